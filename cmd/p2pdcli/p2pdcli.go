@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 
@@ -21,6 +24,8 @@ var (
 	serverAddress       string
 	authenticationToken string
 	serverCertificate   string
+	clientCertificate   string
+	clientKey           string
 )
 
 // Command represent a command that can be invoked.
@@ -43,12 +48,18 @@ func init() {
 		cli.NewLoginCmd(),
 		cli.NewSendMsgCmd(),
 		cli.NewReceiveDlcMsg(),
+		cli.NewCreateInviteCmd(),
+		cli.NewGetAuditEventsCmd(),
+		cli.NewListUsersCmd(),
+		cli.NewRestoreUserCmd(),
 	} {
 		cmd.Init()
 		flagSet := cmd.GetFlagSet()
 		flagSet.StringVar(&serverAddress, "server", "", "The address of the server.")
 		flagSet.StringVar(&serverCertificate, "serverCertificate", "", "The path to the server certificate (optional).")
 		flagSet.StringVar(&authenticationToken, "token", "", "The authentication token.")
+		flagSet.StringVar(&clientCertificate, "clientCertificate", "", "The path to the client certificate used for mutual TLS (optional).")
+		flagSet.StringVar(&clientKey, "clientKey", "", "The path to the client certificate key used for mutual TLS (optional).")
 		commandMap[cmd.Command()] = cmd
 	}
 }
@@ -107,7 +118,7 @@ func getConnection(serverAddress *string, serverCertificate *string) (*grpc.Clie
 		return grpc.Dial(*serverAddress, opts...)
 	}
 
-	creds, err := credentials.NewClientTLSFromFile(*serverCertificate, "")
+	creds, err := getClientCredentials(*serverCertificate)
 
 	if err != nil {
 		return nil, err
@@ -116,3 +127,30 @@ func getConnection(serverAddress *string, serverCertificate *string) (*grpc.Clie
 	opts = append(opts, grpc.WithTransportCredentials(creds))
 	return grpc.Dial(*serverAddress, opts...)
 }
+
+// getClientCredentials returns the transport credentials to use to connect to
+// the server, including the client certificate if one was provided.
+func getClientCredentials(serverCertificate string) (credentials.TransportCredentials, error) {
+	if clientCertificate == "" {
+		return credentials.NewClientTLSFromFile(serverCertificate, "")
+	}
+
+	rootCAs := x509.NewCertPool()
+	pem, err := ioutil.ReadFile(serverCertificate)
+	if err != nil {
+		return nil, err
+	}
+	if !rootCAs.AppendCertsFromPEM(pem) {
+		return nil, errors.New("Could not read server certificate")
+	}
+
+	cert, err := tls.LoadX509KeyPair(clientCertificate, clientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials.NewTLS(&tls.Config{
+		RootCAs:      rootCAs,
+		Certificates: []tls.Certificate{cert},
+	}), nil
+}
@@ -1,18 +1,42 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	stdlog "log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"p2pderivatives-server/internal/database/interceptor"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
+	"p2pderivatives-server/internal/audit"
 	"p2pderivatives-server/internal/authentication"
 	"p2pderivatives-server/internal/common/grpc/methods"
+	"p2pderivatives-server/internal/common/grpc/pbbase"
+	"p2pderivatives-server/internal/common/health"
+	"p2pderivatives-server/internal/common/logging"
+	"p2pderivatives-server/internal/common/metrics"
+	"p2pderivatives-server/internal/common/pow"
+	"p2pderivatives-server/internal/common/reload"
 	"p2pderivatives-server/internal/common/servererror"
 	"p2pderivatives-server/internal/common/token"
+	"p2pderivatives-server/internal/common/tracing"
+	"p2pderivatives-server/internal/database/migration"
+	"p2pderivatives-server/internal/database/sqlite"
+	"p2pderivatives-server/internal/gateway"
 	"p2pderivatives-server/internal/user/usercommon"
 	"p2pderivatives-server/internal/user/usercontroller"
+	"p2pderivatives-server/internal/user/userinterceptor"
 	"p2pderivatives-server/internal/user/userrepository"
 	"p2pderivatives-server/internal/user/userservice"
 
@@ -22,23 +46,64 @@ import (
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_validator "github.com/grpc-ecosystem/go-grpc-middleware/validator"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var (
 	configPath = flag.String("config", "", "Path to the configuration file to use.")
 	appName    = flag.String("appname", "", "The name of the application. Will be use as a prefix for environment variables.")
 	envname    = flag.String("e", "", "environment (ex., \"development\"). Should match with the name of the configuration file.")
-	migrate    = flag.Bool("migrate", false, "If set performs a db migration before starting.")
+	migrate    = flag.Bool("migrate", false, "If set applies the pending db migrations before starting.")
 )
 
+// auditQueueSize is the number of audit events waiting to be stored when
+// they are written asynchronously.
+const auditQueueSize = 1024
+
 // Config contains the configuration parameters for the server.
 type Config struct {
 	Address  string `configkey:"server.address" validate:"required"`
 	TLS      bool   `configkey:"server.tls"`
 	CertFile string `configkey:"server.certfile" validate:"required_with=TLS"`
 	KeyFile  string `configkey:"server.keyfile" validate:"required_with=TLS"`
+	// ClientAuth is one of "none", "optional" or "require" and controls
+	// whether clients are asked for a certificate signed by ClientCAFile.
+	ClientAuth   string `configkey:"server.client_auth" default:"none" validate:"oneof=none optional require"`
+	ClientCAFile string `configkey:"server.client_ca_file"`
+	// MetricsAddress is the address of the HTTP listener exposing Prometheus
+	// metrics on /metrics. Metrics are not exposed if empty.
+	MetricsAddress string `configkey:"server.metrics_address"`
+	// HealthAddress is the address of the HTTP listener exposing the /healthz
+	// liveness and /readyz readiness endpoints. It can be the same as
+	// MetricsAddress. The endpoints are not exposed if empty.
+	HealthAddress string `configkey:"server.health_address"`
+	// HealthCheckInterval is the interval at which the database connectivity
+	// is checked to update the gRPC health status.
+	HealthCheckInterval time.Duration `configkey:"server.health_check_interval,duration" default:"10s"`
+	// GatewayAddress is the address of the HTTP listener serving the REST/JSON
+	// gateway to the gRPC services. It can be the same as HealthAddress or
+	// MetricsAddress. The gateway is not served if empty.
+	GatewayAddress string `configkey:"server.gateway_address"`
+	// GrpcWebAddress is the address of the HTTP listener serving the gRPC
+	// services to gRPC-Web clients. It must differ from the other HTTP
+	// addresses. gRPC-Web is not served if empty.
+	GrpcWebAddress string `configkey:"server.grpc_web_address"`
+	// CORSAllowedOrigins lists the origins of the web applications allowed to
+	// call the gateway and gRPC-Web endpoints. "*" allows all origins.
+	CORSAllowedOrigins []string `configkey:"server.cors_allowed_origins"`
+	// DatabaseDriver selects the database: a PostgreSQL server configured by
+	// the database.* settings, or an embedded SQLite database file configured
+	// by database.sqlite.*.
+	DatabaseDriver string `configkey:"database.driver" default:"postgres" validate:"oneof=postgres sqlite"`
+	// WatchConfig enables reloading the configuration when the configuration
+	// directory or the TLS certificate files change, in addition to SIGHUP.
+	WatchConfig bool `configkey:"server.watch_config"`
 }
 
 func newInitializedLog(config *conf.Configuration) *log.Log {
@@ -49,7 +114,23 @@ func newInitializedLog(config *conf.Configuration) *log.Log {
 	return logger
 }
 
-func newInitializedOrm(config *conf.Configuration, log *log.Log) *orm.ORM {
+// newInitializedOrm opens the database of the configured driver.
+func newInitializedOrm(
+	config *conf.Configuration,
+	serverConfig *Config,
+	log *log.Log) interceptor.DB {
+	if serverConfig.DatabaseDriver == "sqlite" {
+		sqliteConfig := &sqlite.Config{}
+		if err := config.InitializeComponentConfig(sqliteConfig); err != nil {
+			stdlog.Fatalf("Invalid SQLite configuration %v", err)
+		}
+		db, err := sqlite.Open(sqliteConfig, log)
+		if err != nil {
+			stdlog.Fatalf("Could not initialize database %v", err)
+		}
+		return db
+	}
+
 	ormConfig := &orm.Config{}
 	config.InitializeComponentConfig(ormConfig)
 	ormInstance := orm.NewORM(ormConfig, log)
@@ -63,11 +144,20 @@ func newInitializedOrm(config *conf.Configuration, log *log.Log) *orm.ORM {
 }
 
 func newUserService(config *conf.Configuration) (
-	*userservice.Service, *usercommon.Config) {
+	usercommon.ServiceIf, *usercommon.Config) {
 	userConfig := &usercommon.Config{}
 	repo := userrepository.NewRepository()
-	config.InitializeComponentConfig(userConfig)
-	return userservice.NewService(repo, userConfig, &servererror.ServiceError{}), userConfig
+	if err := config.InitializeComponentConfig(userConfig); err != nil {
+		stdlog.Fatalf("Invalid user configuration %v", err)
+	}
+	service, err := userservice.NewService(repo, userConfig, &servererror.ServiceError{})
+	if err != nil {
+		stdlog.Fatalf("Invalid user name pattern %v", err)
+	}
+	if userConfig.UserCacheSize <= 0 {
+		return service, userConfig
+	}
+	return userservice.NewCachedService(service, userConfig), userConfig
 }
 
 func main() {
@@ -95,13 +185,15 @@ func main() {
 	serverConfig := &Config{}
 
 	config.InitializeComponentConfig(serverConfig)
-
-	lis, err := net.Listen("tcp", serverConfig.Address)
-	if err != nil {
-		stdlog.Fatalf("failed to listen: %v", err)
+	// The gateway connects to the gRPC server without a client certificate.
+	if serverConfig.TLS && serverConfig.ClientAuth == "require" &&
+		serverConfig.GatewayAddress != "" {
+		stdlog.Fatal("The gateway cannot be served with server.client_auth set to require")
 	}
 
 	opts := make([]grpc.ServerOption, 0)
+	var certStore *reload.CertificateStore
+	var tlsConfig *tls.Config
 	if serverConfig.TLS {
 		certFile := serverConfig.CertFile
 		keyFile := serverConfig.KeyFile
@@ -111,18 +203,43 @@ func main() {
 		if keyFile == "" {
 			stdlog.Fatal("Need to provide the path to the key file")
 		}
-		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		certStore, err = reload.NewCertificateStore(certFile, keyFile)
+		if err != nil {
+			stdlog.Fatalf("Failed to load certificate %v", err)
+		}
+		tlsConfig, err = newServerTLSConfig(serverConfig, certStore)
 		if err != nil {
 			stdlog.Fatalf("Failed to generate credentials %v", err)
 		}
-		opts = append(opts, grpc.Creds(creds))
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
 	}
 
 	logInstance := newInitializedLog(config)
-	ormInstance := newInitializedOrm(config, logInstance)
+	ormInstance := newInitializedOrm(config, serverConfig, logInstance)
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrateCommand(ormInstance, flag.Args()[1:]); err != nil {
+			stdlog.Fatalf("Migration failed %v", err)
+		}
+		return
+	}
+	healthChecker := health.NewChecker(func(ctx context.Context) error {
+		db, err := ormInstance.GetDB().DB()
+		if err != nil {
+			return err
+		}
+		return db.PingContext(ctx)
+	})
+	serveHTTP(serverConfig, healthChecker, certStore)
 	tokenConfig := &token.Config{}
 	config.InitializeComponentConfig(tokenConfig)
 	token.Init(tokenConfig)
+	certConfig := &token.CertificateConfig{}
+	if err := config.InitializeComponentConfig(certConfig); err != nil {
+		stdlog.Fatalf("Invalid client certificate configuration %v", err)
+	}
+	if err := token.InitCertificateAuth(certConfig); err != nil {
+		stdlog.Fatalf("Invalid client certificate configuration %v", err)
+	}
 
 	if *migrate {
 		err := doMigration(logInstance, ormInstance)
@@ -132,40 +249,486 @@ func main() {
 		}
 	}
 
+	shutdownTracing := initTracing(config, ormInstance)
+	defer shutdownTracing(context.Background())
+
+	retryConfig := &interceptor.RetryConfig{}
+	if err := config.InitializeComponentConfig(retryConfig); err != nil {
+		stdlog.Fatalf("Invalid transaction retry configuration %v", err)
+	}
+	userService, userConfig := newUserService(config)
+	grpc_prometheus.EnableHandlingTimeHistogram()
+	// The outer recovery interceptors convert the panics of the interceptors
+	// into errors, while the inner ones come after the transaction
+	// interceptors so that the transaction of a panicking handler is rolled
+	// back.
 	opts = append(opts, grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
+		otelgrpc.UnaryServerInterceptor(),
+		grpc_prometheus.UnaryServerInterceptor,
+		logging.AccessLogUnaryInterceptor(logInstance.NewEntry()),
+		logging.RequestIDUnaryInterceptor(),
+		logging.RecoveryUnaryInterceptor(),
 		token.UnaryInterceptor(),
 		interceptor.TransactionUnaryServerInterceptor(
 			logInstance.NewEntry(),
 			methods.TxOption,
+			methods.IsolationLevel,
+			retryConfig,
 			ormInstance),
+		userinterceptor.UnaryInterceptor(userService),
 		grpc_validator.UnaryServerInterceptor(),
+		logging.RecoveryUnaryInterceptor(),
 	)), grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
+		otelgrpc.StreamServerInterceptor(),
+		grpc_prometheus.StreamServerInterceptor,
+		logging.AccessLogStreamInterceptor(logInstance.NewEntry()),
+		logging.RequestIDStreamInterceptor(),
+		logging.RecoveryStreamInterceptor(),
 		token.StreamInterceptor(),
 		interceptor.TransactionStreamServerInterceptor(
 			logInstance.NewEntry(),
 			methods.TxOption,
+			methods.IsolationLevel,
+			retryConfig,
 			ormInstance),
+		userinterceptor.StreamInterceptor(userService),
 		grpc_validator.StreamServerInterceptor(),
+		logging.RecoveryStreamInterceptor(),
 	)))
 
-	userService, userConfig := newUserService(config)
 	userController := usercontroller.NewController(userService, userConfig)
+	challenger := newChallenger(config)
+	userController.SetChallenger(challenger)
+	auditRepository := audit.NewRepository(ormInstance.GetDB())
+	auditLogger := newAuditLogger(config, auditRepository)
+	if serverConfig.DatabaseDriver == "sqlite" {
+		auditLogger.EnableAsyncWrites(auditQueueSize)
+	}
+	defer auditLogger.Close()
+	userController.SetAuditLogger(auditLogger)
 	authenticationController := authentication.NewController(userService, userConfig)
+	authenticationController.SetAuditLogger(auditLogger)
+	auditController := audit.NewController(auditRepository, userConfig)
 
 	grpcServer := grpc.NewServer(opts...)
 	usercontroller.RegisterUserServer(grpcServer, userController)
+	usercontroller.RegisterInviteServer(grpcServer, userController)
+	usercontroller.RegisterChallengeServer(grpcServer, userController)
+	usercontroller.RegisterUserAdminServer(grpcServer, userController)
+	usercontroller.RegisterStreamAuthServer(grpcServer, userController)
 	authentication.RegisterAuthenticationServer(
 		grpcServer, authenticationController)
+	audit.RegisterAuditServer(grpcServer, auditController)
+	grpc_prometheus.Register(grpcServer)
+	healthpb.RegisterHealthServer(grpcServer, healthChecker.Server())
 	stdlog.Printf("Ready to listen on %v", serverConfig.Address)
 	methods.Init(grpcServer)
+	methods.SetServiceOption(
+		grpcServer,
+		healthpb.Health_ServiceDesc.ServiceName,
+		&pbbase.OptionBase{
+			IgnoreTokenVerify: true,
+			TxOption:          pbbase.TxOption_NoTx,
+		})
+
+	healthCtx, stopHealthChecks := context.WithCancel(context.Background())
+	defer stopHealthChecks()
+	for name := range grpcServer.GetServiceInfo() {
+		healthChecker.AddServices(name)
+	}
+	healthChecker.SetServing(true)
+	go healthChecker.Run(healthCtx, serverConfig.HealthCheckInterval)
+	go stopOnSignal(grpcServer, healthChecker)
+	serveGrpcWeb(serverConfig, grpcServer, tlsConfig)
+
+	reloadFunc := func() { reloadConfig(logInstance, challenger, certStore) }
+	go reloadOnSignal(reloadFunc)
+	if serverConfig.WatchConfig {
+		go watchConfig(healthCtx, serverConfig, logInstance, reloadFunc)
+	}
+
+	purger := userservice.NewPurger(
+		userService, ormInstance, logInstance.NewEntry(), auditLogger)
+	go purger.Run(healthCtx, userConfig.PurgeInterval)
+
+	lis, err := net.Listen("tcp", serverConfig.Address)
+	if err != nil {
+		stdlog.Fatalf("failed to listen: %v", err)
+	}
 	grpcServer.Serve(lis)
 }
 
-func doMigration(l *log.Log, o *orm.ORM) error {
-	migrator := orm.NewMigrator(
-		o,
-		&usercommon.User{},
-	)
+// stopOnSignal reports the server as not serving and stops it gracefully when
+// an interrupt or termination signal is received.
+func stopOnSignal(grpcServer *grpc.Server, healthChecker *health.Checker) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := <-signals
+	stdlog.Printf("Received %v, shutting down", sig)
+	healthChecker.Shutdown()
+	grpcServer.GracefulStop()
+}
+
+func newChallenger(config *conf.Configuration) *pow.Challenger {
+	powConfig := &pow.Config{}
+	if err := config.InitializeComponentConfig(powConfig); err != nil {
+		stdlog.Fatalf("Invalid proof of work configuration %v", err)
+	}
+	challenger, err := pow.NewChallenger(powConfig)
+	if err != nil {
+		stdlog.Fatalf("Failed to initialize proof of work %v", err)
+	}
+	return challenger
+}
+
+func initTracing(
+	config *conf.Configuration, ormInstance interceptor.DB) func(context.Context) error {
+	tracingConfig := &tracing.Config{}
+	if err := config.InitializeComponentConfig(tracingConfig); err != nil {
+		stdlog.Fatalf("Invalid tracing configuration %v", err)
+	}
+	shutdown, err := tracing.Init(tracingConfig)
+	if err != nil {
+		stdlog.Fatalf("Failed to initialize tracing %v", err)
+	}
+	if tracingConfig.Enabled {
+		if err := ormInstance.GetDB().Use(tracing.GormPlugin{}); err != nil {
+			stdlog.Fatalf("Failed to initialize database tracing %v", err)
+		}
+	}
+	return shutdown
+}
+
+// serveHTTP starts the HTTP listeners exposing the metrics, health and
+// gateway endpoints. The listener of the gateway uses the server certificate
+// if TLS is enabled.
+func serveHTTP(
+	serverConfig *Config,
+	healthChecker *health.Checker,
+	certStore *reload.CertificateStore) {
+	muxes := make(map[string]*http.ServeMux)
+	getMux := func(address string) *http.ServeMux {
+		if muxes[address] == nil {
+			muxes[address] = http.NewServeMux()
+		}
+		return muxes[address]
+	}
+
+	if serverConfig.MetricsAddress != "" {
+		getMux(serverConfig.MetricsAddress).Handle("/metrics", metrics.Handler())
+	}
+	if serverConfig.HealthAddress != "" {
+		mux := getMux(serverConfig.HealthAddress)
+		mux.Handle("/healthz", healthChecker.LivenessHandler())
+		mux.Handle("/readyz", healthChecker.ReadinessHandler())
+	}
+	if serverConfig.GatewayAddress != "" {
+		handler, err := newGatewayHandler(serverConfig, certStore)
+		if err != nil {
+			stdlog.Fatalf("Failed to initialize gateway %v", err)
+		}
+		getMux(serverConfig.GatewayAddress).Handle("/", handler)
+	}
+
+	for address, mux := range muxes {
+		httpServer := &http.Server{Addr: address, Handler: mux}
+		useTLS := certStore != nil && address == serverConfig.GatewayAddress
+		go func() {
+			stdlog.Printf("Serving HTTP on %v", httpServer.Addr)
+			var err error
+			if useTLS {
+				httpServer.TLSConfig = &tls.Config{GetCertificate: certStore.GetCertificate}
+				err = httpServer.ListenAndServeTLS("", "")
+			} else {
+				err = httpServer.ListenAndServe()
+			}
+			if err != nil {
+				stdlog.Fatalf("Failed to serve HTTP %v", err)
+			}
+		}()
+	}
+}
+
+// serveGrpcWeb starts the HTTP listener serving the services of the gRPC
+// server to gRPC-Web clients, with the TLS configuration of the gRPC server if
+// TLS is enabled.
+func serveGrpcWeb(
+	serverConfig *Config,
+	grpcServer *grpc.Server,
+	tlsConfig *tls.Config) {
+	if serverConfig.GrpcWebAddress == "" {
+		return
+	}
+	httpServer := newGrpcWebServer(serverConfig, grpcServer, tlsConfig)
+	go func() {
+		stdlog.Printf("Serving gRPC-Web on %v", serverConfig.GrpcWebAddress)
+		var err error
+		if httpServer.TLSConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil {
+			stdlog.Fatalf("Failed to serve gRPC-Web %v", err)
+		}
+	}()
+}
+
+// newGrpcWebServer returns the HTTP server of the gRPC-Web listener. It
+// verifies client certificates like the gRPC server, as the gRPC-Web handler
+// calls the gRPC handlers directly with the TLS state of the HTTP connection.
+func newGrpcWebServer(
+	serverConfig *Config,
+	grpcServer *grpc.Server,
+	tlsConfig *tls.Config) *http.Server {
+	httpServer := &http.Server{
+		Addr: serverConfig.GrpcWebAddress,
+		Handler: gateway.NewGrpcWebHandler(
+			grpcServer, gateway.NewOriginMatcher(serverConfig.CORSAllowedOrigins)),
+	}
+	if tlsConfig != nil {
+		httpServer.TLSConfig = tlsConfig.Clone()
+	}
+	return httpServer
+}
+
+// newGatewayHandler returns the gateway handler, connected to the gRPC server
+// through the loopback interface.
+func newGatewayHandler(
+	serverConfig *Config,
+	certStore *reload.CertificateStore) (http.Handler, error) {
+	host, port, err := net.SplitHostPort(serverConfig.Address)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	dialOption := grpc.WithInsecure()
+	if certStore != nil {
+		dialOption = grpc.WithTransportCredentials(newLoopbackCredentials(certStore))
+	}
+
+	return gateway.NewHandler(
+		context.Background(),
+		net.JoinHostPort(host, port),
+		[]grpc.DialOption{dialOption},
+		gateway.NewOriginMatcher(serverConfig.CORSAllowedOrigins))
+}
+
+// newLoopbackCredentials returns credentials accepting only the current
+// certificate of the server, which may not be valid for the loopback address.
+func newLoopbackCredentials(certStore *reload.CertificateStore) credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		// The certificate is pinned by VerifyPeerCertificate.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			cert := certStore.Certificate()
+			if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], cert.Certificate[0]) {
+				return errors.New("unexpected server certificate")
+			}
+			return nil
+		},
+	})
+}
+
+func newAuditLogger(
+	config *conf.Configuration, repository *audit.Repository) *audit.Logger {
+	auditConfig := &audit.Config{}
+	if err := config.InitializeComponentConfig(auditConfig); err != nil {
+		stdlog.Fatalf("Invalid audit configuration %v", err)
+	}
+	auditLogger, err := audit.NewLogger(auditConfig, repository)
+	if err != nil {
+		stdlog.Fatalf("Failed to initialize audit log %v", err)
+	}
+	return auditLogger
+}
 
-	return migrator.Initialize()
+// newServerTLSConfig returns the TLS configuration of the gRPC server and of
+// the gRPC-Web listener, using the current certificate of the store for each
+// new connection.
+func newServerTLSConfig(
+	serverConfig *Config,
+	certStore *reload.CertificateStore) (*tls.Config, error) {
+	if serverConfig.ClientAuth == "" || serverConfig.ClientAuth == "none" {
+		return &tls.Config{
+			GetCertificate: certStore.GetCertificate,
+		}, nil
+	}
+
+	if serverConfig.ClientCAFile == "" {
+		return nil, errors.New("client authentication requires a client CA file")
+	}
+	caBundle, err := ioutil.ReadFile(serverConfig.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caBundle) {
+		return nil, errors.Errorf(
+			"no certificate found in %s", serverConfig.ClientCAFile)
+	}
+
+	clientAuth := tls.VerifyClientCertIfGiven
+	if serverConfig.ClientAuth == "require" {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &tls.Config{
+		GetCertificate: certStore.GetCertificate,
+		ClientCAs:      clientCAs,
+		ClientAuth:     clientAuth,
+	}, nil
+}
+
+// reloadLock prevents concurrent reloads of the configuration.
+var reloadLock sync.Mutex
+
+// reloadConfig reads the configuration again and applies the settings that
+// can be changed without restarting the server: the log level and format, the
+// token and client certificate authentication settings, the proof of work
+// settings and the TLS certificate. The current settings are kept if the
+// configuration is invalid.
+func reloadConfig(
+	logInstance *log.Log,
+	challenger *pow.Challenger,
+	certStore *reload.CertificateStore) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	logger := logInstance.Logger
+
+	config := conf.NewConfiguration(*appName, *envname, []string{*configPath})
+	if err := config.Initialize(); err != nil {
+		logger.Errorf("Failed to reload configuration: %v", err)
+		return
+	}
+	logConfig := &log.Config{}
+	tokenConfig := &token.Config{}
+	certConfig := &token.CertificateConfig{}
+	powConfig := &pow.Config{}
+	for _, componentConfig := range []interface{}{
+		logConfig, tokenConfig, certConfig, powConfig} {
+		if err := config.InitializeComponentConfig(componentConfig); err != nil {
+			logger.Errorf("Failed to reload configuration: %v", err)
+			return
+		}
+	}
+	if err := applyLogConfig(logger, logConfig); err != nil {
+		logger.Errorf("Failed to reload configuration: %v", err)
+		return
+	}
+
+	if err := token.InitCertificateAuth(certConfig); err != nil {
+		logger.Errorf("Failed to reload configuration: %v", err)
+		return
+	}
+	token.Init(tokenConfig)
+	challenger.UpdateConfig(powConfig)
+	if certStore != nil {
+		if err := certStore.Reload(); err != nil {
+			logger.Errorf("Failed to reload certificate: %v", err)
+		}
+	}
+	logger.Info("Configuration reloaded")
+}
+
+// applyLogConfig applies the level and format of the given configuration to
+// the logger. Other log settings require a restart.
+func applyLogConfig(logger *logrus.Logger, logConfig *log.Config) error {
+	level, err := logrus.ParseLevel(logConfig.LogLevel)
+	if err != nil {
+		return err
+	}
+	var formatter logrus.Formatter
+	switch logConfig.LogFormat {
+	case "json":
+		formatter = &logrus.JSONFormatter{}
+	case "text":
+		formatter = &logrus.TextFormatter{FullTimestamp: true, QuoteEmptyFields: true}
+	default:
+		return errors.Errorf("invalid log format %s", logConfig.LogFormat)
+	}
+	logger.SetFormatter(formatter)
+	logger.SetLevel(level)
+	return nil
+}
+
+func reloadOnSignal(reloadFunc func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		reloadFunc()
+	}
+}
+
+// watchConfig reloads the configuration when the configuration directory or
+// the certificate files change.
+func watchConfig(
+	ctx context.Context,
+	serverConfig *Config,
+	logInstance *log.Log,
+	reloadFunc func()) {
+	paths := []string{*configPath}
+	if serverConfig.TLS {
+		paths = append(paths, serverConfig.CertFile, serverConfig.KeyFile)
+	}
+	if err := reload.Watch(ctx, paths, time.Second, reloadFunc); err != nil {
+		logInstance.Logger.Errorf("Stopped watching configuration: %v", err)
+	}
+}
+
+func doMigration(l *log.Log, o interceptor.DB) error {
+	applied, err := migration.NewMigrator(o.GetDB(), migration.Migrations).Up()
+	for _, m := range applied {
+		l.Logger.Infof("Applied migration %d_%s", m.Version, m.Name)
+	}
+	return err
+}
+
+// runMigrateCommand runs the migrate subcommand: "up" applies the pending
+// migrations, "down [n]" reverts the n latest migrations (1 by default) and
+// "status" lists the migrations.
+func runMigrateCommand(o interceptor.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [n]|status")
+	}
+	migrator := migration.NewMigrator(o.GetDB(), migration.Migrations)
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return errors.Errorf("invalid number of migrations %s", args[1])
+			}
+			steps = n
+		}
+		reverted, err := migrator.Down(steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return nil
+	}
+	return errors.Errorf("unknown migrate command %s", args[0])
 }
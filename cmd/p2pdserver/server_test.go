@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// writeTestCA writes a self-signed CA certificate to the given file.
+func writeTestCA(t *testing.T, caFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(caFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewGrpcWebServer_WithClientAuthRequired_RequiresClientCertificate(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	writeTestCA(t, caFile)
+	serverConfig := &Config{
+		ClientAuth:     "require",
+		ClientCAFile:   caFile,
+		GrpcWebAddress: ":0",
+	}
+	tlsConfig, err := newServerTLSConfig(serverConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	httpServer := newGrpcWebServer(serverConfig, grpc.NewServer(), tlsConfig)
+
+	// Assert
+	if assert.NotNil(httpServer.TLSConfig) {
+		assert.Equal(tls.RequireAndVerifyClientCert, httpServer.TLSConfig.ClientAuth)
+		assert.NotNil(httpServer.TLSConfig.ClientCAs)
+	}
+}
+
+func TestNewGrpcWebServer_WithClientAuthOptional_VerifiesGivenCertificates(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	writeTestCA(t, caFile)
+	serverConfig := &Config{
+		ClientAuth:     "optional",
+		ClientCAFile:   caFile,
+		GrpcWebAddress: ":0",
+	}
+	tlsConfig, err := newServerTLSConfig(serverConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Act
+	httpServer := newGrpcWebServer(serverConfig, grpc.NewServer(), tlsConfig)
+
+	// Assert
+	if assert.NotNil(httpServer.TLSConfig) {
+		assert.Equal(tls.VerifyClientCertIfGiven, httpServer.TLSConfig.ClientAuth)
+		assert.NotNil(httpServer.TLSConfig.ClientCAs)
+	}
+}
+
+func TestNewServerTLSConfig_WithClientAuthAndNoCAFile_Fails(t *testing.T) {
+	// Act
+	_, err := newServerTLSConfig(&Config{ClientAuth: "require"}, nil)
+
+	// Assert
+	assert.Error(t, err)
+}
@@ -0,0 +1,13 @@
+package audit
+
+// Config contains the configuration of the audit log.
+type Config struct {
+	Enabled bool `configkey:"app.audit.enabled" default:"true"`
+	// File is the path of a file to which events are appended as JSON lines,
+	// in addition to the database. No file is written if empty.
+	File string `configkey:"app.audit.file"`
+	// TrustedProxies lists the IP addresses or CIDR ranges of the reverse
+	// proxies in front of the gateway, whose X-Forwarded-For entries are
+	// trusted. Only the address of the gateway client is trusted if empty.
+	TrustedProxies []string `configkey:"app.audit.trusted_proxies"`
+}
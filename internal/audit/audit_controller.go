@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"p2pderivatives-server/internal/common/contexts"
+	"p2pderivatives-server/internal/common/servererror"
+	"p2pderivatives-server/internal/user/usercommon"
+	"time"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Controller represents the grpc server serving the audit log queries.
+type Controller struct {
+	repository *Repository
+	userConfig *usercommon.Config
+}
+
+// NewController creates a new Controller struct.
+func NewController(
+	repository *Repository, userConfig *usercommon.Config) *Controller {
+	return &Controller{repository: repository, userConfig: userConfig}
+}
+
+// ListAuditEvents returns the audit events matching the request, most recent
+// first. Only administrators can query the audit log.
+func (controller *Controller) ListAuditEvents(
+	request *AuditEventsRequest, stream Audit_ListAuditEventsServer) error {
+	ctx := stream.Context()
+	adminID, ok := contexts.LookupUserID(ctx)
+	if !ok {
+		return servererror.GetGrpcStatus(ctx, servererror.NewErrorWithDetail(
+			servererror.UnauthenticatedError, "Unauthenticated request.",
+			nil, servererror.ErrorDetailCodeTokenRequired, nil)).Err()
+	}
+	if !controller.userConfig.IsAdmin(adminID) {
+		return servererror.GetGrpcStatus(ctx, servererror.NewErrorWithDetail(
+			servererror.PermissionDenied, "Only administrators can query the audit log.",
+			nil, servererror.ErrorDetailCodeAdminRequired, nil)).Err()
+	}
+
+	filter := &Filter{
+		UserID: request.UserId,
+		Type:   EventType(request.Type),
+	}
+	if request.Since > 0 {
+		filter.Since = time.Unix(request.Since, 0)
+	}
+	if request.Until > 0 {
+		filter.Until = time.Unix(request.Until, 0)
+	}
+	limit := int(request.Limit)
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	events, err := controller.repository.FindEvents(
+		ctx, filter, int(request.Offset), limit)
+	if err != nil {
+		return servererror.GetGrpcStatus(ctx, servererror.NewErrorWithDetail(
+			servererror.DbError, "Failed to find audit events.",
+			err, servererror.ErrorDetailCodeUnknown, nil)).Err()
+	}
+
+	for _, event := range events {
+		if err := stream.Send(eventToProto(&event)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func eventToProto(event *Event) *AuditEvent {
+	return &AuditEvent{
+		Type:      string(event.Type),
+		UserId:    event.UserID,
+		UserName:  event.UserName,
+		SourceIp:  event.SourceIP,
+		Success:   event.Success,
+		Detail:    event.Detail,
+		CreatedAt: event.CreatedAt.Unix(),
+	}
+}
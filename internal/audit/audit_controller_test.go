@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"p2pderivatives-server/internal/common/contexts"
+	"p2pderivatives-server/internal/user/usercommon"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type listEventsStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events []*AuditEvent
+}
+
+func (s *listEventsStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *listEventsStream) Send(event *AuditEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func createController() (controller *Controller, repo *Repository, cleanup func()) {
+	repo, tx := createRepoAndTx()
+	config := usercommon.DefaultUserConfiguration()
+	config.AdminIDs = []string{"admin1"}
+	controller = NewController(repo, config)
+	cleanup = func() { tx.Rollback() }
+	return
+}
+
+func TestControllerListAuditEvents_AsAdmin_ReturnsEvents(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller, repo, cleanup := createController()
+	defer cleanup()
+	repo.CreateEvent(context.Background(), &Event{Type: EventLogin, UserID: "id1"})
+	repo.CreateEvent(context.Background(), &Event{Type: EventLogout, UserID: "id2"})
+	stream := &listEventsStream{
+		ctx: contexts.SetUserID(context.Background(), "admin1"),
+	}
+
+	// Act
+	err := controller.ListAuditEvents(&AuditEventsRequest{UserId: "id1"}, stream)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(stream.events, 1)
+	assert.Equal(string(EventLogin), stream.events[0].Type)
+}
+
+func TestControllerListAuditEvents_AsUser_Fails(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller, _, cleanup := createController()
+	defer cleanup()
+	stream := &listEventsStream{
+		ctx: contexts.SetUserID(context.Background(), "id1"),
+	}
+
+	// Act
+	err := controller.ListAuditEvents(&AuditEventsRequest{}, stream)
+
+	// Assert
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+	assert.Empty(stream.events)
+}
+
+func TestControllerListAuditEvents_WithoutUser_ReturnsUnauthenticated(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller, _, cleanup := createController()
+	defer cleanup()
+	stream := &listEventsStream{ctx: context.Background()}
+
+	// Act
+	err := controller.ListAuditEvents(&AuditEventsRequest{}, stream)
+
+	// Assert
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+	assert.Empty(stream.events)
+}
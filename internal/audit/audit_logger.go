@@ -0,0 +1,224 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"p2pderivatives-server/internal/database/interceptor"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus/ctxlogrus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Logger records audit events to the database and optionally to a JSON lines
+// file. A nil Logger records nothing.
+type Logger struct {
+	repository *Repository
+	file       *os.File
+	fileLock   sync.Mutex
+	queue      chan queuedEvent
+	done       chan struct{}
+	// trustedProxies are the networks of the proxies in front of the gateway.
+	trustedProxies []*net.IPNet
+}
+
+type queuedEvent struct {
+	log   *logrus.Entry
+	event *Event
+}
+
+// NewLogger creates a new Logger from the given configuration. Returns nil if
+// the audit log is disabled.
+func NewLogger(config *Config, repository *Repository) (*Logger, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	logger := &Logger{repository: repository}
+	for _, proxy := range config.TrustedProxies {
+		network, err := parseNetwork(proxy)
+		if err != nil {
+			return nil, err
+		}
+		logger.trustedProxies = append(logger.trustedProxies, network)
+	}
+	if config.File != "" {
+		file, err := os.OpenFile(
+			config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		logger.file = file
+	}
+
+	return logger, nil
+}
+
+// Record stores the given event, setting its source IP and time. Failures are
+// logged but not returned so that they do not affect the audited request.
+// Within a transaction, the event is stored when the transaction ends, so that
+// it is stored only once if the transaction is run again, and as a failure if
+// the transaction is not committed.
+func (logger *Logger) Record(ctx context.Context, event *Event) {
+	if logger == nil {
+		return
+	}
+
+	interceptor.OnCompletion(ctx, func(committed bool) {
+		event.Success = event.Success && committed
+		logger.store(ctx, event)
+	})
+}
+
+// store stores the given event, setting its source IP and time.
+func (logger *Logger) store(ctx context.Context, event *Event) {
+	event.SourceIP = logger.sourceIP(ctx)
+	event.CreatedAt = time.Now().UTC()
+
+	log := ctxlogrus.Extract(ctx)
+	if logger.queue != nil {
+		select {
+		case logger.queue <- queuedEvent{log: log, event: event}:
+		default:
+			log.Errorf("failed to store audit event %s: queue is full", event.Type)
+		}
+	} else if err := logger.repository.CreateEvent(ctx, event); err != nil {
+		log.Errorf("failed to store audit event %s: %v", event.Type, err)
+	}
+	if err := logger.writeFile(event); err != nil {
+		log.Errorf("failed to write audit event %s: %v", event.Type, err)
+	}
+}
+
+// EnableAsyncWrites makes Record store the events in the database from a
+// background goroutine, queuing up to queueSize events. This is needed with
+// databases allowing a single writer such as SQLite, on which storing the
+// event would otherwise wait for the transaction of the audited request.
+func (logger *Logger) EnableAsyncWrites(queueSize int) {
+	if logger == nil || logger.queue != nil {
+		return
+	}
+	logger.queue = make(chan queuedEvent, queueSize)
+	logger.done = make(chan struct{})
+	go func() {
+		defer close(logger.done)
+		for queued := range logger.queue {
+			err := logger.repository.CreateEvent(context.Background(), queued.event)
+			if err != nil {
+				queued.log.Errorf(
+					"failed to store audit event %s: %v", queued.event.Type, err)
+			}
+		}
+	}()
+}
+
+// Close stores the queued events and closes the audit file if any. Events
+// must not be recorded after Close.
+func (logger *Logger) Close() error {
+	if logger == nil {
+		return nil
+	}
+	if logger.queue != nil {
+		close(logger.queue)
+		<-logger.done
+	}
+	if logger.file == nil {
+		return nil
+	}
+	return logger.file.Close()
+}
+
+func (logger *Logger) writeFile(event *Event) error {
+	if logger.file == nil {
+		return nil
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	logger.fileLock.Lock()
+	defer logger.fileLock.Unlock()
+	_, err = logger.file.Write(append(line, '\n'))
+	return err
+}
+
+// sourceIP returns the address of the client of the request. Requests relayed
+// by the REST gateway come from the loopback interface, the gateway appending
+// the address of its client to the X-Forwarded-For entries sent by the client.
+// The last entry is used, or the last one not sent by a trusted proxy.
+func (logger *Logger) sourceIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return host
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return host
+	}
+	var entries []string
+	for _, value := range md.Get("x-forwarded-for") {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return host
+	}
+	for i := len(entries) - 1; i > 0; i-- {
+		if !logger.isTrustedProxy(entries[i]) {
+			return entries[i]
+		}
+	}
+	return entries[0]
+}
+
+func (logger *Logger) isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range logger.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetwork parses the given IP address or CIDR range.
+func parseNetwork(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid trusted proxy address %q", value)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy range %q", value)
+	}
+	return network, nil
+}
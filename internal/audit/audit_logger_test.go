@@ -0,0 +1,196 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"p2pderivatives-server/test"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"gorm.io/gorm"
+)
+
+// createRepoAndTx creates a new DB transaction and an audit repository using
+// it.
+func createRepoAndTx() (repo *Repository, tx *gorm.DB) {
+	ormInstance := test.InitializeORM(&Event{})
+	tx = ormInstance.GetDB().Begin()
+	repo = NewRepository(tx)
+	return
+}
+
+func newPeerContext(address string) context.Context {
+	addr, _ := net.ResolveTCPAddr("tcp", address)
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+}
+
+func TestLoggerRecord_StoresEventWithSourceIP(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, tx := createRepoAndTx()
+	defer tx.Rollback()
+	logger, _ := NewLogger(&Config{Enabled: true}, repo)
+	ctx := newPeerContext("192.0.2.1:51234")
+
+	// Act
+	logger.Record(ctx, &Event{Type: EventLogin, UserID: "id1", Success: true})
+
+	// Assert
+	events, err := repo.FindEvents(context.Background(), &Filter{}, 0, 10)
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal(EventLogin, events[0].Type)
+	assert.Equal("id1", events[0].UserID)
+	assert.Equal("192.0.2.1", events[0].SourceIP)
+	assert.True(events[0].Success)
+	assert.False(events[0].CreatedAt.IsZero())
+}
+
+func recordForwarded(config *Config, forwarded ...string) (string, error) {
+	repo, tx := createRepoAndTx()
+	defer tx.Rollback()
+	logger, err := NewLogger(config, repo)
+	if err != nil {
+		return "", err
+	}
+	md := metadata.MD{}
+	md.Append("x-forwarded-for", forwarded...)
+	ctx := metadata.NewIncomingContext(newPeerContext("127.0.0.1:51234"), md)
+	logger.Record(ctx, &Event{Type: EventLogin, UserID: "id1", Success: true})
+	events, err := repo.FindEvents(context.Background(), &Filter{}, 0, 10)
+	if err != nil || len(events) != 1 {
+		return "", err
+	}
+	return events[0].SourceIP, nil
+}
+
+func TestLoggerRecord_FromGateway_StoresAddressAppendedByGateway(t *testing.T) {
+	// Act
+	// The first entry is sent by the client, the last one by the gateway.
+	sourceIP, err := recordForwarded(
+		&Config{Enabled: true}, "203.0.113.9, 198.51.100.7", "192.0.2.1")
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", sourceIP)
+}
+
+func TestLoggerRecord_FromGatewayBehindTrustedProxies_StoresProxiedAddress(t *testing.T) {
+	// Act
+	sourceIP, err := recordForwarded(
+		&Config{Enabled: true, TrustedProxies: []string{"10.0.0.0/8", "198.51.100.7"}},
+		"203.0.113.9, 192.0.2.1, 198.51.100.7", "10.1.2.3")
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1", sourceIP)
+}
+
+func TestNewLogger_WithInvalidTrustedProxy_Fails(t *testing.T) {
+	// Act
+	_, err := NewLogger(
+		&Config{Enabled: true, TrustedProxies: []string{"proxy"}}, nil)
+
+	// Assert
+	assert.Error(t, err)
+}
+
+func TestLoggerRecord_WithFile_AppendsJSONLines(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, tx := createRepoAndTx()
+	defer tx.Rollback()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := NewLogger(&Config{Enabled: true, File: path}, repo)
+	assert.NoError(err)
+
+	// Act
+	logger.Record(context.Background(), &Event{Type: EventLoginFailure, UserName: "alice"})
+	logger.Record(context.Background(), &Event{Type: EventLogin, UserID: "id1", Success: true})
+	logger.Close()
+
+	// Assert
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(lines, 2)
+	var event Event
+	assert.NoError(json.Unmarshal([]byte(lines[0]), &event))
+	assert.Equal(EventLoginFailure, event.Type)
+	assert.Equal("alice", event.UserName)
+	assert.False(event.Success)
+}
+
+func TestLoggerRecord_AsyncWrites_StoresEventOnClose(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, tx := createRepoAndTx()
+	defer tx.Rollback()
+	logger, _ := NewLogger(&Config{Enabled: true}, repo)
+	logger.EnableAsyncWrites(10)
+
+	// Act
+	logger.Record(context.Background(), &Event{Type: EventLogin, UserID: "id1", Success: true})
+	logger.Close()
+
+	// Assert
+	events, err := repo.FindEvents(context.Background(), &Filter{}, 0, 10)
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal("id1", events[0].UserID)
+}
+
+func TestLoggerRecord_Disabled_DoesNothing(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, tx := createRepoAndTx()
+	defer tx.Rollback()
+
+	// Act
+	logger, err := NewLogger(&Config{Enabled: false}, repo)
+	logger.Record(context.Background(), &Event{Type: EventLogin})
+
+	// Assert
+	assert.NoError(err)
+	assert.Nil(logger)
+	events, _ := repo.FindEvents(context.Background(), &Filter{}, 0, 10)
+	assert.Empty(events)
+}
+
+func TestRepositoryFindEvents_WithFilter_ReturnsMatchingEvents(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, tx := createRepoAndTx()
+	defer tx.Rollback()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	events := []*Event{
+		{Type: EventLogin, UserID: "id1", CreatedAt: now.Add(-2 * time.Hour)},
+		{Type: EventLoginFailure, UserID: "id1", CreatedAt: now.Add(-time.Hour)},
+		{Type: EventLogin, UserID: "id1", CreatedAt: now},
+		{Type: EventLogin, UserID: "id2", CreatedAt: now},
+	}
+	for _, event := range events {
+		repo.CreateEvent(ctx, event)
+	}
+
+	// Act
+	result, err := repo.FindEvents(
+		ctx,
+		&Filter{UserID: "id1", Type: EventLogin, Since: now.Add(-90 * time.Minute)},
+		0,
+		10)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(result, 1)
+	assert.Equal(events[2].ID, result[0].ID)
+}
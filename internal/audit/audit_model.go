@@ -0,0 +1,56 @@
+package audit
+
+import "time"
+
+// EventType is the type of an audited event.
+type EventType string
+
+const (
+	// EventRegistration is recorded when a user registers.
+	EventRegistration EventType = "registration"
+	// EventLogin is recorded when a user logs in.
+	EventLogin EventType = "login"
+	// EventLoginFailure is recorded when a login attempt fails.
+	EventLoginFailure EventType = "login_failure"
+	// EventTokenRefresh is recorded when a user refreshes its access token.
+	EventTokenRefresh EventType = "token_refresh"
+	// EventLogout is recorded when a user logs out.
+	EventLogout EventType = "logout"
+	// EventPasswordChange is recorded when a user changes its password.
+	EventPasswordChange EventType = "password_change"
+	// EventDeletion is recorded when a user unregisters.
+	EventDeletion EventType = "deletion"
+	// EventRestoration is recorded when an administrator restores an
+	// unregistered user.
+	EventRestoration EventType = "restoration"
+	// EventPurge is recorded when an unregistered user is permanently deleted
+	// at the end of its deletion grace period.
+	EventPurge EventType = "purge"
+)
+
+// Event represents an audited authentication or account event. Events are
+// only ever inserted.
+type Event struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Type      EventType `gorm:"size:32; index" json:"type"`
+	UserID    string    `gorm:"size:255; index" json:"user_id,omitempty"`
+	UserName  string    `gorm:"size:255" json:"user_name,omitempty"`
+	SourceIP  string    `gorm:"size:64" json:"source_ip,omitempty"`
+	Success   bool      `json:"success"`
+	Detail    string    `gorm:"size:255" json:"detail,omitempty"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// TableName returns the name of the table storing the events.
+func (Event) TableName() string {
+	return "audit_events"
+}
+
+// Filter contains the conditions used to query events. Empty fields are
+// ignored.
+type Filter struct {
+	UserID string
+	Type   EventType
+	Since  time.Time
+	Until  time.Time
+}
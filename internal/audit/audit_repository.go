@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Repository stores the audit events.
+// Unlike other repositories it does not use the transaction of the request, so
+// that events are kept when the request fails and its transaction is rolled
+// back.
+type Repository struct {
+	db *gorm.DB
+}
+
+// NewRepository creates a new Repository using the given DB object.
+func NewRepository(db *gorm.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// CreateEvent inserts the given event.
+func (repo *Repository) CreateEvent(ctx context.Context, event *Event) error {
+	return repo.db.WithContext(ctx).Create(event).Error
+}
+
+// FindEvents returns the events matching the given filter, most recent first.
+func (repo *Repository) FindEvents(
+	ctx context.Context,
+	filter *Filter,
+	offset int,
+	limit int,
+) (result []Event, err error) {
+	query := repo.db.WithContext(ctx).Model(&Event{})
+	if filter.UserID != "" {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	if offset >= 0 {
+		query = query.Offset(offset)
+	}
+	if limit >= 0 {
+		query = query.Limit(limit)
+	}
+
+	err = query.Order("created_at desc").Order("id desc").Find(&result).Error
+	return
+}
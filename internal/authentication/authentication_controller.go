@@ -2,8 +2,10 @@ package authentication
 
 import (
 	"context"
+	"p2pderivatives-server/internal/audit"
 	"p2pderivatives-server/internal/common/contexts"
 	"p2pderivatives-server/internal/common/servererror"
+	"p2pderivatives-server/internal/common/token"
 	"p2pderivatives-server/internal/user/usercommon"
 
 	"github.com/cryptogarageinc/server-common-go/pkg/grpc/log"
@@ -16,6 +18,7 @@ import (
 type Controller struct {
 	userService usercommon.ServiceIf
 	userConfig  *usercommon.Config
+	auditLogger *audit.Logger
 }
 
 // NewController returns a new Controller
@@ -23,6 +26,11 @@ func NewController(userService usercommon.ServiceIf, userConfig *usercommon.Conf
 	return &Controller{userService: userService, userConfig: userConfig}
 }
 
+// SetAuditLogger sets the logger used to record authentication events.
+func (s *Controller) SetAuditLogger(auditLogger *audit.Logger) {
+	s.auditLogger = auditLogger
+}
+
 // Login enables a user to login to the system.
 func (s *Controller) Login(
 	ctx context.Context,
@@ -32,10 +40,20 @@ func (s *Controller) Login(
 	log.Info("Login Request")
 	user, userToken, serr := s.userService.AuthenticateUser(ctx, req.Name, req.Password)
 	if serr != nil {
+		s.auditLogger.Record(ctx, &audit.Event{
+			Type:     audit.EventLoginFailure,
+			UserName: req.Name,
+		})
 		return nil, servererror.GetGrpcStatus(ctx, serr).Err()
 	}
 
 	log.Info("Login Success")
+	s.auditLogger.Record(ctx, &audit.Event{
+		Type:     audit.EventLogin,
+		UserID:   user.ID,
+		UserName: user.Name,
+		Success:  true,
+	})
 	return &LoginResponse{
 		Name: user.Name,
 		Token: &TokenInfo{
@@ -54,8 +72,16 @@ func (s *Controller) Refresh(
 
 	tokenInfo, sErr := s.userService.RefreshUserToken(ctx, req.RefreshToken)
 	if sErr != nil {
+		s.auditLogger.Record(ctx, &audit.Event{Type: audit.EventTokenRefresh})
 		return nil, servererror.GetGrpcStatus(ctx, sErr).Err()
 	}
+	// The subject of the new access token is the ID of the user.
+	userID, _ := token.VerifyToken(tokenInfo.AccessToken)
+	s.auditLogger.Record(ctx, &audit.Event{
+		Type:    audit.EventTokenRefresh,
+		UserID:  userID,
+		Success: true,
+	})
 	return &RefreshResponse{
 		Token: &TokenInfo{
 			AccessToken:  tokenInfo.AccessToken,
@@ -72,10 +98,17 @@ func (s *Controller) Logout(
 	logger := ctxlogrus.Extract(ctx)
 	logger.Info("Logout Request")
 
+	event := &audit.Event{
+		Type:   audit.EventLogout,
+		UserID: s.findRefreshTokenUserID(ctx, req.RefreshToken),
+	}
 	if err := s.userService.RevokeRefreshToken(ctx, req.RefreshToken); err != nil {
 		// Proceed even if token validation fails.
 		logger.Infof("failed to revoke RefreshToken. err:%v", err)
+	} else {
+		event.Success = true
 	}
+	s.auditLogger.Record(ctx, event)
 
 	logger.Info("Logout Success")
 	return &Empty{}, nil
@@ -85,12 +118,41 @@ func (s *Controller) Logout(
 func (s *Controller) UpdatePassword(
 	ctx context.Context,
 	request *UpdatePasswordRequest) (*Empty, error) {
-	userID := contexts.GetUserID(ctx)
+	userID, ok := contexts.LookupUserID(ctx)
+	if !ok {
+		return nil, servererror.GetGrpcStatus(ctx, servererror.NewErrorWithDetail(
+			servererror.UnauthenticatedError, "Unauthenticated request.",
+			nil, servererror.ErrorDetailCodeTokenRequired, nil)).Err()
+	}
 
 	_, err := s.userService.ChangeUserPassword(ctx, userID, request.NewPassword, request.OldPassword)
+	s.auditLogger.Record(ctx, &audit.Event{
+		Type:    audit.EventPasswordChange,
+		UserID:  userID,
+		Success: err == nil,
+	})
 	if err != nil {
 		return nil, servererror.GetGrpcStatus(ctx, err).Err()
 	}
 
 	return &Empty{}, nil
 }
+
+// findRefreshTokenUserID returns the ID of the user owning the given refresh
+// token, or an empty string if it is not found. Only used for auditing.
+func (s *Controller) findRefreshTokenUserID(
+	ctx context.Context, refreshToken string) string {
+	if s.auditLogger == nil {
+		return ""
+	}
+	refreshTokenID, err := token.VerifyToken(refreshToken)
+	if err != nil {
+		return ""
+	}
+	user, err := s.userService.FindFirstUser(
+		ctx, &usercommon.User{RefreshToken: refreshTokenID}, nil)
+	if err != nil {
+		return ""
+	}
+	return user.ID
+}
@@ -8,18 +8,27 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
+	"p2pderivatives-server/internal/audit"
 	"p2pderivatives-server/internal/common/contexts"
+	"p2pderivatives-server/internal/common/servererror"
 	"p2pderivatives-server/internal/common/token"
 	"p2pderivatives-server/internal/user/usercommon"
+	"p2pderivatives-server/internal/user/userservice"
 	"p2pderivatives-server/test"
 	"p2pderivatives-server/test/mocks/mock_usercommon"
+	"p2pderivatives-server/test/mocks/mock_userrepository"
 	"p2pderivatives-server/test/mocks/mock_userservice"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
 	name        = "test"
 	password    = "p@ssw0rd"
 	badPassword = "p@sw0rd"
+	// validPassword meets the password policy of the user service.
+	validPassword = "P@ssw0rd"
 )
 
 var userID string
@@ -71,6 +80,33 @@ func TestAuthenticationLogin_WithIncorrectParameters_Fails(t *testing.T) {
 	assert.Nil(response)
 }
 
+func TestAuthenticationLogin_WithIncorrectParameters_RecordsFailure(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, config, service := initService()
+	tx := test.InitializeORM(&audit.Event{}).GetDB().Begin()
+	defer tx.Rollback()
+	auditRepository := audit.NewRepository(tx)
+	auditLogger, _ := audit.NewLogger(&audit.Config{Enabled: true}, auditRepository)
+	controller := NewController(service, config)
+	controller.SetAuditLogger(auditLogger)
+	request := &LoginRequest{
+		Name:     name,
+		Password: badPassword,
+	}
+
+	// Act
+	controller.Login(ctx, request)
+
+	// Assert
+	events, err := auditRepository.FindEvents(ctx, &audit.Filter{}, 0, 10)
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal(audit.EventLoginFailure, events[0].Type)
+	assert.Equal(name, events[0].UserName)
+	assert.False(events[0].Success)
+}
+
 func TestAuthenticationRefresh_WithCorrectToken_Succeeds(t *testing.T) {
 	// Arrange
 	assert := assert.New(t)
@@ -214,3 +250,111 @@ func TestChangeUserPassword_WithInvalidPassword_Fails(t *testing.T) {
 	// Assert
 	assert.Error(err)
 }
+
+// initUserService returns a user service backed by a repository mock, with a
+// user whose password meets the password policy.
+func initUserService() (context.Context, *usercommon.Config, usercommon.ServiceIf) {
+	ctx, userConfig, _ := initService()
+	userService, _ := userservice.NewService(
+		mock_userrepository.NewRepositoryMock(), userConfig, &servererror.ServiceError{})
+	user, _ := userService.CreateUser(ctx, usercommon.NewUser(name, validPassword))
+	userID = user.ID
+	return ctx, userConfig, userService
+}
+
+func assertDetailCode(t *testing.T, err error, code servererror.ErrorDetailCode) {
+	t.Helper()
+	details, ok := servererror.DecodeStatusDetails(err)
+	if assert.True(t, ok) && assert.Len(t, details.Details, 1) {
+		assert.Equal(t, code, details.Details[0].Code)
+	}
+}
+
+func TestAuthenticationLogin_WithIncorrectPassword_ReturnsCredentialsInvalid(t *testing.T) {
+	// Arrange
+	ctx, config, service := initUserService()
+	controller := NewController(service, config)
+
+	// Act
+	_, err := controller.Login(ctx, &LoginRequest{Name: name, Password: badPassword})
+
+	// Assert
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeCredentialsInvalid)
+}
+
+func TestAuthenticationLogin_WithUnknownName_ReturnsCredentialsInvalid(t *testing.T) {
+	// Arrange
+	ctx, config, service := initUserService()
+	controller := NewController(service, config)
+
+	// Act
+	_, err := controller.Login(ctx, &LoginRequest{Name: "unknown", Password: validPassword})
+
+	// Assert
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeCredentialsInvalid)
+}
+
+func TestAuthenticationRefresh_WithInvalidToken_ReturnsRefreshTokenInvalid(t *testing.T) {
+	// Arrange
+	ctx, config, service := initUserService()
+	controller := NewController(service, config)
+
+	// Act
+	_, err := controller.Refresh(ctx, &RefreshRequest{RefreshToken: "thisIsNotAToken"})
+
+	// Assert
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeRefreshTokenInvalid)
+}
+
+func TestUpdatePassword_WithInvalidNewPassword_ReturnsPasswordInvalid(t *testing.T) {
+	// Arrange
+	ctx, config, service := initUserService()
+	controller := NewController(service, config)
+	ctx = contexts.SetUserID(ctx, userID)
+
+	// Act
+	_, err := controller.UpdatePassword(ctx, &UpdatePasswordRequest{
+		OldPassword: validPassword,
+		NewPassword: "Test",
+	})
+
+	// Assert
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodePasswordInvalid)
+}
+
+func TestUpdatePassword_WithIncorrectOldPassword_ReturnsCredentialsInvalid(t *testing.T) {
+	// Arrange
+	ctx, config, service := initUserService()
+	controller := NewController(service, config)
+	ctx = contexts.SetUserID(ctx, userID)
+
+	// Act
+	_, err := controller.UpdatePassword(ctx, &UpdatePasswordRequest{
+		OldPassword: badPassword,
+		NewPassword: "N3wP@ssw0rd",
+	})
+
+	// Assert
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeCredentialsInvalid)
+}
+
+func TestUpdatePassword_WithoutUser_ReturnsUnauthenticated(t *testing.T) {
+	// Arrange
+	ctx, config, service := initUserService()
+	controller := NewController(service, config)
+
+	// Act
+	_, err := controller.UpdatePassword(ctx, &UpdatePasswordRequest{
+		OldPassword: validPassword,
+		NewPassword: "N3wP@ssw0rd",
+	})
+
+	// Assert
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeTokenRequired)
+}
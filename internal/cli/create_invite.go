@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"p2pderivatives-server/internal/user/usercontroller"
+
+	"google.golang.org/grpc"
+)
+
+// CreateInviteCmd creates an invite code to register new users.
+type CreateInviteCmd struct {
+	cmd      string
+	flagSet  *flag.FlagSet
+	maxUses  *int
+	validity *time.Duration
+}
+
+// NewCreateInviteCmd returns a new CreateInviteCmd struct.
+func NewCreateInviteCmd() *CreateInviteCmd {
+	return &CreateInviteCmd{}
+}
+
+// Command returns the command name.
+func (cmd *CreateInviteCmd) Command() string {
+	return cmd.cmd
+}
+
+// Init initializes the command.
+func (cmd *CreateInviteCmd) Init() {
+	cmd.cmd = "createinvite"
+	cmd.flagSet = flag.NewFlagSet(cmd.cmd, flag.ExitOnError)
+	cmd.maxUses = cmd.flagSet.Int("maxuses", 0, "The number of users that can register with the invite (server default if 0)")
+	cmd.validity = cmd.flagSet.Duration("validity", 0, "The validity of the invite, e.g. 48h (server default if 0)")
+}
+
+// GetFlagSet returns the flag set for this command.
+func (cmd *CreateInviteCmd) GetFlagSet() *flag.FlagSet {
+	return cmd.flagSet
+}
+
+// Do performs the command action.
+func (cmd *CreateInviteCmd) Do(ctx context.Context, conn *grpc.ClientConn) {
+	client := usercontroller.NewInviteClient(conn)
+
+	request := &usercontroller.CreateInviteRequest{
+		MaxUses:  int32(*cmd.maxUses),
+		Validity: int64(cmd.validity.Seconds()),
+	}
+
+	invite, err := client.CreateInvite(ctx, request)
+
+	if err != nil {
+		log.Fatalf("Error creating invite %v", err)
+	}
+
+	log.Println("Invite code: ", invite.Code)
+	log.Println("Max uses: ", invite.MaxUses)
+	log.Println("Expires at: ", time.Unix(invite.ExpiresAt, 0).UTC())
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"time"
+
+	"p2pderivatives-server/internal/audit"
+
+	"google.golang.org/grpc"
+)
+
+// GetAuditEventsCmd lists the events of the audit log.
+type GetAuditEventsCmd struct {
+	cmd       string
+	flagSet   *flag.FlagSet
+	userID    *string
+	eventType *string
+	since     *time.Duration
+	limit     *int
+}
+
+// NewGetAuditEventsCmd returns a new GetAuditEventsCmd struct.
+func NewGetAuditEventsCmd() *GetAuditEventsCmd {
+	return &GetAuditEventsCmd{}
+}
+
+// Command returns the command name.
+func (cmd *GetAuditEventsCmd) Command() string {
+	return cmd.cmd
+}
+
+// Init initializes the command.
+func (cmd *GetAuditEventsCmd) Init() {
+	cmd.cmd = "getauditevents"
+	cmd.flagSet = flag.NewFlagSet(cmd.cmd, flag.ExitOnError)
+	cmd.userID = cmd.flagSet.String("user", "", "Only list the events of this user ID")
+	cmd.eventType = cmd.flagSet.String("type", "", "Only list the events of this type, e.g. login_failure")
+	cmd.since = cmd.flagSet.Duration("since", 0, "Only list the events more recent than this duration, e.g. 24h")
+	cmd.limit = cmd.flagSet.Int("limit", 0, "The maximum number of events to list (server default if 0)")
+}
+
+// GetFlagSet returns the flag set for this command.
+func (cmd *GetAuditEventsCmd) GetFlagSet() *flag.FlagSet {
+	return cmd.flagSet
+}
+
+// Do performs the command action.
+func (cmd *GetAuditEventsCmd) Do(ctx context.Context, conn *grpc.ClientConn) {
+	client := audit.NewAuditClient(conn)
+
+	request := &audit.AuditEventsRequest{
+		UserId: *cmd.userID,
+		Type:   *cmd.eventType,
+		Limit:  int32(*cmd.limit),
+	}
+	if *cmd.since > 0 {
+		request.Since = time.Now().Add(-*cmd.since).Unix()
+	}
+
+	stream, err := client.ListAuditEvents(ctx, request)
+	if err != nil {
+		log.Fatalf("Could not get audit events %v", err)
+	}
+
+	for {
+		event, err := stream.Recv()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			log.Fatalf("Could not get audit events %v", err)
+		}
+		log.Printf("%s %s user=%s name=%s ip=%s success=%v %s",
+			time.Unix(event.CreatedAt, 0).UTC().Format(time.RFC3339),
+			event.Type, event.UserId, event.UserName, event.SourceIp,
+			event.Success, event.Detail)
+	}
+}
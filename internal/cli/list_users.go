@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"time"
+
+	"p2pderivatives-server/internal/user/usercontroller"
+
+	"google.golang.org/grpc"
+)
+
+// ListUsersCmd lists the registered users with their account and activity
+// times.
+type ListUsersCmd struct {
+	cmd           string
+	flagSet       *flag.FlagSet
+	name          *string
+	inactiveSince *time.Duration
+	limit         *int
+}
+
+// NewListUsersCmd returns a new ListUsersCmd struct.
+func NewListUsersCmd() *ListUsersCmd {
+	return &ListUsersCmd{}
+}
+
+// Command returns the command name.
+func (cmd *ListUsersCmd) Command() string {
+	return cmd.cmd
+}
+
+// Init initializes the command.
+func (cmd *ListUsersCmd) Init() {
+	cmd.cmd = "listusers"
+	cmd.flagSet = flag.NewFlagSet(cmd.cmd, flag.ExitOnError)
+	cmd.name = cmd.flagSet.String("name", "", "Only list the user with this name")
+	cmd.inactiveSince = cmd.flagSet.Duration("inactive", 0, "Only list the users that did not log in for this duration, e.g. 2160h")
+	cmd.limit = cmd.flagSet.Int("limit", 0, "The maximum number of users to list (server default if 0)")
+}
+
+// GetFlagSet returns the flag set for this command.
+func (cmd *ListUsersCmd) GetFlagSet() *flag.FlagSet {
+	return cmd.flagSet
+}
+
+// Do performs the command action.
+func (cmd *ListUsersCmd) Do(ctx context.Context, conn *grpc.ClientConn) {
+	client := usercontroller.NewUserAdminClient(conn)
+
+	request := &usercontroller.ListUsersRequest{
+		Name:  *cmd.name,
+		Limit: int32(*cmd.limit),
+	}
+	if *cmd.inactiveSince > 0 {
+		request.InactiveSince = time.Now().Add(-*cmd.inactiveSince).Unix()
+	}
+
+	stream, err := client.ListUsers(ctx, request)
+	if err != nil {
+		log.Fatalf("Could not list users %v", err)
+	}
+
+	for {
+		user, err := stream.Recv()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			log.Fatalf("Could not list users %v", err)
+		}
+		log.Printf("%s name=%s created=%s updated=%s last_login=%s last_seen=%s",
+			user.Id, user.Name, formatUnix(user.CreatedAt),
+			formatUnix(user.UpdatedAt), formatUnix(user.LastLoginAt),
+			formatUnix(user.LastSeenAt))
+	}
+}
+
+// formatUnix formats the given Unix timestamp, "-" if 0.
+func formatUnix(timestamp int64) string {
+	if timestamp == 0 {
+		return "-"
+	}
+	return time.Unix(timestamp, 0).UTC().Format(time.RFC3339)
+}
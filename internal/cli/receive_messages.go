@@ -5,6 +5,7 @@ import (
 	"flag"
 	"io"
 	"log"
+	"time"
 
 	"p2pderivatives-server/internal/user/usercontroller"
 
@@ -45,15 +46,15 @@ func (cmd *ReceiveDlcMsg) GetFlagSet() *flag.FlagSet {
 
 // Do performs the command action.
 func (cmd *ReceiveDlcMsg) Do(ctx context.Context, conn *grpc.ClientConn) {
-	client := usercontroller.NewUserClient(conn)
-	stream, err := client.ReceiveDlcMessages(ctx, &usercontroller.Empty{})
+	client := usercontroller.NewStreamAuthClient(conn)
+	stream, err := client.ReceiveDlcEvents(ctx, &usercontroller.Empty{})
 
 	if err != nil {
 		log.Fatalf("Could not receive dlc messages %v", err)
 	}
 
 	for {
-		message, err := stream.Recv()
+		event, err := stream.Recv()
 
 		if err == io.EOF {
 			break
@@ -62,8 +63,12 @@ func (cmd *ReceiveDlcMsg) Do(ctx context.Context, conn *grpc.ClientConn) {
 		if err != nil {
 			log.Fatalf("%v.ReceiveDlcMsg(_) = _, %v", client, err)
 		}
-		name := message.OrgName
-		payload := string(message.Payload)
-		log.Println("Sender: ", name, " Message: ", payload)
+		if reauth := event.GetReauthRequired(); reauth != nil {
+			log.Println("Access token about to expire, the stream ends unless renewed before ",
+				time.Unix(reauth.ExpiresAt, 0))
+			continue
+		}
+		message := event.GetMessage()
+		log.Println("Sender: ", message.GetOrgName(), " Message: ", string(message.GetPayload()))
 	}
 }
@@ -5,9 +5,11 @@ import (
 	"flag"
 	"log"
 
+	"p2pderivatives-server/internal/common/pow"
 	"p2pderivatives-server/internal/user/usercontroller"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 // RegisterUserCmd registers a user in the system.
@@ -16,6 +18,8 @@ type RegisterUserCmd struct {
 	flagSet  *flag.FlagSet
 	name     *string
 	password *string
+	invite   *string
+	solvePow *bool
 }
 
 // NewRegisterUserCmd returns a new RegisterUserCmd struct.
@@ -34,6 +38,8 @@ func (cmd *RegisterUserCmd) Init() {
 	cmd.flagSet = flag.NewFlagSet(cmd.cmd, flag.ExitOnError)
 	cmd.name = cmd.flagSet.String("name", "", "The name of the user to register")
 	cmd.password = cmd.flagSet.String("password", "", "The password of the user to register")
+	cmd.invite = cmd.flagSet.String("invite", "", "The invite code to register with (optional)")
+	cmd.solvePow = cmd.flagSet.Bool("pow", false, "Solve the registration proof of work challenge")
 }
 
 // GetFlagSet returns the flag set for this command.
@@ -55,6 +61,26 @@ func (cmd *RegisterUserCmd) Do(ctx context.Context, conn *grpc.ClientConn) {
 		Password: *cmd.password,
 	}
 
+	if *cmd.invite != "" {
+		ctx = metadata.AppendToOutgoingContext(
+			ctx, usercontroller.MetaKeyInviteCode, *cmd.invite)
+	}
+
+	if *cmd.solvePow {
+		challengeClient := usercontroller.NewChallengeClient(conn)
+		challenge, err := challengeClient.GetRegistrationChallenge(
+			ctx, &usercontroller.RegistrationChallengeRequest{})
+		if err != nil {
+			log.Fatalf("Error getting registration challenge %v", err)
+		}
+		solution := pow.Solve(
+			challenge.Challenge, *cmd.name, int(challenge.Difficulty))
+		ctx = metadata.AppendToOutgoingContext(
+			ctx,
+			usercontroller.MetaKeyPowChallenge, challenge.Challenge,
+			usercontroller.MetaKeyPowSolution, solution)
+	}
+
 	resp, err := client.RegisterUser(ctx, &request)
 
 	if err != nil {
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"p2pderivatives-server/internal/user/usercontroller"
+
+	"google.golang.org/grpc"
+)
+
+// RestoreUserCmd restores a user deleted less than the deletion grace period
+// ago.
+type RestoreUserCmd struct {
+	cmd     string
+	flagSet *flag.FlagSet
+	name    *string
+}
+
+// NewRestoreUserCmd returns a new RestoreUserCmd struct.
+func NewRestoreUserCmd() *RestoreUserCmd {
+	return &RestoreUserCmd{}
+}
+
+// Command returns the command name.
+func (cmd *RestoreUserCmd) Command() string {
+	return cmd.cmd
+}
+
+// Init initializes the command.
+func (cmd *RestoreUserCmd) Init() {
+	cmd.cmd = "restoreuser"
+	cmd.flagSet = flag.NewFlagSet(cmd.cmd, flag.ExitOnError)
+	cmd.name = cmd.flagSet.String("name", "", "The name of the deleted user to restore")
+}
+
+// GetFlagSet returns the flag set for this command.
+func (cmd *RestoreUserCmd) GetFlagSet() *flag.FlagSet {
+	return cmd.flagSet
+}
+
+// Do performs the command action.
+func (cmd *RestoreUserCmd) Do(ctx context.Context, conn *grpc.ClientConn) {
+	client := usercontroller.NewUserAdminClient(conn)
+
+	user, err := client.RestoreUser(
+		ctx, &usercontroller.RestoreUserRequest{Name: *cmd.name})
+	if err != nil {
+		log.Fatalf("Could not restore user %v", err)
+	}
+	log.Printf("Restored user %s with id %s", user.Name, user.Id)
+}
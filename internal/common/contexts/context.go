@@ -3,6 +3,7 @@ package contexts
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 //ContextKey Key parameter to set/retrieve from a context.
@@ -11,6 +12,17 @@ type ContextKey string
 const (
 	//UserID is a key to set and retrieve user IDs to/from contexts.
 	UserID ContextKey = "user_id"
+	//RequestID is a key to set and retrieve request IDs to/from contexts.
+	RequestID ContextKey = "request_id"
+	//TokenExpiry is a key to set and retrieve the expiration time of the
+	//access token of a request to/from contexts.
+	TokenExpiry ContextKey = "token_expiry"
+	//TokenIssuedAt is a key to set and retrieve the issue time of the access
+	//token of a request to/from contexts.
+	TokenIssuedAt ContextKey = "token_issued_at"
+	//User is a key to set and retrieve the authenticated user to/from
+	//contexts, with the accessors of the usercommon package.
+	User ContextKey = "user"
 )
 
 // GetUserID retrieves the ID of a user from the given context. If not founds,
@@ -23,7 +35,72 @@ func GetUserID(ctx context.Context) string {
 	panic(errors.New("unauthenticated request"))
 }
 
+// LookupUserID retrieves the ID of a user from the given context, false if
+// the request is not authenticated.
+func LookupUserID(ctx context.Context) (string, bool) {
+	val, ok := ctx.Value(UserID).(string)
+	return val, ok
+}
+
 //SetUserID sets the given user ID to the given context.
 func SetUserID(ctx context.Context, userID string) context.Context {
 	return context.WithValue(ctx, UserID, userID)
 }
+
+// GetRequestID retrieves the ID of the request from the given context, or an
+// empty string if not set.
+func GetRequestID(ctx context.Context) string {
+	val, _ := ctx.Value(RequestID).(string)
+	return val
+}
+
+//SetRequestID sets the given request ID to the given context.
+func SetRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, RequestID, requestID)
+}
+
+// GetTokenExpiry retrieves the expiration time of the access token used to
+// authenticate the request from the given context, false if the request was
+// not authenticated with an access token.
+func GetTokenExpiry(ctx context.Context) (time.Time, bool) {
+	val, ok := ctx.Value(TokenExpiry).(time.Time)
+	return val, ok
+}
+
+//SetTokenExpiry sets the given access token expiration time to the given
+//context.
+func SetTokenExpiry(ctx context.Context, expiresAt time.Time) context.Context {
+	return context.WithValue(ctx, TokenExpiry, expiresAt)
+}
+
+// GetTokenIssuedAt retrieves the issue time of the access token used to
+// authenticate the request from the given context, false if the request was
+// not authenticated with an access token.
+func GetTokenIssuedAt(ctx context.Context) (time.Time, bool) {
+	val, ok := ctx.Value(TokenIssuedAt).(time.Time)
+	return val, ok
+}
+
+//SetTokenIssuedAt sets the given access token issue time to the given
+//context.
+func SetTokenIssuedAt(ctx context.Context, issuedAt time.Time) context.Context {
+	return context.WithValue(ctx, TokenIssuedAt, issuedAt)
+}
+
+// detachedContext carries the values of its parent but not its deadline and
+// cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (ctx detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (ctx detachedContext) Done() <-chan struct{}             { return nil }
+func (ctx detachedContext) Err() error                        { return nil }
+func (ctx detachedContext) Value(key interface{}) interface{} { return ctx.parent.Value(key) }
+
+// Detach returns a context with the values of the given context which is
+// never canceled, used to complete work after the end of a request, e.g. when
+// a stream is closed by the client.
+func Detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
@@ -42,3 +42,37 @@ func TestContextsGetUserID_WithValue_ReturnsCorrectValue(t *testing.T) {
 	// Assert
 	assert.Equal(userID, result)
 }
+
+func TestContextsGetRequestID_WithNoID_ReturnsEmpty(t *testing.T) {
+	// Act
+	result := GetRequestID(context.Background())
+
+	// Assert
+	assert.Empty(t, result)
+}
+
+func TestContextsGetRequestID_WithSetRequestID_ReturnsCorrectValue(t *testing.T) {
+	// Arrange
+	ctx := SetRequestID(context.Background(), "request1")
+
+	// Act
+	result := GetRequestID(ctx)
+
+	// Assert
+	assert.Equal(t, "request1", result)
+}
+
+func TestContextsDetach_WithCanceledContext_KeepsValuesAndIsNotCanceled(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(SetUserID(context.Background(), "UserA"))
+	cancel()
+
+	// Act
+	detached := Detach(ctx)
+
+	// Assert
+	assert.NoError(detached.Err())
+	assert.Nil(detached.Done())
+	assert.Equal("UserA", GetUserID(detached))
+}
@@ -1,6 +1,7 @@
 package methods
 
 import (
+	"database/sql"
 	"fmt"
 	"p2pderivatives-server/internal/common/grpc/pbbase"
 
@@ -13,6 +14,10 @@ import (
 
 var methodStore map[string]*pbbase.OptionBase
 
+// isolationLevels records the isolation levels of the methods declared with
+// the isolation_level method option.
+var isolationLevels map[string]sql.IsolationLevel
+
 func init() {
 	//registers the validator.proto file to use in protobuf definition files.
 	fieldValidator := &validator.FieldValidator{}
@@ -25,21 +30,45 @@ func init() {
 func Init(svr *grpc.Server) {
 	sds, _ := grpcreflect.LoadServiceDescriptors(svr)
 	methodStore = make(map[string]*pbbase.OptionBase)
+	isolationLevels = make(map[string]sql.IsolationLevel)
 	for _, sd := range sds {
 		for _, md := range sd.GetMethods() {
 			opts := md.GetMethodOptions()
+			methodName := fmt.Sprintf("/%s/%s", sd.GetFullyQualifiedName(), md.GetName())
 
 			val, err := proto.GetExtension(opts, pbbase.E_OptionBase)
 			if err == nil {
 				option, ok := val.(*pbbase.OptionBase)
 				if ok {
-					methodStore[fmt.Sprintf("/%s/%s", sd.GetFullyQualifiedName(), md.GetName())] = option
+					methodStore[methodName] = option
+				}
+			}
+
+			val, err = proto.GetExtension(opts, pbbase.E_IsolationLevel)
+			if err == nil {
+				level, ok := val.(*pbbase.IsolationLevel)
+				if ok && *level != pbbase.IsolationLevel_DefaultIsolation {
+					isolationLevels[methodName] = toSQLIsolationLevel(*level)
 				}
 			}
 		}
 	}
 }
 
+// toSQLIsolationLevel returns the sql isolation level of the given option.
+func toSQLIsolationLevel(level pbbase.IsolationLevel) sql.IsolationLevel {
+	switch level {
+	case pbbase.IsolationLevel_ReadCommitted:
+		return sql.LevelReadCommitted
+	case pbbase.IsolationLevel_RepeatableRead:
+		return sql.LevelRepeatableRead
+	case pbbase.IsolationLevel_Serializable:
+		return sql.LevelSerializable
+	default:
+		return sql.LevelDefault
+	}
+}
+
 // IsIgnoreTokenVerify returns whether the given method name has the
 // "IgnoreTokenVerify" option.
 func IsIgnoreTokenVerify(methodName string) bool {
@@ -59,3 +88,28 @@ func TxOption(methodName string) pbbase.TxOption {
 	}
 	return pbbase.TxOption_ReadWrite
 }
+
+// SetServiceOption sets the option of all the methods of the given service
+// registered on the server. Used for services whose definition cannot declare
+// options, such as the standard health service. Must be called after Init.
+func SetServiceOption(
+	svr *grpc.Server, serviceName string, option *pbbase.OptionBase) {
+	info, ok := svr.GetServiceInfo()[serviceName]
+	if !ok {
+		return
+	}
+	for _, method := range info.Methods {
+		methodStore[fmt.Sprintf("/%s/%s", serviceName, method.Name)] = option
+	}
+}
+
+// IsolationLevel returns the value of the "isolation_level" option for the
+// requested method as the isolation level of its DB transactions. If the
+// method doesn't have the option, the default isolation level of the
+// database is returned.
+func IsolationLevel(methodName string) sql.IsolationLevel {
+	if level, ok := isolationLevels[methodName]; ok {
+		return level
+	}
+	return sql.LevelDefault
+}
@@ -1,6 +1,7 @@
 package methods_test
 
 import (
+	"database/sql"
 	"testing"
 
 	"p2pderivatives-server/internal/common/grpc/methods"
@@ -9,6 +10,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func TestMethodsInit_HasCorrectTokenMethods(t *testing.T) {
@@ -33,3 +36,29 @@ func TestMethodsInit_HasCorrectTxOptionsMethods(t *testing.T) {
 	assert.Equal(t, pbbase.TxOption_ReadOnly, methods.TxOption("/test.Test/TestReadOnlyTxOption"))
 	assert.Equal(t, pbbase.TxOption_NoTx, methods.TxOption("/test.Test/TestNoTxOption"))
 }
+
+func TestMethodsSetServiceOption_HasOptionForAllMethods(t *testing.T) {
+	srv := grpc.NewServer()
+	test.RegisterTestServer(srv, &test.Controller{})
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	methods.Init(srv)
+
+	methods.SetServiceOption(srv, healthpb.Health_ServiceDesc.ServiceName, &pbbase.OptionBase{
+		IgnoreTokenVerify: true,
+		TxOption:          pbbase.TxOption_NoTx,
+	})
+
+	assert.True(t, methods.IsIgnoreTokenVerify("/grpc.health.v1.Health/Check"))
+	assert.Equal(t, pbbase.TxOption_NoTx, methods.TxOption("/grpc.health.v1.Health/Watch"))
+	assert.True(t, methods.IsIgnoreTokenVerify("/test.Test/TestNoToken"))
+	assert.False(t, methods.IsIgnoreTokenVerify("/test.Test/TestWithToken"))
+}
+
+func TestMethodsInit_HasCorrectIsolationLevels(t *testing.T) {
+	srv := grpc.NewServer()
+	test.RegisterTestServer(srv, &test.Controller{})
+	methods.Init(srv)
+
+	assert.Equal(t, sql.LevelSerializable, methods.IsolationLevel("/test.Test/TestSerializableIsolation"))
+	assert.Equal(t, sql.LevelDefault, methods.IsolationLevel("/test.Test/TestDefaultTxOption"))
+}
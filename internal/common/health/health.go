@@ -0,0 +1,136 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const pingTimeout = time.Second
+
+// Checker reports the health of the server through the standard gRPC health
+// service and HTTP liveness and readiness endpoints. The server is ready when
+// it has been marked as serving and the database can be reached.
+type Checker struct {
+	server   *health.Server
+	ping     func(ctx context.Context) error
+	services []string
+	lock     sync.RWMutex
+	serving  bool
+}
+
+// NewChecker creates a new Checker using the given function to check the
+// database connectivity. The checker is not serving until SetServing is
+// called.
+func NewChecker(ping func(ctx context.Context) error) *Checker {
+	checker := &Checker{
+		server: health.NewServer(),
+		ping:   ping,
+	}
+	checker.server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return checker
+}
+
+// Server returns the gRPC health service implementation.
+func (checker *Checker) Server() healthpb.HealthServer {
+	return checker.server
+}
+
+// AddServices registers the names of the services whose status is reported
+// by the gRPC health service in addition to the overall status.
+func (checker *Checker) AddServices(services ...string) {
+	checker.lock.Lock()
+	checker.services = append(checker.services, services...)
+	checker.lock.Unlock()
+	checker.Update(context.Background())
+}
+
+// SetServing marks the server as serving or not, e.g. during startup or
+// shutdown, and updates the reported status.
+func (checker *Checker) SetServing(serving bool) {
+	checker.lock.Lock()
+	checker.serving = serving
+	checker.lock.Unlock()
+	checker.Update(context.Background())
+}
+
+// Shutdown marks the server as not serving permanently, ignoring later
+// updates.
+func (checker *Checker) Shutdown() {
+	checker.lock.Lock()
+	checker.serving = false
+	checker.lock.Unlock()
+	checker.server.Shutdown()
+}
+
+// IsReady returns whether the server is serving and the database reachable.
+func (checker *Checker) IsReady(ctx context.Context) bool {
+	checker.lock.RLock()
+	serving := checker.serving
+	checker.lock.RUnlock()
+	if !serving {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return checker.ping(ctx) == nil
+}
+
+// Update checks the readiness of the server and updates the status reported
+// by the gRPC health service.
+func (checker *Checker) Update(ctx context.Context) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if checker.IsReady(ctx) {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+
+	checker.lock.RLock()
+	defer checker.lock.RUnlock()
+	checker.server.SetServingStatus("", status)
+	for _, service := range checker.services {
+		checker.server.SetServingStatus(service, status)
+	}
+}
+
+// Run updates the reported status at the given interval until the context is
+// done.
+func (checker *Checker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checker.Update(ctx)
+		}
+	}
+}
+
+// LivenessHandler returns an HTTP handler always responding OK while the
+// process is running.
+func (checker *Checker) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// ReadinessHandler returns an HTTP handler responding OK when the server is
+// ready and Service Unavailable otherwise.
+func (checker *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !checker.IsReady(r.Context()) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("not ready\n"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,120 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func newTestChecker(pingErr *error) *Checker {
+	return NewChecker(func(ctx context.Context) error { return *pingErr })
+}
+
+func checkStatus(checker *Checker, service string) healthpb.HealthCheckResponse_ServingStatus {
+	response, err := checker.Server().Check(
+		context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN
+	}
+	return response.Status
+}
+
+func TestChecker_BeforeSetServing_IsNotServing(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	var pingErr error
+	checker := newTestChecker(&pingErr)
+
+	// Act
+	ready := checker.IsReady(context.Background())
+
+	// Assert
+	assert.False(ready)
+	assert.Equal(healthpb.HealthCheckResponse_NOT_SERVING, checkStatus(checker, ""))
+}
+
+func TestChecker_ServingWithDatabase_IsServing(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	var pingErr error
+	checker := newTestChecker(&pingErr)
+	checker.AddServices("user.User")
+
+	// Act
+	checker.SetServing(true)
+
+	// Assert
+	assert.True(checker.IsReady(context.Background()))
+	assert.Equal(healthpb.HealthCheckResponse_SERVING, checkStatus(checker, ""))
+	assert.Equal(healthpb.HealthCheckResponse_SERVING, checkStatus(checker, "user.User"))
+}
+
+func TestChecker_DatabaseUnreachable_IsNotServing(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	var pingErr error
+	checker := newTestChecker(&pingErr)
+	checker.SetServing(true)
+
+	// Act
+	pingErr = errors.New("connection refused")
+	checker.Update(context.Background())
+
+	// Assert
+	assert.False(checker.IsReady(context.Background()))
+	assert.Equal(healthpb.HealthCheckResponse_NOT_SERVING, checkStatus(checker, ""))
+}
+
+func TestChecker_Shutdown_IsNotServing(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	var pingErr error
+	checker := newTestChecker(&pingErr)
+	checker.SetServing(true)
+
+	// Act
+	checker.Shutdown()
+	checker.Update(context.Background())
+
+	// Assert
+	assert.False(checker.IsReady(context.Background()))
+	assert.Equal(healthpb.HealthCheckResponse_NOT_SERVING, checkStatus(checker, ""))
+}
+
+func TestCheckerReadinessHandler_ReflectsReadiness(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	var pingErr error
+	checker := newTestChecker(&pingErr)
+	handler := checker.ReadinessHandler()
+
+	// Act
+	notReady := httptest.NewRecorder()
+	handler.ServeHTTP(notReady, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	checker.SetServing(true)
+	ready := httptest.NewRecorder()
+	handler.ServeHTTP(ready, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	// Assert
+	assert.Equal(http.StatusServiceUnavailable, notReady.Code)
+	assert.Equal(http.StatusOK, ready.Code)
+}
+
+func TestCheckerLivenessHandler_IsOK(t *testing.T) {
+	// Arrange
+	var pingErr error
+	checker := newTestChecker(&pingErr)
+	recorder := httptest.NewRecorder()
+
+	// Act
+	checker.LivenessHandler().ServeHTTP(
+		recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	// Assert
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
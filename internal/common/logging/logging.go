@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"context"
+	"runtime/debug"
+	"strings"
+
+	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus/ctxlogrus"
+	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// healthServicePrefix is the prefix of the methods of the health service,
+// whose successful calls are not logged as they are frequently polled.
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+// AccessLogUnaryInterceptor is a unary interceptor that adds a logger with
+// the method fields to the context and logs the result and duration of each
+// call. Fields added to the context logger by the following interceptors and
+// handler, such as the request and user IDs, are included in the log.
+func AccessLogUnaryInterceptor(log *logrus.Entry) grpc.UnaryServerInterceptor {
+	return grpc_logrus.UnaryServerInterceptor(log, grpc_logrus.WithDecider(shouldLog))
+}
+
+// AccessLogStreamInterceptor is a stream interceptor that adds a logger with
+// the method fields to the context and logs the result and duration of each
+// stream.
+func AccessLogStreamInterceptor(log *logrus.Entry) grpc.StreamServerInterceptor {
+	return grpc_logrus.StreamServerInterceptor(log, grpc_logrus.WithDecider(shouldLog))
+}
+
+// RecoveryUnaryInterceptor is a unary interceptor that converts panics into
+// Internal errors.
+func RecoveryUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return grpc_recovery.UnaryServerInterceptor(
+		grpc_recovery.WithRecoveryHandlerContext(recoverPanic))
+}
+
+// RecoveryStreamInterceptor is a stream interceptor that converts panics into
+// Internal errors.
+func RecoveryStreamInterceptor() grpc.StreamServerInterceptor {
+	return grpc_recovery.StreamServerInterceptor(
+		grpc_recovery.WithRecoveryHandlerContext(recoverPanic))
+}
+
+func shouldLog(fullMethod string, err error) bool {
+	return err != nil || !strings.HasPrefix(fullMethod, healthServicePrefix)
+}
+
+// recoverPanic logs the recovered value with the stack trace and returns an
+// Internal error without details for the client.
+func recoverPanic(ctx context.Context, p interface{}) error {
+	ctxlogrus.Extract(ctx).WithField("stack", string(debug.Stack())).
+		Errorf("recovered from panic: %v", p)
+	return status.Error(codes.Internal, "internal error")
+}
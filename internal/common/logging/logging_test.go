@@ -0,0 +1,159 @@
+package logging
+
+import (
+	"context"
+	"testing"
+
+	"p2pderivatives-server/internal/common/contexts"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus/ctxlogrus"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var unaryInfo = &grpc.UnaryServerInfo{FullMethod: "/test.Test/TestMethod"}
+
+func callWithRequestID(ctx context.Context) (requestID string, err error) {
+	interceptor := RequestIDUnaryInterceptor()
+	_, err = interceptor(ctx, nil, unaryInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		requestID = contexts.GetRequestID(ctx)
+		return nil, nil
+	})
+	return
+}
+
+func TestRequestIDUnaryInterceptor_WithRequestID_UsesIt(t *testing.T) {
+	// Arrange
+	ctx := metadata.NewIncomingContext(
+		context.Background(), metadata.Pairs(MetaKeyRequestID, "request-1"))
+
+	// Act
+	requestID, err := callWithRequestID(ctx)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "request-1", requestID)
+}
+
+func TestRequestIDUnaryInterceptor_WithoutRequestID_GeneratesOne(t *testing.T) {
+	// Act
+	requestID, err := callWithRequestID(context.Background())
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Len(t, requestID, 36)
+}
+
+func TestRequestIDUnaryInterceptor_WithInvalidRequestID_GeneratesOne(t *testing.T) {
+	// Arrange
+	ctx := metadata.NewIncomingContext(
+		context.Background(), metadata.Pairs(MetaKeyRequestID, "request 1\n"))
+
+	// Act
+	requestID, err := callWithRequestID(ctx)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NotEqual(t, "request 1\n", requestID)
+	assert.Len(t, requestID, 36)
+}
+
+func TestRequestIDUnaryInterceptor_AddsLogField(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	logger, hook := test.NewNullLogger()
+	ctx := ctxlogrus.ToContext(context.Background(), logrus.NewEntry(logger))
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(MetaKeyRequestID, "request-1"))
+
+	// Act
+	callWithRequestID(ctx)
+	ctxlogrus.Extract(ctx).Info("done")
+
+	// Assert
+	assert.Equal("request-1", hook.LastEntry().Data["request_id"])
+}
+
+func TestRecoveryUnaryInterceptor_WithPanic_ReturnsInternalError(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	logger, hook := test.NewNullLogger()
+	ctx := ctxlogrus.ToContext(context.Background(), logrus.NewEntry(logger))
+	interceptor := RecoveryUnaryInterceptor()
+
+	// Act
+	_, err := interceptor(ctx, nil, unaryInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		contexts.GetUserID(ctx)
+		return nil, nil
+	})
+
+	// Assert
+	assert.Equal(codes.Internal, status.Code(err))
+	assert.Equal(logrus.ErrorLevel, hook.LastEntry().Level)
+	assert.Contains(hook.LastEntry().Data["stack"], "runtime/debug.Stack")
+}
+
+func TestRecoveryUnaryInterceptor_WithPanickingInterceptor_ReturnsInternalError(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	panicking := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		panic("interceptor failure")
+	}
+	chain := grpc_middleware.ChainUnaryServer(
+		RequestIDUnaryInterceptor(), RecoveryUnaryInterceptor(), panicking)
+
+	// Act
+	_, err := chain(context.Background(), nil, unaryInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	})
+
+	// Assert
+	assert.Equal(codes.Internal, status.Code(err))
+}
+
+func TestRecoveryStreamInterceptor_WithPanickingInterceptor_ReturnsInternalError(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	panicking := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		panic("interceptor failure")
+	}
+	chain := grpc_middleware.ChainStreamServer(
+		RequestIDStreamInterceptor(), RecoveryStreamInterceptor(), panicking)
+	stream := &testServerStream{ctx: context.Background()}
+
+	// Act
+	err := chain(nil, stream, &grpc.StreamServerInfo{FullMethod: "/test.Test/TestStream"},
+		func(srv interface{}, stream grpc.ServerStream) error {
+			return nil
+		})
+
+	// Assert
+	assert.Equal(codes.Internal, status.Code(err))
+}
+
+// testServerStream is a server stream only providing its context and
+// accepting headers.
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testServerStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func TestShouldLog_HealthCheck_IsOnlyLoggedOnError(t *testing.T) {
+	// Assert
+	assert.False(t, shouldLog("/grpc.health.v1.Health/Check", nil))
+	assert.True(t, shouldLog("/grpc.health.v1.Health/Check", status.Error(codes.Internal, "")))
+	assert.True(t, shouldLog("/user.User/GetUserList", nil))
+}
@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"context"
+	"p2pderivatives-server/internal/common/contexts"
+
+	"github.com/google/uuid"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus/ctxlogrus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// MetaKeyRequestID is the metadata key of the request ID, accepted from
+	// the client and returned in the response header.
+	MetaKeyRequestID = "x-request-id"
+	// maxRequestIDLen is the maximum length of request IDs accepted from
+	// clients.
+	maxRequestIDLen = 128
+)
+
+// RequestIDUnaryInterceptor is a unary interceptor that sets the request ID
+// to the context, the log fields and the response header.
+func RequestIDUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		newCtx, requestID := withRequestID(ctx)
+		grpc.SetHeader(newCtx, metadata.Pairs(MetaKeyRequestID, requestID))
+		return handler(newCtx, req)
+	}
+}
+
+// RequestIDStreamInterceptor is a stream interceptor that sets the request ID
+// to the context, the log fields and the response header.
+func RequestIDStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		newCtx, requestID := withRequestID(stream.Context())
+		stream.SetHeader(metadata.Pairs(MetaKeyRequestID, requestID))
+		return handler(srv, &wrappedStream{stream, newCtx})
+	}
+}
+
+type wrappedStream struct {
+	grpc.ServerStream
+	WrappedContext context.Context
+}
+
+// Context returns the wrapper's WrappedContext, overwriting the nested
+// grpc.ServerStream.Context()
+func (w *wrappedStream) Context() context.Context {
+	return w.WrappedContext
+}
+
+// withRequestID returns the context with the request ID provided by the
+// client, or a new one if it is missing or invalid.
+func withRequestID(ctx context.Context) (context.Context, string) {
+	requestID := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(MetaKeyRequestID); len(vals) > 0 && isValidRequestID(vals[0]) {
+			requestID = vals[0]
+		}
+	}
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+	ctxlogrus.AddFields(ctx, logrus.Fields{"request_id": requestID})
+	return contexts.SetRequestID(ctx, requestID), requestID
+}
+
+// isValidRequestID returns whether the given request ID is short and only
+// contains printable ASCII characters, so that it can safely be logged.
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range requestID {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
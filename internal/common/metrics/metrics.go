@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "p2pd"
+
+// Results of relaying a DLC message.
+const (
+	RelayResultRelayed = "relayed"
+	RelayResultFailed  = "failed"
+)
+
+// Results of a cache lookup.
+const (
+	CacheResultHit  = "hit"
+	CacheResultMiss = "miss"
+)
+
+// Results of a DB transaction.
+const (
+	TxResultCommit      = "commit"
+	TxResultCommitError = "commit_error"
+	TxResultRollback    = "rollback"
+)
+
+var (
+	// ActiveDlcStreams is the number of open ReceiveDlcMessages streams.
+	ActiveDlcStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dlc_receive_streams_active",
+		Help:      "Number of open ReceiveDlcMessages streams.",
+	})
+
+	// RelayedDlcMessages counts the messages handled by SendDlcMessage.
+	RelayedDlcMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dlc_messages_total",
+		Help:      "Number of DLC messages sent, by relay result.",
+	}, []string{"result"})
+
+	// DlcMessageAckLatency measures the time taken by receiving streams to
+	// acknowledge a relayed message.
+	DlcMessageAckLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dlc_message_ack_seconds",
+		Help:      "Time between relaying a DLC message and its acknowledgement.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 8),
+	})
+
+	// DBTransactions counts the DB transactions opened by the transaction
+	// interceptor.
+	DBTransactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_transactions_total",
+		Help:      "Number of DB transactions, by transaction option and result.",
+	}, []string{"option", "result"})
+
+	// DBTransactionRetries counts the DB transactions run again after failing
+	// because of a concurrent transaction.
+	DBTransactionRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_transaction_retries_total",
+		Help:      "Number of DB transactions retried after a conflict, by transaction option.",
+	}, []string{"option"})
+
+	// UserCacheLookups counts the lookups of users by ID in the user cache.
+	UserCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "user_cache_lookups_total",
+		Help:      "Number of lookups of users by ID in the user cache, by result.",
+	}, []string{"result"})
+
+	// PasswordHashDuration measures the time spent computing Argon2 hashes.
+	PasswordHashDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "password_hash_seconds",
+		Help:      "Time spent computing Argon2 password hashes.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 10),
+	})
+)
+
+// ObserveSince records the time elapsed since start in the given observer.
+func ObserveSince(observer prometheus.Observer, start time.Time) {
+	observer.Observe(time.Since(start).Seconds())
+}
+
+// Handler returns the HTTP handler exposing the metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
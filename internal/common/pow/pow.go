@@ -0,0 +1,216 @@
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	challengeVersion = "1"
+	nonceLen         = 16
+)
+
+var (
+	// ErrMalformedChallenge is returned when a challenge cannot be parsed.
+	ErrMalformedChallenge = errors.New("malformed challenge")
+	// ErrInvalidSignature is returned when a challenge was not signed by the
+	// server.
+	ErrInvalidSignature = errors.New("invalid challenge signature")
+	// ErrChallengeExpired is returned when a challenge is used after its
+	// expiration.
+	ErrChallengeExpired = errors.New("challenge expired")
+	// ErrInvalidSolution is returned when a solution does not meet the
+	// challenge difficulty.
+	ErrInvalidSolution = errors.New("invalid solution")
+)
+
+// Challenge is a signed proof of work challenge.
+type Challenge struct {
+	Value      string
+	Difficulty int
+	ExpiresAt  time.Time
+}
+
+// Challenger issues and verifies hashcash-style challenges. Challenges are
+// signed with an HMAC so that they can be verified without keeping state.
+type Challenger struct {
+	config        *Config
+	secret        []byte
+	configLock    sync.RWMutex
+	lock          sync.Mutex
+	registrations []time.Time
+}
+
+// NewChallenger creates a new Challenger using the given configuration.
+func NewChallenger(config *Config) (*Challenger, error) {
+	secret := []byte(config.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, errors.Wrap(err, "failed to generate challenge secret")
+		}
+	}
+	return &Challenger{config: config, secret: secret}, nil
+}
+
+// UpdateConfig applies the given configuration, e.g. on reload. The current
+// secret is kept if the new configuration does not set one, so that issued
+// challenges remain valid.
+func (c *Challenger) UpdateConfig(config *Config) {
+	c.configLock.Lock()
+	defer c.configLock.Unlock()
+	c.config = config
+	if config.Secret != "" {
+		c.secret = []byte(config.Secret)
+	}
+}
+
+func (c *Challenger) getConfig() (*Config, []byte) {
+	c.configLock.RLock()
+	defer c.configLock.RUnlock()
+	return c.config, c.secret
+}
+
+// IsEnabled returns whether registrations require a proof of work.
+func (c *Challenger) IsEnabled() bool {
+	if c == nil {
+		return false
+	}
+	config, _ := c.getConfig()
+	return config.Enabled
+}
+
+// NewChallenge issues a new challenge whose difficulty depends on the recent
+// registration volume.
+func (c *Challenger) NewChallenge(now time.Time) (*Challenge, error) {
+	nonce := make([]byte, nonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate challenge nonce")
+	}
+	config, secret := c.getConfig()
+	difficulty := c.currentDifficulty(config, now)
+	expiresAt := now.Add(config.ChallengeTTL)
+	payload := strings.Join([]string{
+		challengeVersion,
+		strconv.FormatInt(expiresAt.Unix(), 10),
+		strconv.Itoa(difficulty),
+		base64.RawURLEncoding.EncodeToString(nonce),
+	}, ".")
+	return &Challenge{
+		Value:      payload + "." + sign(secret, payload),
+		Difficulty: difficulty,
+		ExpiresAt:  time.Unix(expiresAt.Unix(), 0),
+	}, nil
+}
+
+// Verify checks that the challenge was issued by the server, has not expired
+// and that the solution hashed with the challenge and data meets the challenge
+// difficulty. Binding the data (e.g. the requested user name) prevents reusing
+// a solution for another registration.
+func (c *Challenger) Verify(challenge, data, solution string, now time.Time) error {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 5 || parts[0] != challengeVersion {
+		return ErrMalformedChallenge
+	}
+	payload := strings.Join(parts[:4], ".")
+	_, secret := c.getConfig()
+	if !hmac.Equal([]byte(sign(secret, payload)), []byte(parts[4])) {
+		return ErrInvalidSignature
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrMalformedChallenge
+	}
+	if now.Unix() > expiresAt {
+		return ErrChallengeExpired
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return ErrMalformedChallenge
+	}
+	if LeadingZeroBits(Hash(challenge, data, solution)) < difficulty {
+		return ErrInvalidSolution
+	}
+	return nil
+}
+
+// RecordRegistration records a successful registration, used to scale the
+// difficulty of new challenges.
+func (c *Challenger) RecordRegistration(now time.Time) {
+	config, _ := c.getConfig()
+	if config.ScaleThreshold <= 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.pruneRegistrations(config, now)
+	c.registrations = append(c.registrations, now)
+}
+
+func (c *Challenger) currentDifficulty(config *Config, now time.Time) int {
+	difficulty := config.Difficulty
+	if config.ScaleThreshold > 0 {
+		c.lock.Lock()
+		c.pruneRegistrations(config, now)
+		difficulty += len(c.registrations) / config.ScaleThreshold
+		c.lock.Unlock()
+	}
+	if config.MaxDifficulty > 0 && difficulty > config.MaxDifficulty {
+		difficulty = config.MaxDifficulty
+	}
+	return difficulty
+}
+
+func (c *Challenger) pruneRegistrations(config *Config, now time.Time) {
+	limit := now.Add(-config.ScaleWindow)
+	i := 0
+	for i < len(c.registrations) && !c.registrations[i].After(limit) {
+		i++
+	}
+	c.registrations = c.registrations[i:]
+}
+
+func sign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Hash returns the hash that must have the required number of leading zero
+// bits for the solution to be valid.
+func Hash(challenge, data, solution string) []byte {
+	sum := sha256.Sum256([]byte(challenge + ":" + data + ":" + solution))
+	return sum[:]
+}
+
+// LeadingZeroBits returns the number of leading zero bits in the given hash.
+func LeadingZeroBits(hash []byte) int {
+	count := 0
+	for _, b := range hash {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// Solve finds a solution for the given challenge and data. Intended to be
+// used by clients.
+func Solve(challenge, data string, difficulty int) string {
+	for counter := uint64(0); ; counter++ {
+		solution := strconv.FormatUint(counter, 16)
+		if LeadingZeroBits(Hash(challenge, data, solution)) >= difficulty {
+			return solution
+		}
+	}
+}
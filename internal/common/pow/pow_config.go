@@ -0,0 +1,23 @@
+package pow
+
+import "time"
+
+// Config contains the configuration of the registration proof of work.
+type Config struct {
+	Enabled bool `configkey:"app.pow.enabled"`
+	// Secret is the HMAC key used to sign challenges. Must be shared by all
+	// server instances. A random key is generated if empty.
+	Secret string `configkey:"app.pow.secret"`
+	// Difficulty is the minimum number of leading zero bits required in the
+	// hash of a solution.
+	Difficulty int `configkey:"app.pow.difficulty" default:"20" validate:"min=0,max=64"`
+	// MaxDifficulty caps the difficulty when scaling with the registration
+	// volume.
+	MaxDifficulty int `configkey:"app.pow.max_difficulty" default:"28" validate:"min=0,max=64"`
+	// ScaleThreshold is the number of registrations within ScaleWindow above
+	// which the difficulty is increased by one bit. Zero disables scaling.
+	ScaleThreshold int           `configkey:"app.pow.scale_threshold" default:"0" validate:"min=0"`
+	ScaleWindow    time.Duration `configkey:"app.pow.scale_window,duration" default:"1h"`
+	// ChallengeTTL is the time during which a challenge can be used.
+	ChallengeTTL time.Duration `configkey:"app.pow.challenge_ttl,duration" default:"5m"`
+}
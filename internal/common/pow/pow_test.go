@@ -0,0 +1,125 @@
+package pow
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestChallenger(difficulty, threshold int) *Challenger {
+	challenger, _ := NewChallenger(&Config{
+		Enabled:        true,
+		Secret:         "secret",
+		Difficulty:     difficulty,
+		MaxDifficulty:  difficulty + 2,
+		ScaleThreshold: threshold,
+		ScaleWindow:    time.Hour,
+		ChallengeTTL:   5 * time.Minute,
+	})
+	return challenger
+}
+
+func TestChallenger_WithSolvedChallenge_Verifies(t *testing.T) {
+	assert := assert.New(t)
+	challenger := newTestChallenger(8, 0)
+	challenge, err := challenger.NewChallenge(now)
+	assert.NoError(err)
+
+	solution := Solve(challenge.Value, "name", challenge.Difficulty)
+
+	assert.NoError(challenger.Verify(challenge.Value, "name", solution, now))
+}
+
+func TestChallenger_WithOtherData_Fails(t *testing.T) {
+	assert := assert.New(t)
+	challenger := newTestChallenger(8, 0)
+	challenge, _ := challenger.NewChallenge(now)
+	solution := Solve(challenge.Value, "name", challenge.Difficulty)
+
+	// The solution is bound to the data it was computed for, unless it
+	// happens to also be a solution for the other data.
+	if LeadingZeroBits(Hash(challenge.Value, "other", solution)) < 8 {
+		assert.Equal(ErrInvalidSolution,
+			challenger.Verify(challenge.Value, "other", solution, now))
+	}
+}
+
+func TestChallenger_WithExpiredChallenge_Fails(t *testing.T) {
+	challenger := newTestChallenger(4, 0)
+	challenge, _ := challenger.NewChallenge(now)
+	solution := Solve(challenge.Value, "name", challenge.Difficulty)
+
+	err := challenger.Verify(challenge.Value, "name", solution, now.Add(time.Hour))
+
+	assert.Equal(t, ErrChallengeExpired, err)
+}
+
+func TestChallenger_WithTamperedDifficulty_Fails(t *testing.T) {
+	challenger := newTestChallenger(16, 0)
+	challenge, _ := challenger.NewChallenge(now)
+	parts := strings.Split(challenge.Value, ".")
+	parts[2] = "0"
+	tampered := strings.Join(parts, ".")
+
+	err := challenger.Verify(tampered, "name", "0", now)
+
+	assert.Equal(t, ErrInvalidSignature, err)
+}
+
+func TestChallenger_WithOtherSecret_Fails(t *testing.T) {
+	challenger := newTestChallenger(4, 0)
+	other, _ := NewChallenger(&Config{Enabled: true, Secret: "other"})
+	challenge, _ := other.NewChallenge(now)
+
+	err := challenger.Verify(challenge.Value, "name", "0", now)
+
+	assert.Equal(t, ErrInvalidSignature, err)
+}
+
+func TestChallenger_WithManyRegistrations_ScalesDifficulty(t *testing.T) {
+	assert := assert.New(t)
+	challenger := newTestChallenger(4, 2)
+
+	for i := 0; i < 2; i++ {
+		challenger.RecordRegistration(now)
+	}
+	scaled, _ := challenger.NewChallenge(now)
+	for i := 0; i < 10; i++ {
+		challenger.RecordRegistration(now)
+	}
+	capped, _ := challenger.NewChallenge(now)
+	reset, _ := challenger.NewChallenge(now.Add(2 * time.Hour))
+
+	assert.Equal(5, scaled.Difficulty)
+	assert.Equal(6, capped.Difficulty)
+	assert.Equal(4, reset.Difficulty)
+}
+
+func TestChallenger_UpdateConfig_AppliesDifficultyAndKeepsSecret(t *testing.T) {
+	assert := assert.New(t)
+	challenger := newTestChallenger(4, 0)
+	issued, _ := challenger.NewChallenge(now)
+	solution := Solve(issued.Value, "user1", issued.Difficulty)
+
+	challenger.UpdateConfig(&Config{
+		Enabled:      true,
+		Difficulty:   8,
+		ChallengeTTL: 5 * time.Minute,
+	})
+	updated, _ := challenger.NewChallenge(now)
+
+	assert.Equal(8, updated.Difficulty)
+	assert.NoError(challenger.Verify(issued.Value, "user1", solution, now))
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(0, LeadingZeroBits([]byte{0x80}))
+	assert.Equal(3, LeadingZeroBits([]byte{0x10}))
+	assert.Equal(12, LeadingZeroBits([]byte{0x00, 0x08}))
+	assert.Equal(16, LeadingZeroBits([]byte{0x00, 0x00}))
+}
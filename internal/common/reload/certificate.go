@@ -0,0 +1,51 @@
+package reload
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+// CertificateStore holds a TLS certificate loaded from files that can be
+// reloaded while the server is running.
+type CertificateStore struct {
+	certFile    string
+	keyFile     string
+	lock        sync.RWMutex
+	certificate *tls.Certificate
+}
+
+// NewCertificateStore loads the certificate from the given files.
+func NewCertificateStore(certFile, keyFile string) (*CertificateStore, error) {
+	store := &CertificateStore{certFile: certFile, keyFile: keyFile}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload loads the certificate from the files again. The current certificate
+// is kept if loading fails.
+func (store *CertificateStore) Reload() error {
+	certificate, err := tls.LoadX509KeyPair(store.certFile, store.keyFile)
+	if err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.certificate = &certificate
+	return nil
+}
+
+// Certificate returns the current certificate.
+func (store *CertificateStore) Certificate() *tls.Certificate {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+	return store.certificate
+}
+
+// GetCertificate returns the current certificate, to be used as
+// tls.Config.GetCertificate so that new connections use reloaded
+// certificates.
+func (store *CertificateStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return store.Certificate(), nil
+}
@@ -0,0 +1,125 @@
+package reload
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCertificate writes a self-signed certificate with the given common
+// name and its key to the given files.
+func writeTestCertificate(t *testing.T, commonName, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func commonName(t *testing.T, store *CertificateStore) string {
+	certificate, err := store.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestCertificateStore_Reload_ReturnsNewCertificate(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	writeTestCertificate(t, "old", certFile, keyFile)
+	store, err := NewCertificateStore(certFile, keyFile)
+	assert.NoError(err)
+	writeTestCertificate(t, "new", certFile, keyFile)
+
+	// Act
+	err = store.Reload()
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal("new", commonName(t, store))
+}
+
+func TestCertificateStore_ReloadInvalidFile_KeepsCertificate(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	writeTestCertificate(t, "old", certFile, keyFile)
+	store, _ := NewCertificateStore(certFile, keyFile)
+	ioutil.WriteFile(certFile, []byte("invalid"), 0600)
+
+	// Act
+	err := store.Reload()
+
+	// Assert
+	assert.Error(err)
+	assert.Equal("old", commonName(t, store))
+}
+
+func TestWatch_WithChangedFile_CallsOnChangeOnce(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	ioutil.WriteFile(file, []byte("a: 1"), 0600)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 10)
+	go Watch(ctx, []string{file}, 50*time.Millisecond, func() { changes <- struct{}{} })
+	time.Sleep(50 * time.Millisecond)
+
+	// Act
+	ioutil.WriteFile(file, []byte("a: 2"), 0600)
+	ioutil.WriteFile(file, []byte("a: 3"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "other.yaml"), []byte("b: 1"), 0600)
+
+	// Assert
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		assert.Fail("onChange not called")
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(changes, 0)
+}
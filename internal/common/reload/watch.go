@@ -0,0 +1,67 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch calls onChange when one of the given files, or a file in one of the
+// given directories, changes. The parent directories of the files are
+// watched so that files replaced by renaming, as done by editors and
+// Kubernetes volumes, are detected. Calls are delayed until no change
+// happened for the given delay so that a single call is made for the
+// multiple events caused by a change. Watch blocks until the context is done.
+func Watch(ctx context.Context, paths []string, delay time.Duration, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		dir := path
+		if info, err := os.Stat(path); err != nil {
+			return err
+		} else if !info.IsDir() {
+			files[path] = true
+			dir = filepath.Dir(path)
+		} else {
+			dirs[path] = true
+		}
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			return err
+		case event := <-watcher.Events:
+			name := filepath.Clean(event.Name)
+			if files[name] || dirs[filepath.Dir(name)] || isVolumeUpdate(name) {
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			onChange()
+		}
+	}
+}
+
+// isVolumeUpdate returns whether the event is the update of the data link of
+// a Kubernetes volume, through which the files of the volume are changed.
+func isVolumeUpdate(name string) bool {
+	return filepath.Base(name) == "..data"
+}
@@ -4,16 +4,40 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
 
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	metadata "google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// ErrorDomain is the domain of the google.rpc.ErrorInfo details of the
+	// errors returned by the server.
+	ErrorDomain = "p2pderivatives-server"
+	// MessageLocale is the locale of the google.rpc.LocalizedMessage details
+	// of the errors returned by the server.
+	MessageLocale = "en-US"
+
+	// metaKeyDetailCode is the ErrorInfo metadata key of the numeric
+	// ErrorDetailCode, the values of the detail being set with the
+	// metaKeyDetailValue keys and their index.
+	metaKeyDetailCode  = "code"
+	metaKeyDetailValue = "value_%d"
 )
 
 // GetGrpcStatus converts the given error to a GRPC status corresponding to the
 // code contained in the error. If the error is not a service.Error instance,
 // it will be transformed to an UnknownError and an InternalStatus will be
-// returned.
+// returned. The details of the error are attached to the status as
+// google.rpc error details, and to the x-error-detail trailer as base64
+// encoded JSON for older clients.
 func GetGrpcStatus(ctx context.Context, err error) *status.Status {
 	serr, ok := err.(*Error)
 	if !ok {
@@ -32,6 +56,15 @@ func GetGrpcStatus(ctx context.Context, err error) *status.Status {
 		grpc.SetTrailer(ctx, trailer)
 	}
 
+	st := newStatus(serr)
+	withDetails, err := st.WithDetails(statusDetails(serr)...)
+	if err != nil {
+		return st
+	}
+	return withDetails
+}
+
+func newStatus(serr *Error) *status.Status {
 	switch serr.Code {
 	case InternalError:
 		return NewInternalStatus(serr.Message)
@@ -57,7 +90,68 @@ func GetGrpcStatus(ctx context.Context, err error) *status.Status {
 		return NewInternalStatus(serr.Message)
 	case PermissionDenied:
 		return NewPermissionDeniedStatus(serr.Message)
+	case Aborted:
+		return NewAbortedStatus(serr.Message)
+	case Unimplemented:
+		return NewUnimplementedStatus(serr.Message)
 	default:
 		return NewInternalStatus(serr.Message)
 	}
 }
+
+// statusDetails returns the google.rpc error details of the given error: an
+// ErrorInfo for each of its details, a BadRequest with its field violations,
+// a RetryInfo if it is retryable, and a LocalizedMessage with its message.
+func statusDetails(serr *Error) []proto.Message {
+	details := make([]proto.Message, 0, len(serr.Details)+3)
+	for _, detail := range serr.Details {
+		info := &errdetails.ErrorInfo{
+			Reason: errorReason(detail.Code),
+			Domain: ErrorDomain,
+			Metadata: map[string]string{
+				metaKeyDetailCode: strconv.Itoa(int(detail.Code)),
+			},
+		}
+		for i, value := range detail.Values {
+			info.Metadata[fmt.Sprintf(metaKeyDetailValue, i)] = value
+		}
+		details = append(details, info)
+	}
+	if len(serr.FieldViolations) != 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, violation := range serr.FieldViolations {
+			badRequest.FieldViolations = append(badRequest.FieldViolations,
+				&errdetails.BadRequest_FieldViolation{
+					Field:       violation.Field,
+					Description: violation.Description,
+				})
+		}
+		details = append(details, badRequest)
+	}
+	if serr.Retryable {
+		details = append(details, &errdetails.RetryInfo{
+			RetryDelay: durationpb.New(serr.RetryDelay),
+		})
+	}
+	return append(details, &errdetails.LocalizedMessage{
+		Locale:  MessageLocale,
+		Message: serr.Message,
+	})
+}
+
+// errorReason returns the ErrorInfo reason of the given detail code, its name
+// in upper snake case without prefix, e.g. TOKEN_EXPIRED.
+func errorReason(code ErrorDetailCode) string {
+	name := strings.TrimPrefix(code.String(), "ErrorDetailCode")
+	if strings.HasPrefix(name, "(") {
+		return "UNKNOWN"
+	}
+	var reason strings.Builder
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			reason.WriteRune('_')
+		}
+		reason.WriteRune(unicode.ToUpper(r))
+	}
+	return reason.String()
+}
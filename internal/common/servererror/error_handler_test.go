@@ -0,0 +1,142 @@
+package servererror_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"p2pderivatives-server/internal/common/servererror"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetGrpcStatus_WithDetails_AttachesErrorInfo(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	err := servererror.NewErrorWithDetail(servererror.PreconditionError,
+		"accessToken expired", nil, servererror.ErrorDetailCodeTokenExpired, []string{"a", "b"})
+
+	// Act
+	st := servererror.GetGrpcStatus(context.Background(), err)
+
+	// Assert
+	assert.Equal(codes.FailedPrecondition, st.Code())
+	if assert.Len(st.Details(), 2) {
+		info := st.Details()[0].(*errdetails.ErrorInfo)
+		assert.Equal("TOKEN_EXPIRED", info.Reason)
+		assert.Equal(servererror.ErrorDomain, info.Domain)
+		assert.Equal(map[string]string{"code": "3", "value_0": "a", "value_1": "b"}, info.Metadata)
+		message := st.Details()[1].(*errdetails.LocalizedMessage)
+		assert.Equal(servererror.MessageLocale, message.Locale)
+		assert.Equal("accessToken expired", message.Message)
+	}
+}
+
+func TestDecodeStatusDetails_ReturnsErrorDetails(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	serr := servererror.NewErrorWithDetails(servererror.OptimisticLockError,
+		"User was modified by another request.", nil, []servererror.ErrorDetail{
+			{Code: servererror.ErrorDetailCodeConcurrentUpdate},
+			{Code: servererror.ErrorDetailCodeUnknown, Values: []string{"value"}},
+		})
+	err := servererror.GetGrpcStatus(
+		context.Background(), servererror.WithRetryDelay(serr, time.Second)).Err()
+
+	// Act
+	details, ok := servererror.DecodeStatusDetails(err)
+
+	// Assert
+	assert.True(ok)
+	assert.Equal(serr.(*servererror.Error).Details, details.Details)
+	assert.True(details.Retryable)
+	assert.Equal(time.Second, details.RetryDelay)
+	assert.Equal("User was modified by another request.", details.LocalizedMessage)
+}
+
+func TestDecodeStatusDetails_WithFieldViolations_ReturnsFieldViolations(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	violations := []servererror.FieldViolation{
+		{Field: "max_uses", Description: "Must not be negative."},
+		{Field: "validity", Description: "Must not be negative."},
+	}
+	err := servererror.GetGrpcStatus(context.Background(),
+		servererror.NewErrorWithFieldViolations(servererror.InvalidArguments,
+			"Invalid invite parameters.", nil,
+			servererror.ErrorDetailCodeInviteParametersInvalid, violations)).Err()
+
+	// Act
+	details, ok := servererror.DecodeStatusDetails(err)
+
+	// Assert
+	assert.True(ok)
+	assert.Equal(codes.InvalidArgument, status.Code(err))
+	assert.Equal(violations, details.FieldViolations)
+	assert.Equal([]servererror.ErrorDetail{
+		{Code: servererror.ErrorDetailCodeInviteParametersInvalid}}, details.Details)
+	assert.False(details.Retryable)
+}
+
+func TestDecodeStatusDetails_WithOtherError_ReturnsFalse(t *testing.T) {
+	// Act
+	_, ok := servererror.DecodeStatusDetails(context.Canceled)
+
+	// Assert
+	assert.False(t, ok)
+}
+
+func TestGetGrpcStatus_ErrorDetailCodes_HaveStableValuesAndReasons(t *testing.T) {
+	// The values and reasons of the detail codes are part of the API and
+	// must not change.
+	for _, test := range []struct {
+		code   servererror.ErrorDetailCode
+		value  string
+		reason string
+	}{
+		{servererror.ErrorDetailCodeUnknown, "1", "UNKNOWN"},
+		{servererror.ErrorDetailCodeTokenRequired, "2", "TOKEN_REQUIRED"},
+		{servererror.ErrorDetailCodeTokenExpired, "3", "TOKEN_EXPIRED"},
+		{servererror.ErrorDetailCodeTokenInvalid, "4", "TOKEN_INVALID"},
+		{servererror.ErrorDetailCodeConcurrentUpdate, "5", "CONCURRENT_UPDATE"},
+		{servererror.ErrorDetailCodeTokenRevoked, "6", "TOKEN_REVOKED"},
+		{servererror.ErrorDetailCodeUserNotFound, "7", "USER_NOT_FOUND"},
+		{servererror.ErrorDetailCodeNameTaken, "8", "NAME_TAKEN"},
+		{servererror.ErrorDetailCodeNameInvalid, "9", "NAME_INVALID"},
+		{servererror.ErrorDetailCodePasswordInvalid, "10", "PASSWORD_INVALID"},
+		{servererror.ErrorDetailCodeCredentialsInvalid, "11", "CREDENTIALS_INVALID"},
+		{servererror.ErrorDetailCodeRefreshTokenInvalid, "12", "REFRESH_TOKEN_INVALID"},
+		{servererror.ErrorDetailCodeRegistrationClosed, "13", "REGISTRATION_CLOSED"},
+		{servererror.ErrorDetailCodeProofOfWorkRequired, "14", "PROOF_OF_WORK_REQUIRED"},
+		{servererror.ErrorDetailCodeProofOfWorkInvalid, "15", "PROOF_OF_WORK_INVALID"},
+		{servererror.ErrorDetailCodeProofOfWorkDisabled, "16", "PROOF_OF_WORK_DISABLED"},
+		{servererror.ErrorDetailCodeInviteRequired, "17", "INVITE_REQUIRED"},
+		{servererror.ErrorDetailCodeInviteInvalid, "18", "INVITE_INVALID"},
+		{servererror.ErrorDetailCodeInviteExhausted, "19", "INVITE_EXHAUSTED"},
+		{servererror.ErrorDetailCodeInviteParametersInvalid, "20", "INVITE_PARAMETERS_INVALID"},
+		{servererror.ErrorDetailCodeInviteLimitExceeded, "21", "INVITE_LIMIT_EXCEEDED"},
+		{servererror.ErrorDetailCodeAdminRequired, "22", "ADMIN_REQUIRED"},
+		{servererror.ErrorDetailCodeRestorationExpired, "23", "RESTORATION_EXPIRED"},
+		{servererror.ErrorDetailCodePeerNotConnected, "24", "PEER_NOT_CONNECTED"},
+		{servererror.ErrorDetailCodePeerUnavailable, "25", "PEER_UNAVAILABLE"},
+		{servererror.ErrorDetailCodeStreamRenewalUnsupported, "26", "STREAM_RENEWAL_UNSUPPORTED"},
+	} {
+		t.Run(test.code.String(), func(t *testing.T) {
+			// Arrange
+			assert := assert.New(t)
+			err := servererror.NewErrorWithDetail(
+				servererror.InternalError, "error", nil, test.code, nil)
+
+			// Act
+			st := servererror.GetGrpcStatus(context.Background(), err)
+
+			// Assert
+			info := st.Details()[0].(*errdetails.ErrorInfo)
+			assert.Equal(test.reason, info.Reason)
+			assert.Equal(test.value, info.Metadata["code"])
+		})
+	}
+}
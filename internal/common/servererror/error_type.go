@@ -1,5 +1,7 @@
 package servererror
 
+import "time"
+
 // ErrorCode represents an error code.
 type ErrorCode int
 
@@ -36,6 +38,12 @@ const (
 	// PermissionDenied is returned when the requested action cannot be
 	// performed given the provided authentication.
 	PermissionDenied
+	// Aborted is returned when the request was aborted because of a
+	// concurrent request.
+	Aborted
+	// Unimplemented is returned when the requested service is not enabled on
+	// the server.
+	Unimplemented
 )
 
 // Error represent an error in the system.
@@ -44,6 +52,11 @@ type Error struct {
 	Message string
 	Cause   error
 	Details []ErrorDetail
+	// FieldViolations lists the invalid fields of the request, if any.
+	FieldViolations []FieldViolation
+	// Retryable is set when the request can be retried after RetryDelay.
+	Retryable  bool
+	RetryDelay time.Duration
 }
 
 // Error returns the message associated with the error.
@@ -77,6 +90,77 @@ const (
 	// ErrorDetailCodeTokenInvalid indicates that the requested service
 	// required an authentication token but the provided one was invalid.
 	ErrorDetailCodeTokenInvalid
+	// ErrorDetailCodeConcurrentUpdate indicates that the requested resource
+	// was modified by another request since it was read, and that the request
+	// can be retried.
+	ErrorDetailCodeConcurrentUpdate
+	// ErrorDetailCodeTokenRevoked indicates that the provided authentication
+	// token was issued before the password change or deletion of its user,
+	// and that the user must log in again.
+	ErrorDetailCodeTokenRevoked
+	// ErrorDetailCodeUserNotFound indicates that the requested user, or the
+	// user of the provided authentication token or certificate, does not
+	// exist.
+	ErrorDetailCodeUserNotFound
+	// ErrorDetailCodeNameTaken indicates that the requested user name is
+	// already used or reserved.
+	ErrorDetailCodeNameTaken
+	// ErrorDetailCodeNameInvalid indicates that the requested user name does
+	// not meet the name policy.
+	ErrorDetailCodeNameInvalid
+	// ErrorDetailCodePasswordInvalid indicates that the provided new password
+	// does not meet the password policy.
+	ErrorDetailCodePasswordInvalid
+	// ErrorDetailCodeCredentialsInvalid indicates that the provided name or
+	// password is wrong, without telling which.
+	ErrorDetailCodeCredentialsInvalid
+	// ErrorDetailCodeRefreshTokenInvalid indicates that the provided refresh
+	// token is invalid, expired or was revoked.
+	ErrorDetailCodeRefreshTokenInvalid
+	// ErrorDetailCodeRegistrationClosed indicates that the server does not
+	// accept new users.
+	ErrorDetailCodeRegistrationClosed
+	// ErrorDetailCodeProofOfWorkRequired indicates that the registration
+	// requires a proof of work that was not provided.
+	ErrorDetailCodeProofOfWorkRequired
+	// ErrorDetailCodeProofOfWorkInvalid indicates that the provided proof of
+	// work is invalid or its challenge expired.
+	ErrorDetailCodeProofOfWorkInvalid
+	// ErrorDetailCodeProofOfWorkDisabled indicates that a registration
+	// challenge was requested while no proof of work is required.
+	ErrorDetailCodeProofOfWorkDisabled
+	// ErrorDetailCodeInviteRequired indicates that the registration requires
+	// an invite code that was not provided.
+	ErrorDetailCodeInviteRequired
+	// ErrorDetailCodeInviteInvalid indicates that the provided invite code
+	// does not exist.
+	ErrorDetailCodeInviteInvalid
+	// ErrorDetailCodeInviteExhausted indicates that the provided invite code
+	// is expired or used up.
+	ErrorDetailCodeInviteExhausted
+	// ErrorDetailCodeInviteParametersInvalid indicates that the requested
+	// invite parameters are invalid.
+	ErrorDetailCodeInviteParametersInvalid
+	// ErrorDetailCodeInviteLimitExceeded indicates that the requested invite
+	// parameters exceed the limits allowed to users that are not
+	// administrators.
+	ErrorDetailCodeInviteLimitExceeded
+	// ErrorDetailCodeAdminRequired indicates that the requested service is
+	// only available to administrators.
+	ErrorDetailCodeAdminRequired
+	// ErrorDetailCodeRestorationExpired indicates that the deletion grace
+	// period of the user to restore expired.
+	ErrorDetailCodeRestorationExpired
+	// ErrorDetailCodePeerNotConnected indicates that the destination of a
+	// message is not connected.
+	ErrorDetailCodePeerNotConnected
+	// ErrorDetailCodePeerUnavailable indicates that no connection of the
+	// destination of a message acknowledged it.
+	ErrorDetailCodePeerUnavailable
+	// ErrorDetailCodeStreamRenewalUnsupported indicates that the streams of a
+	// user authenticated with a certificate do not expire and cannot be
+	// renewed.
+	ErrorDetailCodeStreamRenewalUnsupported
 )
 
 // ErrorDetail contains detailed information about an error.
@@ -85,6 +169,34 @@ type ErrorDetail struct {
 	Values []string        `json:"values"` // エラー詳細情報
 }
 
+// FieldViolation describes an invalid field of a request.
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// NewErrorWithFieldViolations creates a new Error structure with the given
+// detail code for a request with the given invalid fields.
+func NewErrorWithFieldViolations(code ErrorCode, message string, err error, detailCode ErrorDetailCode, violations []FieldViolation) error {
+	return &Error{
+		Code:            code,
+		Message:         message,
+		Cause:           err,
+		Details:         []ErrorDetail{{Code: detailCode}},
+		FieldViolations: violations,
+	}
+}
+
+// WithRetryDelay marks the given error as retryable after the given delay
+// and returns it. Errors that are not Error instances are returned as is.
+func WithRetryDelay(err error, delay time.Duration) error {
+	if serr, ok := err.(*Error); ok {
+		serr.Retryable = true
+		serr.RetryDelay = delay
+	}
+	return err
+}
+
 // NewErrorWithDetail creates a new ErrorDetail structure containing the
 // provided information.
 func NewErrorWithDetail(code ErrorCode, message string, err error, detailCode ErrorDetailCode, detailValues []string) error {
@@ -12,11 +12,33 @@ func _() {
 	_ = x[ErrorDetailCodeTokenRequired-2]
 	_ = x[ErrorDetailCodeTokenExpired-3]
 	_ = x[ErrorDetailCodeTokenInvalid-4]
+	_ = x[ErrorDetailCodeConcurrentUpdate-5]
+	_ = x[ErrorDetailCodeTokenRevoked-6]
+	_ = x[ErrorDetailCodeUserNotFound-7]
+	_ = x[ErrorDetailCodeNameTaken-8]
+	_ = x[ErrorDetailCodeNameInvalid-9]
+	_ = x[ErrorDetailCodePasswordInvalid-10]
+	_ = x[ErrorDetailCodeCredentialsInvalid-11]
+	_ = x[ErrorDetailCodeRefreshTokenInvalid-12]
+	_ = x[ErrorDetailCodeRegistrationClosed-13]
+	_ = x[ErrorDetailCodeProofOfWorkRequired-14]
+	_ = x[ErrorDetailCodeProofOfWorkInvalid-15]
+	_ = x[ErrorDetailCodeProofOfWorkDisabled-16]
+	_ = x[ErrorDetailCodeInviteRequired-17]
+	_ = x[ErrorDetailCodeInviteInvalid-18]
+	_ = x[ErrorDetailCodeInviteExhausted-19]
+	_ = x[ErrorDetailCodeInviteParametersInvalid-20]
+	_ = x[ErrorDetailCodeInviteLimitExceeded-21]
+	_ = x[ErrorDetailCodeAdminRequired-22]
+	_ = x[ErrorDetailCodeRestorationExpired-23]
+	_ = x[ErrorDetailCodePeerNotConnected-24]
+	_ = x[ErrorDetailCodePeerUnavailable-25]
+	_ = x[ErrorDetailCodeStreamRenewalUnsupported-26]
 }
 
-const _ErrorDetailCode_name = "ErrorDetailCodeUnknownErrorDetailCodeTokenRequiredErrorDetailCodeTokenExpiredErrorDetailCodeTokenInvalid"
+const _ErrorDetailCode_name = "ErrorDetailCodeUnknownErrorDetailCodeTokenRequiredErrorDetailCodeTokenExpiredErrorDetailCodeTokenInvalidErrorDetailCodeConcurrentUpdateErrorDetailCodeTokenRevokedErrorDetailCodeUserNotFoundErrorDetailCodeNameTakenErrorDetailCodeNameInvalidErrorDetailCodePasswordInvalidErrorDetailCodeCredentialsInvalidErrorDetailCodeRefreshTokenInvalidErrorDetailCodeRegistrationClosedErrorDetailCodeProofOfWorkRequiredErrorDetailCodeProofOfWorkInvalidErrorDetailCodeProofOfWorkDisabledErrorDetailCodeInviteRequiredErrorDetailCodeInviteInvalidErrorDetailCodeInviteExhaustedErrorDetailCodeInviteParametersInvalidErrorDetailCodeInviteLimitExceededErrorDetailCodeAdminRequiredErrorDetailCodeRestorationExpiredErrorDetailCodePeerNotConnectedErrorDetailCodePeerUnavailableErrorDetailCodeStreamRenewalUnsupported"
 
-var _ErrorDetailCode_index = [...]uint8{0, 22, 50, 77, 104}
+var _ErrorDetailCode_index = [...]uint16{0, 22, 50, 77, 104, 135, 162, 189, 213, 239, 269, 302, 336, 369, 403, 436, 470, 499, 527, 557, 595, 629, 657, 690, 721, 751, 790}
 
 func (i ErrorDetailCode) String() string {
 	i -= 1
@@ -74,3 +74,10 @@ func NewUnauthenticatedStatus(message string) *status.Status {
 func NewPermissionDeniedStatus(message string) *status.Status {
 	return status.New(codes.PermissionDenied, message)
 }
+
+// NewAbortedStatus returns a GRPC status with the Aborted code.
+// Refer to https://github.com/grpc/grpc-go/blob/master/codes/codes.go for the
+// meaning of the error code.
+func NewAbortedStatus(message string) *status.Status {
+	return status.New(codes.Aborted, message)
+}
@@ -61,3 +61,22 @@ func (s *ServiceError) CreateServiceErrorWithDetails(
 	}
 	return NewErrorWithDetails(code, message, err, errDetails)
 }
+
+// CreateServiceErrorWithFieldViolations creates and logs a detailed error for
+// a request with the given invalid fields.
+func (s *ServiceError) CreateServiceErrorWithFieldViolations(
+	ctx context.Context,
+	code ErrorCode,
+	message string,
+	err error,
+	detailCode ErrorDetailCode,
+	violations []FieldViolation) error {
+	log := ctxlogrus.Extract(ctx)
+	if err != nil {
+		err = errors.WithStack(err)
+		log.Errorf(fmt.Sprintf("%s error:%+v", message, err))
+	} else {
+		log.Errorf(message)
+	}
+	return NewErrorWithFieldViolations(code, message, err, detailCode, violations)
+}
@@ -0,0 +1,72 @@
+package servererror
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// StatusDetails contains the details of an error returned by the server,
+// decoded from the google.rpc error details of its status.
+type StatusDetails struct {
+	Details         []ErrorDetail
+	FieldViolations []FieldViolation
+	// Retryable is set when the request can be retried after RetryDelay.
+	Retryable  bool
+	RetryDelay time.Duration
+	// LocalizedMessage is the message of the error in MessageLocale.
+	LocalizedMessage string
+}
+
+// DecodeStatusDetails returns the details of the given error returned by a
+// gRPC client of the server, false if the error is not a gRPC status error.
+func DecodeStatusDetails(err error) (*StatusDetails, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil, false
+	}
+	details := &StatusDetails{}
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			if errorDetail, ok := decodeErrorInfo(detail); ok {
+				details.Details = append(details.Details, errorDetail)
+			}
+		case *errdetails.BadRequest:
+			for _, violation := range detail.FieldViolations {
+				details.FieldViolations = append(details.FieldViolations, FieldViolation{
+					Field:       violation.Field,
+					Description: violation.Description,
+				})
+			}
+		case *errdetails.RetryInfo:
+			details.Retryable = true
+			details.RetryDelay = detail.RetryDelay.AsDuration()
+		case *errdetails.LocalizedMessage:
+			details.LocalizedMessage = detail.Message
+		}
+	}
+	return details, true
+}
+
+func decodeErrorInfo(info *errdetails.ErrorInfo) (ErrorDetail, bool) {
+	if info.Domain != ErrorDomain {
+		return ErrorDetail{}, false
+	}
+	code, err := strconv.Atoi(info.Metadata[metaKeyDetailCode])
+	if err != nil {
+		return ErrorDetail{}, false
+	}
+	detail := ErrorDetail{Code: ErrorDetailCode(code)}
+	for i := 0; ; i++ {
+		value, ok := info.Metadata[fmt.Sprintf(metaKeyDetailValue, i)]
+		if !ok {
+			break
+		}
+		detail.Values = append(detail.Values, value)
+	}
+	return detail, true
+}
@@ -0,0 +1,200 @@
+package token
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// CertificateBinding associates a client certificate with a user. A
+// certificate matches the binding if any of the non empty matchers matches.
+// When several bindings match a certificate, those matching it with the
+// strongest matcher, the fingerprint then the subject then a SAN, are used.
+type CertificateBinding struct {
+	UserID      string `configkey:"user_id" validate:"required"`
+	Fingerprint string `configkey:"fingerprint"` // hex encoded SHA-256 of the DER certificate
+	Subject     string `configkey:"subject"`     // RFC 2253 distinguished name
+	SAN         string `configkey:"san"`         // DNS name, email address or URI
+}
+
+// CertificateConfig contains the configuration for authenticating users with
+// verified client certificates.
+type CertificateConfig struct {
+	Enabled  bool                          `configkey:"app.certauth.enabled"`
+	Bindings map[string]CertificateBinding `configkey:"app.certauth.bindings"`
+}
+
+var (
+	certConf     *CertificateConfig
+	certConfLock sync.RWMutex
+)
+
+// Strengths of the matchers of a binding, a stronger match taking precedence.
+const (
+	noMatch = iota
+	sanMatch
+	subjectMatch
+	fingerprintMatch
+)
+
+// InitCertificateAuth sets the global configuration used to map client
+// certificates to users. Can be called again to apply a new configuration.
+// An invalid configuration is not applied.
+func InitCertificateAuth(config *CertificateConfig) error {
+	if err := validateCertificateConfig(config); err != nil {
+		return err
+	}
+	certConfLock.Lock()
+	defer certConfLock.Unlock()
+	certConf = config
+	return nil
+}
+
+// validateCertificateConfig checks that each binding has a matcher and that
+// no two bindings of different users have the same matcher.
+func validateCertificateConfig(config *CertificateConfig) error {
+	if config == nil {
+		return nil
+	}
+	users := make(map[string]string)
+	bind := func(name, matcher, userID string) error {
+		if other, ok := users[matcher]; ok && other != userID {
+			return errors.Errorf(
+				"certificate binding %s: %s is bound to another user", name, matcher)
+		}
+		users[matcher] = userID
+		return nil
+	}
+	for name, binding := range config.Bindings {
+		if binding.Fingerprint == "" && binding.Subject == "" && binding.SAN == "" {
+			return errors.Errorf("certificate binding %s has no matcher", name)
+		}
+		if binding.Fingerprint != "" {
+			fingerprint := normalizeFingerprint(binding.Fingerprint)
+			if decoded, err := hex.DecodeString(fingerprint); err != nil ||
+				len(decoded) != sha256.Size {
+				return errors.Errorf(
+					"certificate binding %s has an invalid fingerprint", name)
+			}
+			if err := bind(name, "fingerprint "+fingerprint, binding.UserID); err != nil {
+				return err
+			}
+		}
+		if binding.Subject != "" {
+			if err := bind(name, "subject "+binding.Subject, binding.UserID); err != nil {
+				return err
+			}
+		}
+		if binding.SAN != "" {
+			if err := bind(name, "SAN "+strings.ToLower(binding.SAN), binding.UserID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FindCertificateUserID returns the ID of the user bound to the given
+// certificate. No user is returned if the bindings matching the certificate
+// with the strongest matcher are bound to different users.
+func FindCertificateUserID(cert *x509.Certificate) (string, bool) {
+	certConfLock.RLock()
+	certConf := certConf
+	certConfLock.RUnlock()
+	if certConf == nil || !certConf.Enabled || cert == nil {
+		return "", false
+	}
+
+	fingerprint := CertificateFingerprint(cert)
+	subject := cert.Subject.String()
+	best := noMatch
+	userID := ""
+	ambiguous := false
+	for _, binding := range certConf.Bindings {
+		match := binding.match(cert, fingerprint, subject)
+		switch {
+		case match == noMatch || match < best:
+		case match > best:
+			best, userID, ambiguous = match, binding.UserID, false
+		case binding.UserID != userID:
+			ambiguous = true
+		}
+	}
+
+	if best == noMatch || ambiguous {
+		return "", false
+	}
+	return userID, true
+}
+
+// match returns the strength of the strongest matcher of the binding
+// matching the given certificate.
+func (binding *CertificateBinding) match(
+	cert *x509.Certificate, fingerprint, subject string) int {
+	switch {
+	case binding.Fingerprint != "" &&
+		normalizeFingerprint(binding.Fingerprint) == fingerprint:
+		return fingerprintMatch
+	case binding.Subject != "" && binding.Subject == subject:
+		return subjectMatch
+	case binding.SAN != "" && hasSAN(cert, binding.SAN):
+		return sanMatch
+	default:
+		return noMatch
+	}
+}
+
+// CertificateFingerprint returns the hex encoded SHA-256 fingerprint of the
+// given certificate.
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// peerCertificate returns the leaf certificate presented by the peer if it was
+// verified during the TLS handshake.
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	chains := tlsInfo.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil
+	}
+	return chains[0][0]
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+func hasSAN(cert *x509.Certificate, san string) bool {
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, san) {
+			return true
+		}
+	}
+	for _, email := range cert.EmailAddresses {
+		if strings.EqualFold(email, san) {
+			return true
+		}
+	}
+	for _, uri := range cert.URIs {
+		if uri.String() == san {
+			return true
+		}
+	}
+	return false
+}
@@ -5,7 +5,12 @@ import (
 	"p2pderivatives-server/internal/common/contexts"
 	"p2pderivatives-server/internal/common/grpc/methods"
 	"p2pderivatives-server/internal/common/servererror"
+	"p2pderivatives-server/internal/common/tracing"
+	"strings"
+	"time"
 
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus/ctxlogrus"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
@@ -13,6 +18,8 @@ import (
 const (
 	//MetaKeyAuthentication is the key for setting and retrieving from metadata.
 	MetaKeyAuthentication = "authorization"
+
+	bearerPrefix = "Bearer "
 )
 
 var (
@@ -25,6 +32,10 @@ var (
 	// ErrTokenInvalid is an error returned when a token was expected but the
 	// provided one was invalid.
 	ErrTokenInvalid = servererror.NewErrorWithDetail(servererror.PreconditionError, "accessToken invalid", nil, servererror.ErrorDetailCodeTokenInvalid, nil)
+	// ErrTokenRevoked is an error returned when a token was expected but the
+	// provided one was revoked by a password change or the deletion of its
+	// user.
+	ErrTokenRevoked = servererror.NewErrorWithDetail(servererror.UnauthenticatedError, "accessToken revoked", nil, servererror.ErrorDetailCodeTokenRevoked, nil)
 )
 
 // UnaryInterceptor is a unary interceptor that checks that a valid token is
@@ -69,22 +80,35 @@ func (w *wrappedStream) Context() context.Context {
 	return w.WrappedContext
 }
 
-func verifyToken(ctx context.Context) (context.Context, error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return ctx, servererror.GetGrpcStatus(ctx, ErrInvalidRequest).Err()
-	}
+func verifyToken(ctx context.Context) (newCtx context.Context, err error) {
+	_, span := tracing.StartSpan(ctx, "token.verify")
+	defer func() { tracing.EndSpan(span, err) }()
+
+	md, _ := metadata.FromIncomingContext(ctx)
 	vals := md.Get(MetaKeyAuthentication)
 	if len(vals) == 0 {
+		// Fall back on the verified client certificate if any.
+		if id, ok := FindCertificateUserID(peerCertificate(ctx)); ok {
+			return withUserID(ctx, id), nil
+		}
 		return ctx, servererror.GetGrpcStatus(ctx, ErrInvalidRequest).Err()
 	}
-	accessToken := vals[0]
-	id, err := VerifyToken(accessToken)
+	// Browser clients using the gateway WebSockets send "Bearer <token>".
+	accessToken := strings.TrimPrefix(vals[0], bearerPrefix)
+	claims, err := verifyClaims(accessToken)
 	if err != nil {
-		if IsTokenExpiredError(err) {
+		if IsTokenExpiredError(err) || IsTokenRevokedError(err) {
 			return ctx, servererror.GetGrpcStatus(ctx, err).Err()
 		}
 		return ctx, servererror.GetGrpcStatus(ctx, ErrTokenInvalid).Err()
 	}
-	return contexts.SetUserID(ctx, id), nil
+	ctx = contexts.SetTokenExpiry(ctx, time.Unix(claims.ExpiresAt, 0))
+	ctx = contexts.SetTokenIssuedAt(ctx, time.Unix(0, claims.IssuedAtNano))
+	return withUserID(ctx, claims.Id), nil
+}
+
+// withUserID sets the user ID to the context and the log fields.
+func withUserID(ctx context.Context, userID string) context.Context {
+	ctxlogrus.AddFields(ctx, logrus.Fields{"user_id": userID})
+	return contexts.SetUserID(ctx, userID)
 }
@@ -2,7 +2,14 @@ package token_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
+	"math/big"
 	"p2pderivatives-server/internal/common/contexts"
 	"p2pderivatives-server/internal/common/grpc/methods"
 	"p2pderivatives-server/internal/common/token"
@@ -13,7 +20,9 @@ import (
 	"github.com/bouk/monkey"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 var (
@@ -28,6 +37,7 @@ var (
 	noTokenError      = "rpc error: code = InvalidArgument desc = accessToken required"
 	invalidTokenError = "rpc error: code = FailedPrecondition desc = accessToken invalid"
 	expiredTokenError = "rpc error: code = FailedPrecondition desc = accessToken expired"
+	revokedTokenError = "rpc error: code = Unauthenticated desc = accessToken revoked"
 	noError           = ""
 )
 
@@ -53,6 +63,17 @@ func TestTokenInterceptor_WithTokenIsRequired_Succeed(t *testing.T) {
 		noError)
 }
 
+func TestTokenInterceptor_WithBearerTokenIsRequired_Succeed(t *testing.T) {
+	testTokenHelper(
+		newContext("Bearer "+validToken),
+		t,
+		unaryInterceptor,
+		"TestWithToken",
+		userID,
+		success,
+		noError)
+}
+
 func TestTokenInterceptor_WithNoTokenIsRequired_Fails(t *testing.T) {
 	testTokenHelper(
 		context.Background(),
@@ -76,6 +97,25 @@ func TestTokenInterceptor_WithExpiredTokenIsRequired_Fails(t *testing.T) {
 	)
 }
 
+func TestTokenInterceptor_WithRevokedTokenIsRequired_Fails(t *testing.T) {
+	token.Init(&token.Config{
+		Secret:     "k^Cc#*mdnS9$nTOY6S1#1i7^e*o1ijSl",
+		Exp:        time.Minute * 30,
+		RefreshExp: time.Hour * 24 * 30,
+	})
+	revokedToken, _, _ := token.GenerateAccessToken("revokedUserID")
+	token.RevokeUserTokens("revokedUserID")
+
+	testTokenHelper(
+		newContext(revokedToken),
+		t,
+		unaryInterceptor,
+		"TestWithToken",
+		noUserID,
+		noResult,
+		revokedTokenError)
+}
+
 func TestTokenInterceptor_WithEmptyTokenIsRequired_Fails(t *testing.T) {
 	testTokenHelper(
 		newContext(emptyToken),
@@ -231,6 +271,38 @@ func TestBiDirectionalStreamTokenInterceptor_WithInvalidTokenIsRequired_Fails(t
 		invalidTokenError)
 }
 
+func TestStreamTokenInterceptor_WithToken_SetsTokenExpiry(t *testing.T) {
+	// Arrange
+	patch := monkey.Patch(time.Now, func() time.Time {
+		return time.Date(2019, 1, 31, 12, 0, 0, 0, time.UTC)
+	})
+	defer patch.Unpatch()
+	assert := assert.New(t)
+	srv := grpc.NewServer()
+	test.RegisterTestServer(srv, &test.Controller{})
+	methods.Init(srv)
+	token.Init(&token.Config{
+		Secret:     "k^Cc#*mdnS9$nTOY6S1#1i7^e*o1ijSl",
+		Exp:        time.Minute * 30,
+		RefreshExp: time.Hour * 24 * 30,
+	})
+	var expiresAt time.Time
+	var bound bool
+
+	// Act
+	err := token.StreamInterceptor()(nil, &mockStream{MockContext: newContext(validToken)},
+		&grpc.StreamServerInfo{FullMethod: getFullMethod("TestWithToken")},
+		func(srv interface{}, stream grpc.ServerStream) error {
+			expiresAt, bound = contexts.GetTokenExpiry(stream.Context())
+			return nil
+		})
+
+	// Assert
+	assert.NoError(err)
+	assert.True(bound)
+	assert.Equal(time.Date(2019, 1, 31, 12, 0, 0, 0, time.UTC), expiresAt.UTC())
+}
+
 func testTokenHelper(
 	ctx context.Context,
 	t *testing.T,
@@ -333,3 +405,202 @@ type mockStream struct {
 func (w *mockStream) Context() context.Context {
 	return w.MockContext
 }
+
+func TestTokenInterceptor_WithBoundClientCertificate_Succeed(t *testing.T) {
+	cert := newTestCertificate(t, "bound")
+	token.InitCertificateAuth(&token.CertificateConfig{
+		Enabled: true,
+		Bindings: map[string]token.CertificateBinding{
+			"bound": {UserID: userID, Fingerprint: token.CertificateFingerprint(cert)},
+		},
+	})
+	defer token.InitCertificateAuth(nil)
+
+	testTokenHelper(
+		newPeerContext(cert),
+		t,
+		unaryInterceptor,
+		"TestWithToken",
+		userID,
+		success,
+		noError)
+}
+
+func TestTokenInterceptor_WithSubjectBoundClientCertificate_Succeed(t *testing.T) {
+	cert := newTestCertificate(t, "bound")
+	token.InitCertificateAuth(&token.CertificateConfig{
+		Enabled: true,
+		Bindings: map[string]token.CertificateBinding{
+			"bound": {UserID: userID, Subject: "CN=bound"},
+		},
+	})
+	defer token.InitCertificateAuth(nil)
+
+	testTokenHelper(
+		newPeerContext(cert),
+		t,
+		streamInterceptor,
+		"TestResponseStreamWithToken",
+		userID,
+		noResult,
+		noError)
+}
+
+func TestTokenInterceptor_WithUnboundClientCertificate_Fails(t *testing.T) {
+	cert := newTestCertificate(t, "unbound")
+	token.InitCertificateAuth(&token.CertificateConfig{
+		Enabled: true,
+		Bindings: map[string]token.CertificateBinding{
+			"bound": {UserID: userID, Subject: "CN=bound"},
+		},
+	})
+	defer token.InitCertificateAuth(nil)
+
+	testTokenHelper(
+		newPeerContext(cert),
+		t,
+		unaryInterceptor,
+		"TestWithToken",
+		noUserID,
+		noResult,
+		noTokenError)
+}
+
+func TestTokenInterceptor_WithClientCertificateAuthDisabled_Fails(t *testing.T) {
+	cert := newTestCertificate(t, "bound")
+	token.InitCertificateAuth(&token.CertificateConfig{
+		Enabled: false,
+		Bindings: map[string]token.CertificateBinding{
+			"bound": {UserID: userID, Subject: "CN=bound"},
+		},
+	})
+	defer token.InitCertificateAuth(nil)
+
+	testTokenHelper(
+		newPeerContext(cert),
+		t,
+		unaryInterceptor,
+		"TestWithToken",
+		noUserID,
+		noResult,
+		noTokenError)
+}
+
+func TestFindCertificateUserID_WithOverlappingBindings_PrefersFingerprint(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	cert := newTestCertificate(t, "bound", "client.example.com")
+	err := token.InitCertificateAuth(&token.CertificateConfig{
+		Enabled: true,
+		Bindings: map[string]token.CertificateBinding{
+			"broad": {UserID: "user1", SAN: "client.example.com"},
+			"exact": {UserID: "user2", Fingerprint: token.CertificateFingerprint(cert)},
+		},
+	})
+	defer token.InitCertificateAuth(nil)
+
+	// Act
+	ids := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		id, ok := token.FindCertificateUserID(cert)
+		assert.True(ok)
+		ids[id] = true
+	}
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(map[string]bool{"user2": true}, ids)
+}
+
+func TestFindCertificateUserID_WithEquallyStrongBindingsOfDifferentUsers_Fails(t *testing.T) {
+	// Arrange
+	cert := newTestCertificate(t, "bound", "a.example.com", "b.example.com")
+	token.InitCertificateAuth(&token.CertificateConfig{
+		Enabled: true,
+		Bindings: map[string]token.CertificateBinding{
+			"a": {UserID: "user1", SAN: "a.example.com"},
+			"b": {UserID: "user2", SAN: "b.example.com"},
+		},
+	})
+	defer token.InitCertificateAuth(nil)
+
+	// Act
+	_, ok := token.FindCertificateUserID(cert)
+
+	// Assert
+	assert.False(t, ok)
+}
+
+func TestInitCertificateAuth_WithSameSubjectForDifferentUsers_FailsAndKeepsConfig(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	cert := newTestCertificate(t, "bound")
+	token.InitCertificateAuth(&token.CertificateConfig{
+		Enabled: true,
+		Bindings: map[string]token.CertificateBinding{
+			"bound": {UserID: "user1", Subject: "CN=bound"},
+		},
+	})
+	defer token.InitCertificateAuth(nil)
+
+	// Act
+	err := token.InitCertificateAuth(&token.CertificateConfig{
+		Enabled: true,
+		Bindings: map[string]token.CertificateBinding{
+			"a": {UserID: "user1", Subject: "CN=bound"},
+			"b": {UserID: "user2", Subject: "CN=bound"},
+		},
+	})
+	id, _ := token.FindCertificateUserID(cert)
+
+	// Assert
+	assert.Error(err)
+	assert.Equal("user1", id)
+}
+
+func TestInitCertificateAuth_WithInvalidBindings_Fails(t *testing.T) {
+	assert := assert.New(t)
+	defer token.InitCertificateAuth(nil)
+
+	assert.Error(token.InitCertificateAuth(&token.CertificateConfig{
+		Bindings: map[string]token.CertificateBinding{"none": {UserID: "user1"}},
+	}))
+	assert.Error(token.InitCertificateAuth(&token.CertificateConfig{
+		Bindings: map[string]token.CertificateBinding{
+			"short": {UserID: "user1", Fingerprint: "ab:cd"}},
+	}))
+}
+
+func newTestCertificate(
+	t *testing.T, commonName string, dnsNames ...string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newPeerContext(cert *x509.Certificate) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{cert}},
+			},
+		},
+	})
+}
@@ -0,0 +1,97 @@
+package token
+
+import (
+	"sync"
+	"time"
+)
+
+// revocations records, for each user whose tokens were revoked, the time of
+// the revocation and, for each user whose revocations are watched, the channel
+// closed on the next revocation.
+var revocations = struct {
+	sync.Mutex
+	revokedAt map[string]time.Time
+	watches   map[string]*revocationWatch
+}{
+	revokedAt: make(map[string]time.Time),
+	watches:   make(map[string]*revocationWatch),
+}
+
+// revocationWatch is the channel closed on the next revocation of the tokens
+// of a user, shared by its watchers.
+type revocationWatch struct {
+	channel  chan struct{}
+	watchers int
+}
+
+// RevokeUserTokens revokes the access tokens issued to the user with the
+// given ID until now, and closes the channels returned by UserTokensRevoked
+// for this user.
+func RevokeUserTokens(userID string) {
+	now := time.Now()
+	revocations.Lock()
+	defer revocations.Unlock()
+
+	// Tokens issued before the access token lifetime are expired anyway.
+	if conf := getConfig(); conf != nil {
+		for id, revokedAt := range revocations.revokedAt {
+			if now.Sub(revokedAt) > conf.Exp {
+				delete(revocations.revokedAt, id)
+			}
+		}
+	}
+	revocations.revokedAt[userID] = now
+	if watch, ok := revocations.watches[userID]; ok {
+		close(watch.channel)
+		delete(revocations.watches, userID)
+	}
+}
+
+// UserTokensRevoked returns a channel that is closed when the tokens of the
+// user with the given ID are next revoked, and a function to call once the
+// channel is no longer watched.
+func UserTokensRevoked(userID string) (revoked <-chan struct{}, stop func()) {
+	revocations.Lock()
+	defer revocations.Unlock()
+	watch, ok := revocations.watches[userID]
+	if !ok {
+		watch = &revocationWatch{channel: make(chan struct{})}
+		revocations.watches[userID] = watch
+	}
+	watch.watchers++
+	var once sync.Once
+	return watch.channel, func() {
+		once.Do(func() { unwatchRevocation(userID, watch) })
+	}
+}
+
+// RevocationWatchers returns the number of watchers of the next revocation of
+// the tokens of the user with the given ID.
+func RevocationWatchers(userID string) int {
+	revocations.Lock()
+	defer revocations.Unlock()
+	if watch, ok := revocations.watches[userID]; ok {
+		return watch.watchers
+	}
+	return 0
+}
+
+// unwatchRevocation removes a watcher of the given watch, which is dropped
+// with its last watcher unless already dropped by a revocation.
+func unwatchRevocation(userID string, watch *revocationWatch) {
+	revocations.Lock()
+	defer revocations.Unlock()
+	watch.watchers--
+	if watch.watchers == 0 && revocations.watches[userID] == watch {
+		delete(revocations.watches, userID)
+	}
+}
+
+// isRevoked returns whether the token with the given subject issued at the
+// given time was revoked.
+func isRevoked(id string, issuedAt time.Time) bool {
+	revocations.Lock()
+	defer revocations.Unlock()
+	revokedAt, ok := revocations.revokedAt[id]
+	return ok && !issuedAt.After(revokedAt)
+}
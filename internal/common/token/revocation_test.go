@@ -0,0 +1,125 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initRevocationTest() {
+	Init(&Config{
+		Secret:     "k^Cc#*mdnS9$nTOY6S1#1i7^e*o1ijSl",
+		Exp:        time.Minute * 30,
+		RefreshExp: time.Hour * 24 * 30,
+	})
+}
+
+func TestVerifyToken_IssuedBeforeRevocation_ReturnsRevoked(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	initRevocationTest()
+	tokenStr, _, _ := GenerateAccessToken("revoked1")
+
+	// Act
+	RevokeUserTokens("revoked1")
+	_, err := VerifyToken(tokenStr)
+
+	// Assert
+	assert.Equal(ErrTokenRevoked, err)
+}
+
+func TestVerifyToken_IssuedAfterRevocation_Succeeds(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	initRevocationTest()
+	RevokeUserTokens("revoked2")
+	tokenStr, _, _ := GenerateAccessToken("revoked2")
+
+	// Act
+	id, err := VerifyToken(tokenStr)
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal("revoked2", id)
+}
+
+func TestVerifyToken_OfOtherUser_Succeeds(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	initRevocationTest()
+	tokenStr, _, _ := GenerateAccessToken("other")
+
+	// Act
+	RevokeUserTokens("revoked3")
+	id, err := VerifyToken(tokenStr)
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal("other", id)
+}
+
+func TestUserTokensRevoked_OnRevocation_IsClosed(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	initRevocationTest()
+	revoked, stopRevoked := UserTokensRevoked("revoked4")
+	defer stopRevoked()
+	other, stopOther := UserTokensRevoked("other")
+	defer stopOther()
+
+	// Act
+	RevokeUserTokens("revoked4")
+	next, stopNext := UserTokensRevoked("revoked4")
+	defer stopNext()
+
+	// Assert
+	assert.True(isClosed(revoked))
+	assert.False(isClosed(other))
+	assert.False(isClosed(next))
+}
+
+func TestUserTokensRevoked_AfterLastStop_IsNoLongerWatched(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	initRevocationTest()
+	_, stop1 := UserTokensRevoked("watched")
+	_, stop2 := UserTokensRevoked("watched")
+
+	// Act
+	stop1()
+	stop1()
+	watchersAfterFirstStop := RevocationWatchers("watched")
+	stop2()
+
+	// Assert
+	assert.Equal(1, watchersAfterFirstStop)
+	assert.NotContains(revocations.watches, "watched")
+}
+
+func TestUserTokensRevoked_StopAfterRevocation_KeepsNewWatch(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	initRevocationTest()
+	_, stopOld := UserTokensRevoked("watched")
+	RevokeUserTokens("watched")
+	next, stopNext := UserTokensRevoked("watched")
+
+	// Act
+	stopOld()
+	RevokeUserTokens("watched")
+	stopNext()
+
+	// Assert
+	assert.True(isClosed(next))
+	assert.NotContains(revocations.watches, "watched")
+}
+
+func isClosed(channel <-chan struct{}) bool {
+	select {
+	case <-channel:
+		return true
+	default:
+		return false
+	}
+}
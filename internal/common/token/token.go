@@ -1,6 +1,7 @@
 package token
 
 import (
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt"
@@ -12,57 +13,103 @@ func IsTokenExpiredError(err error) bool {
 	return err == ErrTokenExpired
 }
 
-var conf *Config
+// IsTokenRevokedError returns true if error is TokenRevoked error
+func IsTokenRevokedError(err error) bool {
+	return err == ErrTokenRevoked
+}
+
+// claims are the claims of the access and refresh tokens.
+type claims struct {
+	jwt.StandardClaims
+	// IssuedAtNano is the issue time in nanoseconds, so that the tokens issued
+	// right after a revocation of the user tokens are accepted.
+	IssuedAtNano int64 `json:"iat_ns,omitempty"`
+}
+
+func newClaims(id string, now time.Time, exp time.Duration) *claims {
+	return &claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        id,
+			ExpiresAt: now.UTC().Add(exp).Unix(),
+		},
+		IssuedAtNano: now.UnixNano(),
+	}
+}
+
+var (
+	conf     *Config
+	confLock sync.RWMutex
+)
 
 //Init Token sets the global configuration to be used for token instances.
+//Can be called again to apply a new configuration, e.g. on reload.
 func Init(config *Config) {
+	confLock.Lock()
+	defer confLock.Unlock()
 	conf = config
 }
 
+func getConfig() *Config {
+	confLock.RLock()
+	defer confLock.RUnlock()
+	return conf
+}
+
 //GenerateAccessToken creates a new jwt token using the provided id.
 func GenerateAccessToken(id string) (string, int64, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Id:        id,
-		ExpiresAt: time.Now().UTC().Add(conf.Exp).Unix(),
-	})
+	conf := getConfig()
+	token := jwt.NewWithClaims(
+		jwt.SigningMethodHS256, newClaims(id, time.Now(), conf.Exp))
 
 	tokenStr, err := token.SignedString([]byte(conf.Secret))
 	return tokenStr, int64(conf.Exp.Seconds()), err
 }
 
-//VerifyToken checks that the given token is valid.
+//VerifyToken checks that the given token is valid and was not revoked.
 func VerifyToken(tokenStr string) (string, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+	claims, err := verifyClaims(tokenStr)
+	if err != nil {
+		return "", err
+	}
+	return claims.Id, nil
+}
+
+//verifyClaims returns the claims of the given token if it is valid and was
+//not revoked.
+func verifyClaims(tokenStr string) (*claims, error) {
+	conf := getConfig()
+	token, err := jwt.ParseWithClaims(tokenStr, &claims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(conf.Secret), nil
 	})
 
 	if err != nil {
 		if ve, ok := err.(*jwt.ValidationError); ok {
 			if ve.Errors&jwt.ValidationErrorExpired != 0 {
-				return "", ErrTokenExpired
+				return nil, ErrTokenExpired
 			}
 		}
-		return "", errors.Errorf("%s is invalid", tokenStr)
+		return nil, errors.Errorf("%s is invalid", tokenStr)
 	}
 
 	if token == nil {
-		return "", errors.Errorf("not found token in %s:", tokenStr)
+		return nil, errors.Errorf("not found token in %s:", tokenStr)
 	}
 
-	claims, ok := token.Claims.(*jwt.StandardClaims)
+	claims, ok := token.Claims.(*claims)
 	if !ok {
-		return "", errors.Errorf("not found claims in %s", tokenStr)
+		return nil, errors.Errorf("not found claims in %s", tokenStr)
 	}
-	return claims.Id, nil
+	if isRevoked(claims.Id, time.Unix(0, claims.IssuedAtNano)) {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
 }
 
 //GenerateRefreshToken creates a refresh token for the given id.
 func GenerateRefreshToken(id string) (string, error) {
-	exp := time.Now().UTC().Add(conf.RefreshExp).Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Id:        id,
-		ExpiresAt: exp,
-	})
+	conf := getConfig()
+	token := jwt.NewWithClaims(
+		jwt.SigningMethodHS256, newClaims(id, time.Now(), conf.RefreshExp))
 
 	tokenStr, err := token.SignedString([]byte(conf.Secret))
 	return tokenStr, err
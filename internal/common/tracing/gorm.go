@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "tracing:span"
+
+// GormPlugin creates a span for every query executed with a context, using
+// the span of the context as parent.
+type GormPlugin struct{}
+
+// Name returns the name of the plugin.
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+// Initialize registers the callbacks of the plugin.
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").
+		Register("tracing:before_create", startGormSpan("create")); err != nil {
+		return err
+	}
+	if err := callbacks.Create().After("gorm:create").
+		Register("tracing:after_create", endGormSpan); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").
+		Register("tracing:before_query", startGormSpan("query")); err != nil {
+		return err
+	}
+	if err := callbacks.Query().After("gorm:query").
+		Register("tracing:after_query", endGormSpan); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").
+		Register("tracing:before_update", startGormSpan("update")); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:update").
+		Register("tracing:after_update", endGormSpan); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").
+		Register("tracing:before_delete", startGormSpan("delete")); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("gorm:delete").
+		Register("tracing:after_delete", endGormSpan); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").
+		Register("tracing:before_row", startGormSpan("row")); err != nil {
+		return err
+	}
+	if err := callbacks.Row().After("gorm:row").
+		Register("tracing:after_row", endGormSpan); err != nil {
+		return err
+	}
+	if err := callbacks.Raw().Before("gorm:raw").
+		Register("tracing:before_raw", startGormSpan("raw")); err != nil {
+		return err
+	}
+	return callbacks.Raw().After("gorm:raw").
+		Register("tracing:after_raw", endGormSpan)
+}
+
+func startGormSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Statement.Context == nil {
+			return
+		}
+		_, span := StartSpan(db.Statement.Context, "gorm."+operation)
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func endGormSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	span.SetAttributes(
+		StringAttribute("db.sql.table", db.Statement.Table),
+		StringAttribute("db.statement", db.Statement.SQL.String()))
+	var err error
+	if db.Error != gorm.ErrRecordNotFound {
+		err = db.Error
+	}
+	EndSpan(span, err)
+}
@@ -0,0 +1,15 @@
+package tracing
+
+// Config contains the configuration of the OpenTelemetry tracing.
+type Config struct {
+	Enabled bool `configkey:"app.tracing.enabled"`
+	// Exporter is "otlp" to send spans to an OTLP gRPC collector or "stdout"
+	// to print them.
+	Exporter string `configkey:"app.tracing.exporter" default:"otlp" validate:"oneof=otlp stdout"`
+	// Endpoint is the address of the OTLP collector.
+	Endpoint string `configkey:"app.tracing.endpoint" default:"localhost:4317"`
+	// Insecure disables TLS when connecting to the OTLP collector.
+	Insecure    bool    `configkey:"app.tracing.insecure"`
+	ServiceName string  `configkey:"app.tracing.service_name" default:"p2pdserver"`
+	SampleRatio float64 `configkey:"app.tracing.sample_ratio" default:"1" validate:"min=0,max=1"`
+}
@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"p2pderivatives-server/test"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type tracedModel struct {
+	ID   uint
+	Name string
+}
+
+func newRecorder() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(
+		sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}
+
+func TestGormPlugin_QueryWithContext_CreatesChildSpan(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	recorder := newRecorder()
+	ormInstance := test.InitializeORM(&tracedModel{})
+	defer ormInstance.Finalize()
+	db := ormInstance.GetDB()
+	assert.NoError(db.Use(GormPlugin{}))
+	ctx, parent := StartSpan(context.Background(), "parent")
+
+	// Act
+	var result []tracedModel
+	err := db.WithContext(ctx).Where("name = ?", "alice").Find(&result).Error
+	parent.End()
+
+	// Assert
+	assert.NoError(err)
+	spans := recorder.Ended()
+	assert.Len(spans, 2)
+	query := spans[0]
+	assert.Equal("gorm.query", query.Name())
+	assert.Equal(parent.SpanContext().SpanID(), query.Parent().SpanID())
+	assert.Equal(parent.SpanContext().TraceID(), query.SpanContext().TraceID())
+}
+
+func TestEndSpan_WithError_SetsErrorStatus(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	recorder := newRecorder()
+	_, span := StartSpan(context.Background(), "failing")
+
+	// Act
+	EndSpan(span, context.DeadlineExceeded)
+
+	// Assert
+	spans := recorder.Ended()
+	assert.Len(spans, 1)
+	assert.Equal("Error", spans[0].Status().Code.String())
+	assert.Len(spans[0].Events(), 1)
+}
@@ -0,0 +1,126 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+)
+
+// PostgreSQL error codes of transactions failing because of a concurrent
+// transaction.
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+const conflictCallbackName = "p2pd:tx_conflict"
+
+// RetryConfig contains the configuration of the retries of the transactions
+// failing because of a concurrent transaction.
+type RetryConfig struct {
+	// MaxRetries is the number of times a transaction is run again, 0 to
+	// disable retries.
+	MaxRetries int `configkey:"database.tx_retry.max_retries" default:"3" validate:"min=0"`
+	// Backoff is the delay before the first retry, doubled for each retry up
+	// to MaxBackoff.
+	Backoff    time.Duration `configkey:"database.tx_retry.backoff,duration" default:"20ms"`
+	MaxBackoff time.Duration `configkey:"database.tx_retry.max_backoff,duration" default:"500ms"`
+}
+
+// backoff returns the delay before the given retry, starting at 0, with a
+// random jitter so that conflicting transactions are not retried together.
+func (config *RetryConfig) backoff(retry int) time.Duration {
+	delay := config.Backoff
+	for i := 0; i < retry && delay < config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > config.MaxBackoff {
+		delay = config.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// ctxTxStateMarker is used to retrieve the state of the DB transaction from
+// the context of its statements.
+type ctxTxStateMarker struct{}
+
+var ctxTxStateKey = &ctxTxStateMarker{}
+
+// txState records whether a statement of a transaction failed because of a
+// concurrent transaction, as handlers usually do not return the DB errors,
+// and the functions to run once the transaction ends for good.
+type txState struct {
+	conflict     int32
+	onCompletion []func(committed bool)
+}
+
+func (state *txState) setConflict() {
+	atomic.StoreInt32(&state.conflict, 1)
+}
+
+func (state *txState) hasConflict() bool {
+	return atomic.LoadInt32(&state.conflict) == 1
+}
+
+// isConflictError reports whether the given error is due to a concurrent
+// transaction, in which case the transaction can be run again.
+func isConflictError(err error) bool {
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		code := pgErr.SQLState()
+		return code == sqlStateSerializationFailure || code == sqlStateDeadlockDetected
+	}
+	// SQLite fails without waiting for the busy timeout when a transaction
+	// which read data tries to write while another transaction is writing.
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// registerConflictCallbacks registers the gorm callbacks recording the
+// statements failing because of a concurrent transaction in the state of
+// their transaction.
+func registerConflictCallbacks(db *gorm.DB) {
+	callbacks := db.Callback()
+	if callbacks.Query().Get(conflictCallbackName) != nil {
+		return
+	}
+	callbacks.Create().Register(conflictCallbackName, recordConflict)
+	callbacks.Query().Register(conflictCallbackName, recordConflict)
+	callbacks.Update().Register(conflictCallbackName, recordConflict)
+	callbacks.Delete().Register(conflictCallbackName, recordConflict)
+	callbacks.Row().Register(conflictCallbackName, recordConflict)
+	callbacks.Raw().Register(conflictCallbackName, recordConflict)
+}
+
+func recordConflict(db *gorm.DB) {
+	if db.Error == nil || !isConflictError(db.Error) {
+		return
+	}
+	if state, ok := db.Statement.Context.Value(ctxTxStateKey).(*txState); ok {
+		state.setConflict()
+	}
+}
+
+// sleep waits for the given duration, and returns false if the context is
+// done before.
+func sleep(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,224 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"p2pderivatives-server/internal/common/grpc/pbbase"
+	"p2pderivatives-server/internal/common/metrics"
+	"p2pderivatives-server/internal/common/servererror"
+	"p2pderivatives-server/test"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+type pgError struct {
+	code string
+}
+
+func (err *pgError) Error() string {
+	return "pg error " + err.code
+}
+
+func (err *pgError) SQLState() string {
+	return err.code
+}
+
+func TestIsConflictError(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isConflictError(&pgError{code: sqlStateSerializationFailure}))
+	assert.True(isConflictError(&pgError{code: sqlStateDeadlockDetected}))
+	assert.False(isConflictError(&pgError{code: "23505"}))
+	assert.True(isConflictError(sqlite3.Error{Code: sqlite3.ErrBusy}))
+	assert.False(isConflictError(sqlite3.Error{Code: sqlite3.ErrConstraint}))
+	assert.False(isConflictError(errors.New("error")))
+}
+
+func TestRecordConflict_WithConflictError_SetsConflict(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	state := &txState{}
+	ctx := context.WithValue(context.Background(), ctxTxStateKey, state)
+	db := &gorm.DB{
+		Statement: &gorm.Statement{Context: ctx},
+		Error:     &pgError{code: sqlStateSerializationFailure},
+	}
+
+	// Act
+	recordConflict(db)
+
+	// Assert
+	assert.True(state.hasConflict())
+}
+
+func TestRetryConfigBackoff_IsBounded(t *testing.T) {
+	assert := assert.New(t)
+	config := &RetryConfig{Backoff: 10 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+
+	first := config.backoff(0)
+	last := config.backoff(20)
+
+	assert.True(first >= 5*time.Millisecond && first <= 10*time.Millisecond)
+	assert.True(last >= 50*time.Millisecond && last <= 100*time.Millisecond)
+}
+
+func TestTransactionInterceptorUnaryInterceptor_Conflict_RetriesHandler(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	retries := metrics.DBTransactionRetries.WithLabelValues(
+		pbbase.TxOption_ReadWrite.String())
+	initialRetries := testutil.ToFloat64(retries)
+	calls := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		if calls == 1 {
+			ctx.Value(ctxTxStateKey).(*txState).setConflict()
+			return nil, errors.New("could not serialize access")
+		}
+		return "response", nil
+	}
+
+	// Act
+	res, err := retryInterceptorTestHelper(&RetryConfig{MaxRetries: 3}, handler)
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal("response", res)
+	assert.Equal(2, calls)
+	assert.Equal(initialRetries+1, testutil.ToFloat64(retries))
+}
+
+func TestTransactionInterceptorUnaryInterceptor_Conflict_RunsAfterCommitOnce(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	calls := 0
+	runs := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		AfterCommit(ctx, func() { runs++ })
+		if calls == 1 {
+			ctx.Value(ctxTxStateKey).(*txState).setConflict()
+		}
+		return nil, nil
+	}
+
+	// Act
+	_, err := retryInterceptorTestHelper(&RetryConfig{MaxRetries: 3}, handler)
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(2, calls)
+	assert.Equal(1, runs)
+}
+
+func TestTransactionInterceptorUnaryInterceptor_Error_DoesNotRunAfterCommit(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	runs := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		AfterCommit(ctx, func() { runs++ })
+		return nil, errors.New("error")
+	}
+
+	// Act
+	_, err := retryInterceptorTestHelper(&RetryConfig{MaxRetries: 3}, handler)
+
+	// Assert
+	assert.Error(err)
+	assert.Equal(0, runs)
+}
+
+func TestTransactionInterceptorUnaryInterceptor_ConflictThenError_CompletesOnceNotCommitted(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	calls := 0
+	var completions []bool
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		OnCompletion(ctx, func(committed bool) {
+			completions = append(completions, committed)
+		})
+		if calls == 1 {
+			ctx.Value(ctxTxStateKey).(*txState).setConflict()
+		}
+		return nil, errors.New("error")
+	}
+
+	// Act
+	_, err := retryInterceptorTestHelper(&RetryConfig{MaxRetries: 3}, handler)
+
+	// Assert
+	assert.Error(err)
+	assert.Equal(2, calls)
+	assert.Equal([]bool{false}, completions)
+}
+
+func TestTransactionInterceptorUnaryInterceptor_ConflictAfterRetries_ReturnsAborted(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	calls := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		ctx.Value(ctxTxStateKey).(*txState).setConflict()
+		return nil, errors.New("could not serialize access")
+	}
+
+	// Act
+	_, err := retryInterceptorTestHelper(
+		&RetryConfig{MaxRetries: 2, MaxBackoff: time.Second}, handler)
+
+	// Assert
+	assert.Equal(3, calls)
+	assert.Equal(codes.Aborted, status.Code(err))
+	details, _ := servererror.DecodeStatusDetails(err)
+	assert.True(details.Retryable)
+	assert.Equal(time.Second, details.RetryDelay)
+	assert.Equal([]servererror.ErrorDetail{
+		{Code: servererror.ErrorDetailCodeConcurrentUpdate}}, details.Details)
+}
+
+func TestTransactionInterceptorUnaryInterceptor_OtherError_DoesNotRetry(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	calls := 0
+	handlerErr := errors.New("error")
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return nil, handlerErr
+	}
+
+	// Act
+	_, err := retryInterceptorTestHelper(&RetryConfig{MaxRetries: 3}, handler)
+
+	// Assert
+	assert.Equal(1, calls)
+	assert.Equal(handlerErr, err)
+}
+
+func retryInterceptorTestHelper(
+	retryConfig *RetryConfig,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	log := test.GetTestLogger(test.GetTestConfig())
+	ormInstance := test.InitializeORM()
+	defer ormInstance.Finalize()
+
+	return TransactionUnaryServerInterceptor(
+		log.NewEntry(),
+		func(s string) pbbase.TxOption { return pbbase.TxOption_ReadWrite },
+		defaultIsolationLevel,
+		retryConfig,
+		ormInstance)(
+		context.Background(),
+		nil,
+		&grpc.UnaryServerInfo{},
+		handler)
+}
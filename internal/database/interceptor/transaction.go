@@ -4,23 +4,45 @@ import (
 	"context"
 	"database/sql"
 	"p2pderivatives-server/internal/common/grpc/pbbase"
-
-	"github.com/cryptogarageinc/server-common-go/pkg/database/orm"
+	"p2pderivatives-server/internal/common/metrics"
+	"p2pderivatives-server/internal/common/servererror"
+	"p2pderivatives-server/internal/common/tracing"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 )
 
+// DB provides the database on which the transactions are opened, either a
+// PostgreSQL orm.ORM or an embedded sqlite.DB.
+type DB interface {
+	GetDB() *gorm.DB
+}
+
 // ctxTxMarker is used to retrieve the DB transaction from the context.
 type ctxTxMarker struct{}
 
+// ctxTxFactoryMarker is used to retrieve the transaction factory of streaming
+// methods from the context.
+type ctxTxFactoryMarker struct{}
+
 var (
-	ctxTxKey = &ctxTxMarker{}
+	ctxTxKey        = &ctxTxMarker{}
+	ctxTxFactoryKey = &ctxTxFactoryMarker{}
 )
 
+// txFactory opens the transactions of a method.
+type txFactory struct {
+	log            *logrus.Entry
+	txOption       pbbase.TxOption
+	isolationLevel sql.IsolationLevel
+	retryConfig    *RetryConfig
+	ormInstance    DB
+}
+
 type wrappedStream struct {
 	grpc.ServerStream
 	WrappedContext context.Context
@@ -31,40 +53,62 @@ func (w *wrappedStream) Context() context.Context {
 	return w.WrappedContext
 }
 
-// TransactionStreamServerInterceptor provides the DB transaction for streaming
-// methods which require it, and rollbacks in case of errors.
+// TransactionStreamServerInterceptor provides a transaction factory to the
+// streaming methods which require DB access. As streams can last for hours,
+// they do not get a transaction for their whole duration but open one for
+// each unit of work with RunInTx, so that they do not hold a DB connection
+// while waiting.
 func TransactionStreamServerInterceptor(
 	log *logrus.Entry,
 	txOption func(fullMethod string) pbbase.TxOption,
-	ormInstance *orm.ORM) grpc.StreamServerInterceptor {
+	isolationLevel func(fullMethod string) sql.IsolationLevel,
+	retryConfig *RetryConfig,
+	ormInstance DB) grpc.StreamServerInterceptor {
+	registerConflictCallbacks(ormInstance.GetDB())
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		subHandler := func(ctx context.Context) (interface{}, error) {
-			wStream := &wrappedStream{ss, ctx}
-			err := handler(srv, wStream)
-			return nil, err
+		option := txOption(info.FullMethod)
+		if option == pbbase.TxOption_NoTx {
+			return handler(srv, ss)
 		}
 
-		_, err := interceptor(
-			ss.Context(), log, txOption, ormInstance, info.FullMethod, subHandler)
-		return err
+		factory := &txFactory{
+			log:            log.WithField("method", info.FullMethod),
+			txOption:       option,
+			isolationLevel: isolationLevel(info.FullMethod),
+			retryConfig:    retryConfig,
+			ormInstance:    ormInstance,
+		}
+		ctx := context.WithValue(ss.Context(), ctxTxFactoryKey, factory)
+		return handler(srv, &wrappedStream{ss, ctx})
 	}
 }
 
 // TransactionUnaryServerInterceptor provides the DB transaction for methods
-// which require it, and rollbacks in case of errors.
+// which require it, and rollbacks in case of errors. Transactions failing
+// because of a concurrent transaction are run again with a backoff.
 func TransactionUnaryServerInterceptor(
 	log *logrus.Entry,
 	txOption func(fullMethod string) pbbase.TxOption,
-	ormInstance *orm.ORM) grpc.UnaryServerInterceptor {
+	isolationLevel func(fullMethod string) sql.IsolationLevel,
+	retryConfig *RetryConfig,
+	ormInstance DB) grpc.UnaryServerInterceptor {
+	registerConflictCallbacks(ormInstance.GetDB())
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		subHandler := func(ctx context.Context) (interface{}, error) {
-			res, err := handler(ctx, req)
-			return res, err
+		option := txOption(info.FullMethod)
+		if option == pbbase.TxOption_NoTx {
+			return handler(ctx, req)
 		}
 
-		res, err := interceptor(
-			ctx, log, txOption, ormInstance, info.FullMethod, subHandler)
-		return res, err
+		factory := &txFactory{
+			log:            log.WithField("method", info.FullMethod),
+			txOption:       option,
+			isolationLevel: isolationLevel(info.FullMethod),
+			retryConfig:    retryConfig,
+			ormInstance:    ormInstance,
+		}
+		return factory.run(ctx, func(ctx context.Context) (interface{}, error) {
+			return handler(ctx, req)
+		})
 	}
 }
 
@@ -82,61 +126,173 @@ func ExtractTx(ctx context.Context) *gorm.DB {
 	return tx
 }
 
-func interceptor(
+// RunInTx runs fn with a DB transaction in its context. Within unary methods
+// fn uses the transaction of the request. Within streaming methods a new
+// transaction with the option of the method is opened for fn, and committed
+// or rollbacked when it returns. fn is run with the given context if it has
+// neither a transaction nor a transaction factory.
+func RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if tx, ok := ctx.Value(ctxTxKey).(*gorm.DB); ok && tx != nil {
+		return fn(ctx)
+	}
+	factory, ok := ctx.Value(ctxTxFactoryKey).(*txFactory)
+	if !ok {
+		return fn(ctx)
+	}
+
+	_, err := factory.run(ctx, func(ctx context.Context) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// OnCompletion registers fn to be run once the transaction of the given
+// context ends without being run again, with whether it was committed, for
+// the side effects of a handler which must not be repeated when it is run
+// again because of a concurrent transaction. Read-only transactions are
+// considered committed if their handler succeeds. fn is run immediately, as
+// committed, if the context has no transaction.
+func OnCompletion(ctx context.Context, fn func(committed bool)) {
+	state, ok := ctx.Value(ctxTxStateKey).(*txState)
+	if !ok {
+		fn(true)
+		return
+	}
+	state.onCompletion = append(state.onCompletion, fn)
+}
+
+// AfterCommit registers fn to be run once the transaction of the given context
+// is committed, for the side effects of a handler which must neither happen
+// if its transaction is rollbacked nor be repeated when it is run again. fn
+// is run immediately if the context has no transaction.
+func AfterCommit(ctx context.Context, fn func()) {
+	OnCompletion(ctx, func(committed bool) {
+		if committed {
+			fn()
+		}
+	})
+}
+
+// complete runs the functions registered with OnCompletion.
+func (state *txState) complete(committed bool) {
+	for _, fn := range state.onCompletion {
+		fn(committed)
+	}
+}
+
+// run runs the handler in a transaction, running it again in a new
+// transaction if it fails because of a concurrent transaction.
+func (factory *txFactory) run(
 	ctx context.Context,
-	log *logrus.Entry,
-	txOption func(methodName string) pbbase.TxOption,
-	ormInstance *orm.ORM,
-	fullMethod string,
 	handler func(ctx context.Context) (interface{}, error)) (interface{}, error) {
-	log = log.WithField("method", fullMethod)
-
-	switch txOption(fullMethod) {
-	case pbbase.TxOption_NoTx:
-		return handler(ctx)
-	case pbbase.TxOption_ReadOnly:
-		return handleReadOnly(ctx, log, ormInstance, handler)
-	case pbbase.TxOption_ReadWrite:
-		return handleReadWrite(ctx, log, ormInstance, handler)
+	for retry := 0; ; retry++ {
+		var res interface{}
+		var err error
+		var state *txState
+		switch factory.txOption {
+		case pbbase.TxOption_ReadOnly:
+			res, state, err = factory.handleReadOnly(ctx, handler)
+		case pbbase.TxOption_ReadWrite:
+			res, state, err = factory.handleReadWrite(ctx, handler)
+		default:
+			panic("Unhandled tx option.")
+		}
+		if !state.hasConflict() {
+			state.complete(err == nil)
+			return res, err
+		}
+
+		if retry >= factory.retryConfig.MaxRetries ||
+			!sleep(ctx, factory.retryConfig.backoff(retry)) {
+			state.complete(false)
+			factory.log.Warnf(
+				"DB transaction failed because of a concurrent transaction after %d retries: %v",
+				retry, err)
+			return nil, servererror.GetGrpcStatus(ctx, servererror.WithRetryDelay(
+				servererror.NewErrorWithDetail(servererror.Aborted, "aborted because of a concurrent request", err,
+					servererror.ErrorDetailCodeConcurrentUpdate, nil),
+				factory.retryConfig.MaxBackoff)).Err()
+		}
+		metrics.DBTransactionRetries.WithLabelValues(factory.txOption.String()).Inc()
+		factory.log.Infof(
+			"retrying DB transaction failed because of a concurrent transaction: %v", err)
 	}
+}
 
-	panic("Unhandled tx option.")
+// begin opens a transaction whose statements record conflicts with
+// concurrent transactions in the returned state. The transaction is not bound
+// to the request context so that it is not rollbacked before the handler
+// returns.
+func (factory *txFactory) begin(readOnly bool) (*gorm.DB, *txState) {
+	state := &txState{}
+	txCtx := context.WithValue(context.Background(), ctxTxStateKey, state)
+	tx := factory.ormInstance.GetDB().WithContext(txCtx).Begin(&sql.TxOptions{
+		Isolation: factory.isolationLevel,
+		ReadOnly:  readOnly,
+	})
+	return tx, state
 }
 
-func handleReadOnly(
+// saveTx adds the transaction and its state to the context of the handler.
+func saveTx(ctx context.Context, tx *gorm.DB, state *txState) context.Context {
+	return context.WithValue(SaveTx(ctx, tx), ctxTxStateKey, state)
+}
+
+func (factory *txFactory) handleReadOnly(
 	ctx context.Context,
-	log *logrus.Entry,
-	ormInstance *orm.ORM,
-	handler func(ctx context.Context) (interface{}, error)) (interface{}, error) {
-	options := &sql.TxOptions{ReadOnly: true}
-	tx := ormInstance.GetDB().Begin(options)
-	res, err := handler(SaveTx(ctx, tx))
+	handler func(ctx context.Context) (interface{}, error)) (
+	res interface{}, state *txState, err error) {
+	ctx, span := tracing.StartSpan(ctx, "db.transaction",
+		trace.WithAttributes(tracing.StringAttribute(
+			"db.tx_option", pbbase.TxOption_ReadOnly.String())))
+	defer span.End()
+	tx, state := factory.begin(true)
+	res, err = handler(saveTx(ctx, tx, state))
 	tx.Rollback()
-	return res, err
+	metrics.DBTransactions.WithLabelValues(
+		pbbase.TxOption_ReadOnly.String(), metrics.TxResultRollback).Inc()
+	return res, state, err
 }
 
-func handleReadWrite(
+func (factory *txFactory) handleReadWrite(
 	ctx context.Context,
-	log *logrus.Entry,
-	ormInstance *orm.ORM,
-	handler func(ctx context.Context) (interface{}, error)) (interface{}, error) {
-	tx := ormInstance.GetDB().Begin()
-	newCtx := SaveTx(ctx, tx)
+	handler func(ctx context.Context) (interface{}, error)) (
+	res interface{}, state *txState, err error) {
+	log := factory.log
+	ctx, span := tracing.StartSpan(ctx, "db.transaction",
+		trace.WithAttributes(tracing.StringAttribute(
+			"db.tx_option", pbbase.TxOption_ReadWrite.String())))
+	defer span.End()
+	tx, state := factory.begin(false)
+	newCtx := saveTx(ctx, tx, state)
 
-	res, err := handler(newCtx)
+	res, err = handler(newCtx)
 
-	if err != nil {
+	if err != nil || state.hasConflict() {
 		if tx.Rollback(); tx.Error != nil {
 			log.Errorf("failed to rollback: %+v", tx.Error)
 		}
 		log.Infoln("DB transaction for the method handler is rollbacked", err)
-		return nil, err
+		metrics.DBTransactions.WithLabelValues(
+			pbbase.TxOption_ReadWrite.String(), metrics.TxResultRollback).Inc()
+		return nil, state, err
 	}
 
-	if tx.Commit(); tx.Error != nil {
+	_, commitSpan := tracing.StartSpan(ctx, "db.commit")
+	tx.Commit()
+	tracing.EndSpan(commitSpan, tx.Error)
+	if tx.Error != nil {
 		log.Errorf("failed to commit: %+v", tx.Error)
-		return nil, status.Errorf(codes.Internal, "failed to commit")
+		metrics.DBTransactions.WithLabelValues(
+			pbbase.TxOption_ReadWrite.String(), metrics.TxResultCommitError).Inc()
+		if isConflictError(tx.Error) {
+			state.setConflict()
+			return nil, state, tx.Error
+		}
+		return nil, state, status.Errorf(codes.Internal, "failed to commit")
 	}
+	metrics.DBTransactions.WithLabelValues(
+		pbbase.TxOption_ReadWrite.String(), metrics.TxResultCommit).Inc()
 	log.Debugf("commit succeeds")
-	return res, nil
+	return res, state, nil
 }
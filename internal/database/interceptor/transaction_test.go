@@ -2,11 +2,16 @@ package interceptor
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"testing"
+	"time"
 
 	"p2pderivatives-server/internal/common/grpc/pbbase"
+	"p2pderivatives-server/internal/common/metrics"
 	"p2pderivatives-server/test"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
 	"gorm.io/gorm"
@@ -61,6 +66,32 @@ func TestTransactionInterceptorUnaryInterceptor_NoTxOption_Panics(t *testing.T)
 	unaryInterceptorTestHelper(pbbase.TxOption_NoTx, handler)
 }
 
+func TestTransactionInterceptorUnaryInterceptor_RWOption_CountsCommitAndRollback(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	option := pbbase.TxOption_ReadWrite.String()
+	commits := metrics.DBTransactions.WithLabelValues(option, metrics.TxResultCommit)
+	rollbacks := metrics.DBTransactions.WithLabelValues(option, metrics.TxResultRollback)
+	initialCommits := testutil.ToFloat64(commits)
+	initialRollbacks := testutil.ToFloat64(rollbacks)
+
+	// Act
+	unaryInterceptorTestHelper(
+		pbbase.TxOption_ReadWrite,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+	unaryInterceptorTestHelper(
+		pbbase.TxOption_ReadWrite,
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, errors.New("error")
+		})
+
+	// Assert
+	assert.Equal(initialCommits+1, testutil.ToFloat64(commits))
+	assert.Equal(initialRollbacks+1, testutil.ToFloat64(rollbacks))
+}
+
 func TestTransactionInterceptorStreamInterceptor_RWTxOption_HasTx(t *testing.T) {
 	// Arrange
 	assert := assert.New(t)
@@ -68,8 +99,10 @@ func TestTransactionInterceptorStreamInterceptor_RWTxOption_HasTx(t *testing.T)
 
 	// Act
 	handler := func(srv interface{}, stream grpc.ServerStream) error {
-		retrievedTx = ExtractTx(stream.Context())
-		return nil
+		return RunInTx(stream.Context(), func(ctx context.Context) error {
+			retrievedTx = ExtractTx(ctx)
+			return nil
+		})
 	}
 
 	streamInterceptorTestHelper(pbbase.TxOption_ReadWrite, handler)
@@ -85,8 +118,10 @@ func TestTransactionInterceptorStreamInterceptor_ReadOnlyTxOption_HasTx(t *testi
 
 	// Act
 	handler := func(srv interface{}, stream grpc.ServerStream) error {
-		retrievedTx = ExtractTx(stream.Context())
-		return nil
+		return RunInTx(stream.Context(), func(ctx context.Context) error {
+			retrievedTx = ExtractTx(ctx)
+			return nil
+		})
 	}
 
 	streamInterceptorTestHelper(pbbase.TxOption_ReadOnly, handler)
@@ -108,6 +143,119 @@ func TestTransactionInterceptorStreamInterceptor_NoTxOption_Panics(t *testing.T)
 	streamInterceptorTestHelper(pbbase.TxOption_NoTx, handler)
 }
 
+func TestTransactionInterceptorStreamInterceptor_OutsideRunInTx_Panics(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+
+	// Act/Assert
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		assert.Panics(func() { ExtractTx(stream.Context()) })
+		return nil
+	}
+
+	streamInterceptorTestHelper(pbbase.TxOption_ReadWrite, handler)
+}
+
+func TestTransactionInterceptorStreamInterceptor_RunInTx_CountsCommitPerCall(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	option := pbbase.TxOption_ReadWrite.String()
+	commits := metrics.DBTransactions.WithLabelValues(option, metrics.TxResultCommit)
+	initialCommits := testutil.ToFloat64(commits)
+
+	// Act
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		for i := 0; i < 3; i++ {
+			err := RunInTx(stream.Context(), func(ctx context.Context) error {
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	streamInterceptorTestHelper(pbbase.TxOption_ReadWrite, handler)
+
+	// Assert
+	assert.Equal(initialCommits+3, testutil.ToFloat64(commits))
+}
+
+func TestTransactionInterceptorStreamInterceptor_ManyReceivers_DoNotExhaustPool(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	log := test.GetTestLogger(test.GetTestConfig())
+	ormInstance := test.InitializeORM()
+	defer ormInstance.Finalize()
+	sqlDB, _ := ormInstance.GetDB().DB()
+	sqlDB.SetMaxOpenConns(2)
+	txOption := func(s string) pbbase.TxOption { return pbbase.TxOption_ReadWrite }
+	streamInterceptor := TransactionStreamServerInterceptor(
+		log.NewEntry(), txOption, defaultIsolationLevel, &RetryConfig{}, ormInstance)
+	unaryInterceptor := TransactionUnaryServerInterceptor(
+		log.NewEntry(), txOption, defaultIsolationLevel, &RetryConfig{}, ormInstance)
+	nbReceivers := 20
+	release := make(chan struct{})
+	started := make(chan struct{}, nbReceivers)
+	errs := make(chan error, nbReceivers)
+	// Like ReceiveDlcMessages, the handler looks up the user and then waits
+	// for messages until the stream ends.
+	receiver := func(srv interface{}, stream grpc.ServerStream) error {
+		err := RunInTx(stream.Context(), func(ctx context.Context) error {
+			return ExtractTx(ctx).Exec("SELECT 1").Error
+		})
+		started <- struct{}{}
+		<-release
+		return err
+	}
+
+	// Act
+	for i := 0; i < nbReceivers; i++ {
+		go func() {
+			errs <- streamInterceptor(
+				nil,
+				&mockStream{MockContext: context.Background()},
+				&grpc.StreamServerInfo{},
+				receiver)
+		}()
+	}
+	for i := 0; i < nbReceivers; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			assert.FailNow("receivers could not get a DB connection")
+		}
+	}
+	unaryDone := make(chan error, 1)
+	go func() {
+		_, err := unaryInterceptor(
+			context.Background(),
+			nil,
+			&grpc.UnaryServerInfo{},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, ExtractTx(ctx).Exec("SELECT 1").Error
+			})
+		unaryDone <- err
+	}()
+
+	// Assert
+	select {
+	case err := <-unaryDone:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("request could not get a DB connection")
+	}
+	close(release)
+	for i := 0; i < nbReceivers; i++ {
+		assert.NoError(<-errs)
+	}
+}
+
+func defaultIsolationLevel(fullMethod string) sql.IsolationLevel {
+	return sql.LevelDefault
+}
+
 func unaryInterceptorTestHelper(
 	txOption pbbase.TxOption,
 	handler func(context.Context, interface{}) (interface{}, error)) {
@@ -123,6 +271,8 @@ func unaryInterceptorTestHelper(
 	TransactionUnaryServerInterceptor(
 		log.NewEntry(),
 		func(s string) pbbase.TxOption { return txOption },
+		defaultIsolationLevel,
+		&RetryConfig{},
 		ormInstance)(
 		context.Background(),
 		nil,
@@ -146,6 +296,8 @@ func streamInterceptorTestHelper(
 	TransactionStreamServerInterceptor(
 		log.NewEntry(),
 		func(s string) pbbase.TxOption { return txOption },
+		defaultIsolationLevel,
+		&RetryConfig{},
 		ormInstance)(
 		nil,
 		&mockStream{MockContext: context.Background()},
@@ -0,0 +1,154 @@
+package migration
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Migration is a versioned change of the database schema or data. Up applies
+// the change and Down reverts it. Both are run in a transaction in which the
+// migrations table is also updated.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// AppliedMigration is a record of the migrations table.
+type AppliedMigration struct {
+	Version   int    `gorm:"primaryKey; autoIncrement:false"`
+	Name      string `gorm:"size:255; not null"`
+	AppliedAt time.Time
+}
+
+// TableName returns the name of the table recording the applied migrations.
+func (AppliedMigration) TableName() string {
+	return "migrations"
+}
+
+// Status is the state of a migration in the database.
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies and reverts migrations on a database.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewMigrator creates a new Migrator for the given migrations.
+func NewMigrator(db *gorm.DB, migrations []Migration) *Migrator {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return &Migrator{db: db, migrations: sorted}
+}
+
+// Up applies the migrations that were not applied yet, in version order, and
+// returns the applied migrations.
+func (m *Migrator) Up() ([]Migration, error) {
+	applied, err := m.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&AppliedMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return done, errors.Wrapf(
+				err, "migration %d_%s failed", migration.Version, migration.Name)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down reverts the given number of applied migrations, latest first, and
+// returns the reverted migrations.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	applied, err := m.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&AppliedMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return done, errors.Wrapf(
+				err, "rollback of migration %d_%s failed", migration.Version, migration.Name)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Status returns the state of all the migrations, in version order.
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		record, ok := applied[migration.Version]
+		statuses = append(statuses, Status{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+// appliedMigrations returns the records of the migrations table by version,
+// creating the table if needed.
+func (m *Migrator) appliedMigrations() (map[int]AppliedMigration, error) {
+	if !m.db.Migrator().HasTable(&AppliedMigration{}) {
+		if err := m.db.Migrator().CreateTable(&AppliedMigration{}); err != nil {
+			return nil, errors.Wrap(err, "failed to create migrations table")
+		}
+	}
+	var records []AppliedMigration
+	if err := m.db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]AppliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
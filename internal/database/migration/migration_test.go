@@ -0,0 +1,175 @@
+package migration
+
+import (
+	"testing"
+
+	"p2pderivatives-server/internal/audit"
+	"p2pderivatives-server/internal/user/usercommon"
+	"p2pderivatives-server/test"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// newTestDB returns an empty in-memory database. The pool is limited to one
+// connection as each connection to an in-memory database has its own data.
+func newTestDB(t *testing.T) *gorm.DB {
+	db := test.InitializeORM().GetDB()
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return db
+}
+
+func TestMigratorUp_AppliesAllMigrations(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	db := newTestDB(t)
+	migrator := NewMigrator(db, Migrations)
+
+	// Act
+	applied, err := migrator.Up()
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(applied, len(Migrations))
+	assert.True(db.Migrator().HasTable(&usercommon.User{}))
+	assert.True(db.Migrator().HasColumn(&usercommon.User{}, "NormalizedName"))
+	assert.True(db.Migrator().HasColumn(&usercommon.User{}, "Version"))
+	assert.True(db.Migrator().HasColumn(&usercommon.User{}, "LastLoginAt"))
+	assert.True(db.Migrator().HasColumn(&usercommon.User{}, "DeletedAt"))
+	assert.True(db.Migrator().HasColumn(&usercommon.User{}, "TokensValidAfter"))
+	assert.True(db.Migrator().HasTable(&usercommon.NameReservation{}))
+	assert.True(db.Migrator().HasTable(&usercommon.Invite{}))
+	assert.True(db.Migrator().HasTable(&audit.Event{}))
+	statuses, err := migrator.Status()
+	assert.NoError(err)
+	for _, status := range statuses {
+		assert.True(status.Applied)
+	}
+}
+
+func TestMigratorUp_Twice_AppliesNothing(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	migrator := NewMigrator(newTestDB(t), Migrations)
+	migrator.Up()
+
+	// Act
+	applied, err := migrator.Up()
+
+	// Assert
+	assert.NoError(err)
+	assert.Empty(applied)
+}
+
+func TestMigratorUp_WithAutoMigratedDatabase_Succeeds(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	db := newTestDB(t)
+	db.AutoMigrate(
+		&usercommon.User{}, &usercommon.Invite{}, &audit.Event{},
+		&usercommon.NameReservation{})
+	migrator := NewMigrator(db, Migrations)
+
+	// Act
+	applied, err := migrator.Up()
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(applied, len(Migrations))
+}
+
+func TestMigratorUp_WithExistingUsers_SetsNormalizedNames(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	db := newTestDB(t)
+	migrator := NewMigrator(db, Migrations)
+	migrator.Up()
+	migrator.Down(len(Migrations) - 2)
+	users := []userV1{
+		{ID: "id1", Name: "Alice", Password: "p"},
+		{ID: "id2", Name: "alice", Password: "p"},
+		{ID: "id3", Name: "bad name", Password: "p"},
+	}
+	assert.NoError(db.Create(&users).Error)
+
+	// Act
+	_, err := migrator.Up()
+
+	// Assert
+	assert.NoError(err)
+	var migrated []userV3
+	db.Order("id").Find(&migrated)
+	assert.Len(migrated, 3)
+	assert.Equal("alice", *migrated[0].NormalizedName)
+	assert.Nil(migrated[1].NormalizedName)
+	assert.Nil(migrated[2].NormalizedName)
+}
+
+func TestMigratorDown_RevertsLatestMigrations(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	db := newTestDB(t)
+	migrator := NewMigrator(db, Migrations)
+	migrator.Up()
+
+	// Act
+	reverted, err := migrator.Down(len(Migrations) - 2)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(reverted, len(Migrations)-2)
+	assert.Equal(len(Migrations), reverted[0].Version)
+	assert.Equal(3, reverted[len(reverted)-1].Version)
+	assert.False(db.Migrator().HasColumn(&usercommon.User{}, "Version"))
+	assert.False(db.Migrator().HasColumn(&usercommon.User{}, "LastLoginAt"))
+	assert.False(db.Migrator().HasColumn(&usercommon.User{}, "DeletedAt"))
+	assert.False(db.Migrator().HasColumn(&usercommon.User{}, "TokensValidAfter"))
+	assert.False(db.Migrator().HasTable(&usercommon.NameReservation{}))
+	assert.False(db.Migrator().HasTable(&audit.Event{}))
+	assert.False(db.Migrator().HasColumn(&usercommon.User{}, "NormalizedName"))
+	assert.True(db.Migrator().HasTable(&usercommon.Invite{}))
+	statuses, _ := migrator.Status()
+	assert.True(statuses[1].Applied)
+	assert.False(statuses[2].Applied)
+}
+
+func TestMigratorDown_All_DropsTables(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	db := newTestDB(t)
+	migrator := NewMigrator(db, Migrations)
+	migrator.Up()
+
+	// Act
+	reverted, err := migrator.Down(len(Migrations) + 1)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(reverted, len(Migrations))
+	assert.False(db.Migrator().HasTable(&usercommon.User{}))
+}
+
+func TestMigratorUp_WithExistingUsers_SetsCreationTimes(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	db := newTestDB(t)
+	migrator := NewMigrator(db, Migrations)
+	migrator.Up()
+	migrator.Down(len(Migrations) - 5)
+	user := userV5{ID: "id1", Name: "alice", Password: "p", Version: 1}
+	assert.NoError(db.Create(&user).Error)
+
+	// Act
+	_, err := migrator.Up()
+
+	// Assert
+	assert.NoError(err)
+	var migrated userV6
+	assert.NoError(db.First(&migrated, "id = ?", "id1").Error)
+	assert.False(migrated.CreatedAt.IsZero())
+	assert.Nil(migrated.LastLoginAt)
+}
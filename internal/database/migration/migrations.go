@@ -0,0 +1,347 @@
+package migration
+
+import (
+	"time"
+
+	"p2pderivatives-server/internal/user/usercommon"
+
+	"gorm.io/gorm"
+)
+
+// Migrations lists the migrations of the server database. Migrations use
+// their own copy of the models as they were at the time of the migration, so
+// that later changes to the models do not change the migrations. Migrations
+// check the current schema before changing it so that they can be applied to
+// databases created with the auto-migration of previous versions.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "baseline",
+		Up: func(tx *gorm.DB) error {
+			return createTableIfNotExists(tx, &userV1{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&userV1{})
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_invites",
+		Up: func(tx *gorm.DB) error {
+			return createTableIfNotExists(tx, &inviteV2{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&inviteV2{})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_users_normalized_name",
+		Up:      addNormalizedNames,
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&userV3{}, "NormalizedName") {
+				if err := tx.Migrator().DropIndex(&userV3{}, "NormalizedName"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropColumn(&userV3{}, "NormalizedName")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_audit_events",
+		Up: func(tx *gorm.DB) error {
+			return createTableIfNotExists(tx, &auditEventV4{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&auditEventV4{})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_users_version",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&userV5{}, "Version") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&userV5{}, "Version")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&userV5{}, "Version")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_users_timestamps",
+		Up:      addUserTimestamps,
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&userV6{}, "LastLoginAt") {
+				if err := tx.Migrator().DropIndex(&userV6{}, "LastLoginAt"); err != nil {
+					return err
+				}
+			}
+			for _, column := range userV6TimestampColumns {
+				if err := tx.Migrator().DropColumn(&userV6{}, column); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_users_deleted_at",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&userV7{}, "DeletedAt") {
+				if err := tx.Migrator().AddColumn(&userV7{}, "DeletedAt"); err != nil {
+					return err
+				}
+			}
+			if tx.Migrator().HasIndex(&userV7{}, "DeletedAt") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&userV7{}, "DeletedAt")
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Migrator().HasIndex(&userV7{}, "DeletedAt") {
+				if err := tx.Migrator().DropIndex(&userV7{}, "DeletedAt"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropColumn(&userV7{}, "DeletedAt")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_name_reservations",
+		Up: func(tx *gorm.DB) error {
+			return createTableIfNotExists(tx, &nameReservationV8{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&nameReservationV8{})
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_users_tokens_valid_after",
+		Up: func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&userV9{}, "TokensValidAfter") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&userV9{}, "TokensValidAfter")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&userV9{}, "TokensValidAfter")
+		},
+	},
+}
+
+func createTableIfNotExists(tx *gorm.DB, model interface{}) error {
+	if tx.Migrator().HasTable(model) {
+		return nil
+	}
+	return tx.Migrator().CreateTable(model)
+}
+
+type userV1 struct {
+	ID                    string `gorm:"primary_key; size:255"`
+	Name                  string `gorm:"unique; not null; size:255"`
+	Password              string `gorm:"not null; size:256"`
+	RequireChangePassword bool   `gorm:"not null"`
+	RefreshToken          string `gorm:"size:255"`
+}
+
+func (userV1) TableName() string {
+	return "users"
+}
+
+type inviteV2 struct {
+	Code      string    `gorm:"primary_key; size:64"`
+	CreatorID string    `gorm:"size:255"`
+	MaxUses   int       `gorm:"not null"`
+	Uses      int       `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+func (inviteV2) TableName() string {
+	return "invites"
+}
+
+type userV3 struct {
+	ID                    string  `gorm:"primary_key; size:255"`
+	Name                  string  `gorm:"unique; not null; size:255"`
+	NormalizedName        *string `gorm:"uniqueIndex; size:255"`
+	Password              string  `gorm:"not null; size:256"`
+	RequireChangePassword bool    `gorm:"not null"`
+	RefreshToken          string  `gorm:"size:255"`
+}
+
+func (userV3) TableName() string {
+	return "users"
+}
+
+// addNormalizedNames adds the normalized name column and sets it for the
+// existing users, skipping users whose name is invalid or whose normalized
+// name conflicts with another user.
+func addNormalizedNames(tx *gorm.DB) error {
+	if !tx.Migrator().HasColumn(&userV3{}, "NormalizedName") {
+		if err := tx.Migrator().AddColumn(&userV3{}, "NormalizedName"); err != nil {
+			return err
+		}
+	}
+
+	var users []userV3
+	if err := tx.Where("normalized_name IS NULL").Order("id").Find(&users).Error; err != nil {
+		return err
+	}
+	taken := make(map[string]bool)
+	var existing []string
+	if err := tx.Model(&userV3{}).Where("normalized_name IS NOT NULL").
+		Pluck("normalized_name", &existing).Error; err != nil {
+		return err
+	}
+	for _, name := range existing {
+		taken[name] = true
+	}
+	for _, user := range users {
+		normalized, err := usercommon.NormalizeName(user.Name)
+		if err != nil || taken[normalized] {
+			continue
+		}
+		err = tx.Model(&userV3{}).Where("id = ?", user.ID).
+			Update("normalized_name", normalized).Error
+		if err != nil {
+			return err
+		}
+		taken[normalized] = true
+	}
+
+	if tx.Migrator().HasIndex(&userV3{}, "NormalizedName") {
+		return nil
+	}
+	return tx.Migrator().CreateIndex(&userV3{}, "NormalizedName")
+}
+
+type auditEventV4 struct {
+	ID        uint   `gorm:"primaryKey"`
+	Type      string `gorm:"size:32; index"`
+	UserID    string `gorm:"size:255; index"`
+	UserName  string `gorm:"size:255"`
+	SourceIP  string `gorm:"size:64"`
+	Success   bool
+	Detail    string    `gorm:"size:255"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (auditEventV4) TableName() string {
+	return "audit_events"
+}
+
+type userV5 struct {
+	ID                    string  `gorm:"primary_key; size:255"`
+	Name                  string  `gorm:"unique; not null; size:255"`
+	NormalizedName        *string `gorm:"uniqueIndex; size:255"`
+	Password              string  `gorm:"not null; size:256"`
+	RequireChangePassword bool    `gorm:"not null"`
+	RefreshToken          string  `gorm:"size:255"`
+	Version               uint    `gorm:"not null; default:1"`
+}
+
+func (userV5) TableName() string {
+	return "users"
+}
+
+type userV6 struct {
+	ID                    string  `gorm:"primary_key; size:255"`
+	Name                  string  `gorm:"unique; not null; size:255"`
+	NormalizedName        *string `gorm:"uniqueIndex; size:255"`
+	Password              string  `gorm:"not null; size:256"`
+	RequireChangePassword bool    `gorm:"not null"`
+	RefreshToken          string  `gorm:"size:255"`
+	Version               uint    `gorm:"not null; default:1"`
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	LastLoginAt           *time.Time `gorm:"index"`
+	LastSeenAt            *time.Time
+}
+
+func (userV6) TableName() string {
+	return "users"
+}
+
+var userV6TimestampColumns = []string{
+	"CreatedAt", "UpdatedAt", "LastLoginAt", "LastSeenAt"}
+
+// addUserTimestamps adds the timestamp columns of the users, setting the
+// creation and update times of the existing users to the migration time as
+// their actual creation time is unknown.
+func addUserTimestamps(tx *gorm.DB) error {
+	for _, column := range userV6TimestampColumns {
+		if tx.Migrator().HasColumn(&userV6{}, column) {
+			continue
+		}
+		if err := tx.Migrator().AddColumn(&userV6{}, column); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	err := tx.Model(&userV6{}).Where("created_at IS NULL").
+		UpdateColumns(map[string]interface{}{"created_at": now, "updated_at": now}).Error
+	if err != nil {
+		return err
+	}
+
+	if tx.Migrator().HasIndex(&userV6{}, "LastLoginAt") {
+		return nil
+	}
+	return tx.Migrator().CreateIndex(&userV6{}, "LastLoginAt")
+}
+
+type userV7 struct {
+	ID                    string  `gorm:"primary_key; size:255"`
+	Name                  string  `gorm:"unique; not null; size:255"`
+	NormalizedName        *string `gorm:"uniqueIndex; size:255"`
+	Password              string  `gorm:"not null; size:256"`
+	RequireChangePassword bool    `gorm:"not null"`
+	RefreshToken          string  `gorm:"size:255"`
+	Version               uint    `gorm:"not null; default:1"`
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	LastLoginAt           *time.Time `gorm:"index"`
+	LastSeenAt            *time.Time
+	DeletedAt             gorm.DeletedAt `gorm:"index"`
+}
+
+func (userV7) TableName() string {
+	return "users"
+}
+
+type nameReservationV8 struct {
+	NormalizedName string    `gorm:"primary_key; size:255"`
+	ExpiresAt      time.Time `gorm:"not null; index"`
+}
+
+func (nameReservationV8) TableName() string {
+	return "name_reservations"
+}
+
+type userV9 struct {
+	ID                    string  `gorm:"primary_key; size:255"`
+	Name                  string  `gorm:"unique; not null; size:255"`
+	NormalizedName        *string `gorm:"uniqueIndex; size:255"`
+	Password              string  `gorm:"not null; size:256"`
+	RequireChangePassword bool    `gorm:"not null"`
+	RefreshToken          string  `gorm:"size:255"`
+	Version               uint    `gorm:"not null; default:1"`
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	LastLoginAt           *time.Time `gorm:"index"`
+	LastSeenAt            *time.Time
+	DeletedAt             gorm.DeletedAt `gorm:"index"`
+	TokensValidAfter      *time.Time
+}
+
+func (userV9) TableName() string {
+	return "users"
+}
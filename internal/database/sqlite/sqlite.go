@@ -0,0 +1,62 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/cryptogarageinc/server-common-go/pkg/log"
+	"github.com/pkg/errors"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// DB is an embedded SQLite database stored in a file, which can be used
+// instead of a PostgreSQL server for single node deployments.
+type DB struct {
+	db    *gorm.DB
+	sqldb *sql.DB
+}
+
+// Open opens the database file of the configuration. The database uses
+// write-ahead logging so that reading transactions do not block writing ones,
+// and enforces foreign key constraints.
+func Open(config *Config, l *log.Log) (*DB, error) {
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create database directory")
+	}
+	params := url.Values{}
+	params.Set("_journal_mode", "WAL")
+	params.Set("_foreign_keys", "true")
+	params.Set("_busy_timeout", fmt.Sprint(config.BusyTimeout.Milliseconds()))
+	dsn := fmt.Sprintf("file:%s?%s", config.Path, params.Encode())
+
+	level := logger.Silent
+	if config.EnableLogging {
+		level = logger.Info
+	}
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.New(l.Logger, logger.Config{LogLevel: level}),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open database")
+	}
+	sqldb, err := db.DB()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not access sub sql database")
+	}
+	return &DB{db: db, sqldb: sqldb}, nil
+}
+
+// GetDB returns the gorm DB instance.
+func (d *DB) GetDB() *gorm.DB {
+	return d.db
+}
+
+// Finalize closes the database.
+func (d *DB) Finalize() error {
+	return d.sqldb.Close()
+}
@@ -0,0 +1,13 @@
+package sqlite
+
+import "time"
+
+// Config contains the configuration of the embedded SQLite database.
+type Config struct {
+	EnableLogging bool `configkey:"database.log"`
+	// Path is the path of the database file, created if it does not exist.
+	Path string `configkey:"database.sqlite.path" default:"p2pd.db" validate:"required"`
+	// BusyTimeout is the time waited for the lock held by another transaction
+	// before failing with a "database is locked" error.
+	BusyTimeout time.Duration `configkey:"database.sqlite.busy_timeout,duration" default:"5s"`
+}
@@ -0,0 +1,57 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"p2pderivatives-server/internal/database/migration"
+	"p2pderivatives-server/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestDB(t *testing.T) *DB {
+	config := &Config{
+		Path:        filepath.Join(t.TempDir(), "data", "p2pd.db"),
+		BusyTimeout: time.Second,
+	}
+	db, err := Open(config, test.GetTestLogger(test.GetTestConfig()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Finalize() })
+	return db
+}
+
+func TestOpen_SetsPragmas(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	db := openTestDB(t)
+
+	// Act
+	var journalMode string
+	var foreignKeys int
+	err1 := db.GetDB().Raw("PRAGMA journal_mode").Scan(&journalMode).Error
+	err2 := db.GetDB().Raw("PRAGMA foreign_keys").Scan(&foreignKeys).Error
+
+	// Assert
+	assert.NoError(err1)
+	assert.NoError(err2)
+	assert.Equal("wal", journalMode)
+	assert.Equal(1, foreignKeys)
+}
+
+func TestOpen_ApplyMigrations_Succeeds(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	db := openTestDB(t)
+	migrator := migration.NewMigrator(db.GetDB(), migration.Migrations)
+
+	// Act
+	applied, err := migrator.Up()
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(applied, len(migration.Migrations))
+}
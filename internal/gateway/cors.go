@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"net/http"
+	"net/url"
+	"p2pderivatives-server/internal/common/logging"
+
+	"github.com/rs/cors"
+)
+
+// allowedHeaders are the request headers browsers are allowed to send on
+// cross-origin requests to the gateway.
+var allowedHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	"Accept",
+	"X-Invite-Code",
+	"X-Pow-Challenge",
+	"X-Pow-Solution",
+	"X-Request-Id",
+}
+
+// NewOriginMatcher returns a function reporting whether cross-origin requests
+// from the given origin are allowed. The "*" origin allows all origins.
+func NewOriginMatcher(allowedOrigins []string) func(origin string) bool {
+	origins := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		origins[origin] = true
+	}
+	return func(origin string) bool {
+		return origins["*"] || origins[origin]
+	}
+}
+
+// withCORS wraps the given handler to answer preflight requests and set the
+// CORS headers of the responses to allowed origins.
+func withCORS(handler http.Handler, allowOrigin func(origin string) bool) http.Handler {
+	return cors.New(cors.Options{
+		AllowOriginFunc: allowOrigin,
+		AllowedMethods: []string{
+			http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+		AllowedHeaders: allowedHeaders,
+		ExposedHeaders: []string{MetaKeyErrorDetail, logging.MetaKeyRequestID},
+	}).Handler(handler)
+}
+
+// isWebSocketOriginAllowed reports whether the WebSocket upgrade request
+// comes from the server's own origin or from an allowed origin. Browsers do
+// not apply CORS to WebSocket connections.
+func isWebSocketOriginAllowed(r *http.Request, allowOrigin func(origin string) bool) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return true
+	}
+	return allowOrigin(origin)
+}
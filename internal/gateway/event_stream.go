@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"bytes"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+// MIMEEventStream is the content type of server-sent events. Requests
+// accepting it receive the messages of streaming calls as events instead of
+// newline-delimited JSON.
+const MIMEEventStream = "text/event-stream"
+
+// EventStreamMarshaler writes each message as the JSON data of a server-sent
+// event.
+type EventStreamMarshaler struct {
+	runtime.Marshaler
+}
+
+// ContentType returns the content type of server-sent events.
+func (m *EventStreamMarshaler) ContentType(v interface{}) string {
+	return MIMEEventStream
+}
+
+// Marshal returns the given message as a server-sent event without its
+// terminating blank line.
+func (m *EventStreamMarshaler) Marshal(v interface{}) ([]byte, error) {
+	data, err := m.Marshaler.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buffer.WriteString("data: ")
+		buffer.Write(line)
+		buffer.WriteByte('\n')
+	}
+	return buffer.Bytes(), nil
+}
+
+// Delimiter returns the separator of server-sent events.
+func (m *EventStreamMarshaler) Delimiter() []byte {
+	return []byte("\n")
+}
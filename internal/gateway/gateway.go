@@ -0,0 +1,142 @@
+package gateway
+
+import (
+	"context"
+	_ "embed" // for the OpenAPI document
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"p2pderivatives-server/internal/audit"
+	"p2pderivatives-server/internal/authentication"
+	"p2pderivatives-server/internal/common/logging"
+	"p2pderivatives-server/internal/user/usercontroller"
+
+	"github.com/gorilla/websocket"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/tmc/grpc-websocket-proxy/wsproxy"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// MetaKeyErrorDetail is the metadata key of the error details, set as
+// response header by the gateway.
+const MetaKeyErrorDetail = "x-error-detail"
+
+// webSocketPingInterval is the interval at which WebSocket connections are
+// checked for liveness.
+const webSocketPingInterval = 30 * time.Second
+
+// forwardedHeaders are the request headers passed to the gRPC services as
+// metadata with the same name, in addition to the authorization header.
+var forwardedHeaders = map[string]bool{
+	usercontroller.MetaKeyInviteCode:   true,
+	usercontroller.MetaKeyPowChallenge: true,
+	usercontroller.MetaKeyPowSolution:  true,
+	logging.MetaKeyRequestID:           true,
+}
+
+//go:embed openapi/p2pderivatives.swagger.json
+var openAPIDocument []byte
+
+// NewHandler returns an HTTP handler translating REST/JSON requests to calls
+// to the gRPC server at the given endpoint, and serving the OpenAPI document
+// of the routes on /openapi.json. Streaming routes can also be consumed over
+// WebSockets. Cross-origin requests are accepted from the origins allowed by
+// allowOrigin.
+func NewHandler(
+	ctx context.Context,
+	endpoint string,
+	dialOptions []grpc.DialOption,
+	allowOrigin func(origin string) bool) (http.Handler, error) {
+	jsonMarshaler := &runtime.JSONPb{
+		MarshalOptions:   protojson.MarshalOptions{EmitUnpopulated: true},
+		UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+	}
+	gatewayMux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(matchIncomingHeader),
+		runtime.WithOutgoingHeaderMatcher(matchOutgoingHeader),
+		runtime.WithErrorHandler(handleError),
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, jsonMarshaler),
+		runtime.WithMarshalerOption(MIMEEventStream, &EventStreamMarshaler{jsonMarshaler}),
+	)
+
+	registrations := []func(context.Context, *runtime.ServeMux, string, []grpc.DialOption) error{
+		usercontroller.RegisterUserHandlerFromEndpoint,
+		usercontroller.RegisterInviteHandlerFromEndpoint,
+		usercontroller.RegisterChallengeHandlerFromEndpoint,
+		usercontroller.RegisterUserAdminHandlerFromEndpoint,
+		usercontroller.RegisterStreamAuthHandlerFromEndpoint,
+		authentication.RegisterAuthenticationHandlerFromEndpoint,
+		audit.RegisterAuditHandlerFromEndpoint,
+	}
+	for _, register := range registrations {
+		if err := register(ctx, gatewayMux, endpoint, dialOptions); err != nil {
+			return nil, err
+		}
+	}
+
+	webSocketProxy := wsproxy.WebsocketProxy(gatewayMux,
+		wsproxy.WithForwardedHeaders(func(header string) bool {
+			return header == "Origin" || header == "Referer"
+		}),
+		wsproxy.WithRequestMutator(func(incoming *http.Request, outgoing *http.Request) *http.Request {
+			// Keep the client address for the x-forwarded-for metadata.
+			outgoing.RemoteAddr = incoming.RemoteAddr
+			return outgoing
+		}),
+		wsproxy.WithPingControl(webSocketPingInterval))
+
+	mux := http.NewServeMux()
+	mux.Handle("/", withCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			if !isWebSocketOriginAllowed(r, allowOrigin) {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+			webSocketProxy.ServeHTTP(w, r)
+			return
+		}
+		gatewayMux.ServeHTTP(w, r)
+	}), allowOrigin))
+	mux.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	})
+	return mux, nil
+}
+
+func matchIncomingHeader(key string) (string, bool) {
+	lowerKey := strings.ToLower(key)
+	if forwardedHeaders[lowerKey] {
+		return lowerKey, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// matchOutgoingHeader returns the request ID header as is, and prefixes the
+// other gRPC response headers as done by default.
+func matchOutgoingHeader(key string) (string, bool) {
+	if key == logging.MetaKeyRequestID {
+		return textproto.CanonicalMIMEHeaderKey(key), true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+// handleError writes the error details trailer of the gRPC call, if any, to
+// the x-error-detail header before writing the error response.
+func handleError(
+	ctx context.Context,
+	mux *runtime.ServeMux,
+	marshaler runtime.Marshaler,
+	w http.ResponseWriter,
+	r *http.Request,
+	err error) {
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		for _, detail := range md.TrailerMD.Get(MetaKeyErrorDetail) {
+			w.Header().Add(textproto.CanonicalMIMEHeaderKey(MetaKeyErrorDetail), detail)
+		}
+	}
+	runtime.DefaultHTTPErrorHandler(ctx, mux, marshaler, w, r, err)
+}
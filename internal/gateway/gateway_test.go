@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestMatchIncomingHeader_ForwardedHeader_ReturnsLowerCaseKey(t *testing.T) {
+	// Act
+	key, ok := matchIncomingHeader("X-Invite-Code")
+
+	// Assert
+	assert.True(t, ok)
+	assert.Equal(t, "x-invite-code", key)
+}
+
+func TestMatchIncomingHeader_OtherHeader_IsNotForwarded(t *testing.T) {
+	// Act
+	_, ok := matchIncomingHeader("X-Custom")
+
+	// Assert
+	assert.False(t, ok)
+}
+
+func TestEventStreamMarshaler_Marshal_ReturnsEventData(t *testing.T) {
+	// Arrange
+	marshaler := &EventStreamMarshaler{&runtime.JSONPb{}}
+	value, _ := structpb.NewStruct(map[string]interface{}{"name": "user1"})
+
+	// Act
+	data, err := marshaler.Marshal(value)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "data: {\"name\":\"user1\"}\n", string(data))
+	assert.Equal(t, MIMEEventStream, marshaler.ContentType(value))
+}
+
+func TestHandleError_WithErrorDetail_SetsHeader(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx := runtime.NewServerMetadataContext(context.Background(), runtime.ServerMetadata{
+		TrailerMD: metadata.Pairs(MetaKeyErrorDetail, "detail"),
+	})
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/v1/users", nil)
+	err := status.Error(codes.NotFound, "not found")
+
+	// Act
+	handleError(ctx, runtime.NewServeMux(), &runtime.JSONPb{}, recorder, request, err)
+
+	// Assert
+	assert.Equal(http.StatusNotFound, recorder.Code)
+	assert.Equal("detail", recorder.Header().Get("X-Error-Detail"))
+}
+
+func TestNewOriginMatcher_ReturnsWhetherOriginIsAllowed(t *testing.T) {
+	// Arrange
+	allowOrigin := NewOriginMatcher([]string{"https://wallet.example.com"})
+	allowAll := NewOriginMatcher([]string{"*"})
+
+	// Assert
+	assert.True(t, allowOrigin("https://wallet.example.com"))
+	assert.False(t, allowOrigin("https://other.example.com"))
+	assert.True(t, allowAll("https://other.example.com"))
+}
+
+func TestWithCORS_PreflightFromAllowedOrigin_AllowsRequest(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	handler := withCORS(http.NotFoundHandler(), NewOriginMatcher([]string{"https://wallet.example.com"}))
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodOptions, "/v1/auth/login", nil)
+	request.Header.Set("Origin", "https://wallet.example.com")
+	request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	request.Header.Set("Access-Control-Request-Headers", "authorization,content-type")
+
+	// Act
+	handler.ServeHTTP(recorder, request)
+
+	// Assert
+	assert.Equal("https://wallet.example.com", recorder.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(http.MethodPost, recorder.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestIsWebSocketOriginAllowed_ReturnsWhetherOriginIsAllowed(t *testing.T) {
+	// Arrange
+	allowOrigin := NewOriginMatcher([]string{"https://wallet.example.com"})
+	newRequest := func(origin string) *http.Request {
+		request := httptest.NewRequest(http.MethodGet, "http://server.example.com/v1/messages", nil)
+		request.Header.Set("Origin", origin)
+		return request
+	}
+
+	// Assert
+	assert.True(t, isWebSocketOriginAllowed(newRequest("https://server.example.com"), allowOrigin))
+	assert.True(t, isWebSocketOriginAllowed(newRequest("https://wallet.example.com"), allowOrigin))
+	assert.False(t, isWebSocketOriginAllowed(newRequest("https://other.example.com"), allowOrigin))
+}
@@ -0,0 +1,23 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"google.golang.org/grpc"
+)
+
+// NewGrpcWebHandler returns an HTTP handler serving the services of the given
+// server to gRPC-Web clients, over HTTP or WebSockets for streaming calls.
+// Cross-origin requests are accepted from the origins allowed by allowOrigin.
+func NewGrpcWebHandler(
+	server *grpc.Server,
+	allowOrigin func(origin string) bool) http.Handler {
+	return grpcweb.WrapServer(server,
+		grpcweb.WithOriginFunc(allowOrigin),
+		grpcweb.WithWebsockets(true),
+		grpcweb.WithWebsocketOriginFunc(func(r *http.Request) bool {
+			return isWebSocketOriginAllowed(r, allowOrigin)
+		}),
+	)
+}
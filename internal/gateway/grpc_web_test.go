@@ -0,0 +1,34 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestGrpcWebHandler_UnaryCall_Succeeds(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	handler := NewGrpcWebHandler(server, NewOriginMatcher(nil))
+	// Empty HealthCheckRequest in an uncompressed frame.
+	body := []byte{0, 0, 0, 0, 0}
+	request := httptest.NewRequest(
+		http.MethodPost, "/grpc.health.v1.Health/Check", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/grpc-web+proto")
+	recorder := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(recorder, request)
+
+	// Assert
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Contains(recorder.Body.String(), "grpc-status: 0")
+}
@@ -0,0 +1,47 @@
+package usercommon
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+)
+
+const inviteCodeLen = 18
+
+// Invite represents an invite code allowing users to register when the
+// registration mode is RegistrationModeInvite.
+type Invite struct {
+	Code      string    `gorm:"primary_key; size:64"`
+	CreatorID string    `gorm:"size:255"`
+	MaxUses   int       `gorm:"not null"`
+	Uses      int       `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// NewInvite creates a new Invite structure with a random code.
+func NewInvite(creatorID string, maxUses int, expiresAt time.Time) (*Invite, error) {
+	code, err := generateInviteCode()
+	if err != nil {
+		return nil, err
+	}
+	return &Invite{
+		Code:      code,
+		CreatorID: creatorID,
+		MaxUses:   maxUses,
+		Uses:      0,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// IsRedeemable returns whether the invite can still be used at the given time.
+func (invite *Invite) IsRedeemable(now time.Time) bool {
+	return invite.Uses < invite.MaxUses && now.Before(invite.ExpiresAt)
+}
+
+func generateInviteCode() (string, error) {
+	bytes := make([]byte, inviteCodeLen)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
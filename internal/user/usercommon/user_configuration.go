@@ -1,10 +1,34 @@
 package usercommon
 
+import "time"
+
 const passwordProtectSaltLen = 32
 const passwordProtectKeyLen = 32
 const passwordProtectTime = 3
 const passwordProtectMemory = 32 * 1024
 const passwordProtectThreads = 4
+const defaultInviteMaxUses = 1
+const defaultInviteValidity = 7 * 24 * time.Hour
+const defaultNamePattern = "^[a-z0-9][a-z0-9_.-]*$"
+const defaultDeletionGracePeriod = 30 * 24 * time.Hour
+const defaultNameReservation = 365 * 24 * time.Hour
+const defaultPurgeInterval = time.Hour
+const defaultStreamReauthNotice = time.Minute
+const defaultUserCacheSize = 10000
+const defaultUserCacheTTL = time.Minute
+
+var defaultReservedNames = []string{
+	"admin", "administrator", "root", "system", "server", "support"}
+
+// Registration modes restricting who can register a new user.
+const (
+	// RegistrationModeOpen lets anyone register.
+	RegistrationModeOpen = "open"
+	// RegistrationModeInvite requires a valid invite code to register.
+	RegistrationModeInvite = "invite"
+	// RegistrationModeClosed rejects all registrations.
+	RegistrationModeClosed = "closed"
+)
 
 // Config provides access to the configuration used by the user
 // related functionalities.
@@ -14,16 +38,70 @@ type Config struct {
 	PasswordTime    uint32 `configkey:"app.user.password_time" default:"3" validate:"min=3"`
 	PasswordMemory  uint32 `configkey:"app.user.password_memory" default:"32768"`
 	PasswordThreads uint8  `configkey:"app.user.password_threads" default:"4"`
+	// RegistrationMode is one of "open", "invite" or "closed".
+	RegistrationMode string `configkey:"app.user.registration_mode" default:"open" validate:"oneof=open invite closed"`
+	// InviteMaxUses and InviteValidity bound the invites created by users
+	// that are not administrators.
+	InviteMaxUses  int           `configkey:"app.user.invite_max_uses" default:"1" validate:"min=1"`
+	InviteValidity time.Duration `configkey:"app.user.invite_validity,duration" default:"168h"`
+	// NamePattern is a regular expression that normalized user names must
+	// match. The default only allows ASCII letters, digits and "_.-", which
+	// prevents registering homoglyphs of existing names.
+	NamePattern string `configkey:"app.user.name_pattern" default:"^[a-z0-9][a-z0-9_.-]*$"`
+	// ReservedNames lists names that cannot be registered, compared in their
+	// normalized form.
+	ReservedNames []string `configkey:"app.user.reserved_names" default:"admin,administrator,root,system,server,support"`
+	// AdminIDs lists the IDs of the users with administrator rights.
+	AdminIDs []string `configkey:"app.user.admin_ids"`
+	// DeletionGracePeriod is the time during which unregistered users can be
+	// restored, after which they are purged.
+	DeletionGracePeriod time.Duration `configkey:"app.user.deletion_grace_period,duration" default:"720h"`
+	// NameReservation is the time during which the names of purged users
+	// cannot be registered by other users.
+	NameReservation time.Duration `configkey:"app.user.name_reservation,duration" default:"8760h"`
+	// PurgeInterval is the interval at which the users whose grace period
+	// expired are purged.
+	PurgeInterval time.Duration `configkey:"app.user.purge_interval,duration" default:"1h"`
+	// StreamReauthNotice is the time before the expiration of the access
+	// token of a ReceiveDlcEvents stream at which the client is asked to
+	// renew it.
+	StreamReauthNotice time.Duration `configkey:"app.user.stream_reauth_notice,duration" default:"1m"`
+	// UserCacheSize is the maximum number of users cached by ID, 0 disabling
+	// the cache, and UserCacheTTL the time after which they are read again
+	// from the database.
+	UserCacheSize int           `configkey:"app.user.cache_size" default:"10000" validate:"min=0"`
+	UserCacheTTL  time.Duration `configkey:"app.user.cache_ttl,duration" default:"1m"`
+}
+
+// IsAdmin returns whether the user with the given ID is an administrator.
+func (c *Config) IsAdmin(userID string) bool {
+	for _, adminID := range c.AdminIDs {
+		if adminID == userID {
+			return true
+		}
+	}
+	return false
 }
 
 // DefaultUserConfiguration returns a user configuration with default values.
 // Mainly intended to be used for testing purpose.
 func DefaultUserConfiguration() *Config {
 	return &Config{
-		SaltLen:         passwordProtectSaltLen,
-		KeyLen:          passwordProtectKeyLen,
-		PasswordTime:    passwordProtectTime,
-		PasswordMemory:  passwordProtectMemory,
-		PasswordThreads: passwordProtectThreads,
+		SaltLen:             passwordProtectSaltLen,
+		KeyLen:              passwordProtectKeyLen,
+		PasswordTime:        passwordProtectTime,
+		PasswordMemory:      passwordProtectMemory,
+		PasswordThreads:     passwordProtectThreads,
+		RegistrationMode:    RegistrationModeOpen,
+		InviteMaxUses:       defaultInviteMaxUses,
+		InviteValidity:      defaultInviteValidity,
+		NamePattern:         defaultNamePattern,
+		ReservedNames:       defaultReservedNames,
+		DeletionGracePeriod: defaultDeletionGracePeriod,
+		NameReservation:     defaultNameReservation,
+		PurgeInterval:       defaultPurgeInterval,
+		StreamReauthNotice:  defaultStreamReauthNotice,
+		UserCacheSize:       defaultUserCacheSize,
+		UserCacheTTL:        defaultUserCacheTTL,
 	}
 }
@@ -0,0 +1,18 @@
+package usercommon
+
+import (
+	"context"
+	"p2pderivatives-server/internal/common/contexts"
+)
+
+// GetContextUser retrieves the authenticated user from the given context,
+// false if the request is not authenticated.
+func GetContextUser(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(contexts.User).(*User)
+	return user, ok && user != nil
+}
+
+// SetContextUser sets the given authenticated user to the given context.
+func SetContextUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, contexts.User, user)
+}
@@ -2,6 +2,7 @@ package usercommon
 
 import (
 	"context"
+	"time"
 )
 
 // ServiceIf an interface representing a service to interact with user
@@ -19,6 +20,11 @@ type ServiceIf interface {
 	ChangeUserPassword(ctx context.Context, userID, newPassword, oldPassword string) (*User, error)
 	RefreshUserToken(ctx context.Context, refreshToken string) (*TokenInfo, error)
 	RevokeRefreshToken(ctx context.Context, refreshToken string) error
+	CreateInvite(ctx context.Context, creatorID string, maxUses int, validity time.Duration) (*Invite, error)
+	RedeemInvite(ctx context.Context, code string) error
+	UpdateLastSeen(ctx context.Context, userID string) error
+	RestoreUser(ctx context.Context, name string) (*User, error)
+	PurgeDeletedUsers(ctx context.Context) ([]User, error)
 }
 
 // RepositoryIf is used to interact with a storage layer for User data.
@@ -34,4 +40,14 @@ type RepositoryIf interface {
 	CreateUsers(ctx context.Context, users []*User) error
 	DeleteUsers(ctx context.Context, users []*User) error
 	UpdateUsers(ctx context.Context, users []*User) error
+	UpdateLastLogin(ctx context.Context, userID string, at time.Time) error
+	UpdateLastSeen(ctx context.Context, userID string, at time.Time) error
+	IsNameTaken(ctx context.Context, normalizedName string, now time.Time) (bool, error)
+	FindDeletedUser(ctx context.Context, normalizedName string) (*User, error)
+	RestoreUser(ctx context.Context, user *User) error
+	PurgeUsers(ctx context.Context, deletedBefore time.Time, reservedUntil time.Time) ([]User, error)
+	DeleteExpiredNameReservations(ctx context.Context, now time.Time) error
+	FindInvite(ctx context.Context, code string) (*Invite, error)
+	CreateInvite(ctx context.Context, invite *Invite) error
+	UseInvite(ctx context.Context, invite *Invite) error
 }
@@ -1,14 +1,48 @@
 package usercommon
 
-import "github.com/google/uuid"
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrOptimisticLock is returned by the repository when updating a user that
+// was modified by another transaction since it was read.
+var ErrOptimisticLock = errors.New("user was modified by another transaction")
 
 // User represents a user in the system.
 type User struct {
-	ID                    string `gorm:"primary_key; size:255"`
-	Name                  string `gorm:"unique; not null; size:255"`
-	Password              string `gorm:"not null; size:256"`
-	RequireChangePassword bool   `gorm:"not null"`
-	RefreshToken          string `gorm:"size:255"`
+	ID                    string  `gorm:"primary_key; size:255"`
+	Name                  string  `gorm:"unique; not null; size:255"`
+	NormalizedName        *string `gorm:"uniqueIndex; size:255"` // set from Name by the repository
+	Password              string  `gorm:"not null; size:256"`
+	RequireChangePassword bool    `gorm:"not null"`
+	RefreshToken          string  `gorm:"size:255"`
+	// Version is incremented by each update of the user, which fails if the
+	// user was updated since it was read.
+	Version   uint `gorm:"not null; default:1"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// LastLoginAt and LastSeenAt are the times of the last login and of the
+	// last connection or disconnection of a message stream, nil if none.
+	LastLoginAt *time.Time `gorm:"index"`
+	LastSeenAt  *time.Time
+	// TokensValidAfter is the time of the last revocation of the tokens of
+	// the user, the access tokens issued until then being rejected, nil if
+	// none.
+	TokensValidAfter *time.Time
+	// DeletedAt is set when the user unregisters. Deleted users are ignored by
+	// queries, and can be restored until they are purged.
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// NameReservation prevents the normalized name of a purged user from being
+// registered by another user until it expires.
+type NameReservation struct {
+	NormalizedName string    `gorm:"primary_key; size:255"`
+	ExpiresAt      time.Time `gorm:"not null; index"`
 }
 
 // Condition represents conditions when looking up users.
@@ -19,6 +53,9 @@ type Condition struct {
 	Limit          int
 	SortConditions []string
 	IDs            []string
+	// InactiveSince restricts the users to the ones that did not log in since
+	// the given time, using their creation time if they never logged in.
+	InactiveSince time.Time
 }
 
 //TokenInfo represents information about a user's JWT token.
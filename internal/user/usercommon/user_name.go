@@ -0,0 +1,24 @@
+package usercommon
+
+import (
+	"errors"
+
+	"golang.org/x/text/secure/precis"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeName returns the canonical form of the given user name, used to
+// prevent registering names that only differ by case, width or compatibility
+// characters. The name is NFKC normalized and then prepared using the PRECIS
+// UsernameCaseMapped profile (RFC 8265), which fails for names containing
+// disallowed characters such as spaces or control characters.
+func NormalizeName(name string) (string, error) {
+	normalized, err := precis.UsernameCaseMapped.String(norm.NFKC.String(name))
+	if err != nil {
+		return "", err
+	}
+	if normalized == "" {
+		return "", errors.New("empty user name")
+	}
+	return normalized, nil
+}
@@ -0,0 +1,35 @@
+package usercommon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUser_NormalizeName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+		isOk     bool
+	}{
+		{name: "Lower     :", input: "alice", expected: "alice", isOk: true},
+		{name: "Mixed case:", input: "Alice", expected: "alice", isOk: true},
+		{name: "Upper case:", input: "ALICE", expected: "alice", isOk: true},
+		{name: "Fullwidth :", input: "Ａｌｉｃｅ", expected: "alice", isOk: true},
+		{name: "Space     :", input: "alice bob", isOk: false},
+		{name: "Empty     :", input: "", isOk: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := NormalizeName(tc.input)
+			if tc.isOk {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, actual)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
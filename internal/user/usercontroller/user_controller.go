@@ -2,11 +2,21 @@ package usercontroller
 
 import (
 	"context"
+	"p2pderivatives-server/internal/audit"
 	"p2pderivatives-server/internal/common/contexts"
+	"p2pderivatives-server/internal/common/metrics"
+	"p2pderivatives-server/internal/common/pow"
 	"p2pderivatives-server/internal/common/servererror"
+	"p2pderivatives-server/internal/common/token"
+	"p2pderivatives-server/internal/common/tracing"
+	"p2pderivatives-server/internal/database/interceptor"
 	"p2pderivatives-server/internal/user/usercommon"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 var empty *Empty = &Empty{}
@@ -22,40 +32,99 @@ const (
 
 const pingTimeout = 50 * time.Millisecond
 
+const (
+	defaultListUsersLimit = 100
+	maxListUsersLimit     = 1000
+)
+
+// MetaKeyInviteCode is the metadata key used to provide an invite code when
+// registering a user.
+const MetaKeyInviteCode = "x-invite-code"
+
+const (
+	// MetaKeyPowChallenge is the metadata key used to provide the proof of work
+	// challenge when registering a user.
+	MetaKeyPowChallenge = "x-pow-challenge"
+	// MetaKeyPowSolution is the metadata key used to provide the solution to
+	// the proof of work challenge when registering a user.
+	MetaKeyPowSolution = "x-pow-solution"
+)
+
 type dlcMessageWithAck struct {
 	message *DlcMessage
 	ackChan chan int
+	// spanContext identifies the span of the sender, used to link the
+	// delivery span of the receiver.
+	spanContext trace.SpanContext
+}
+
+// dlcReceiver is the channel through which a ReceiveDlcMessages stream gets
+// the messages sent to its user.
+type dlcReceiver struct {
+	messages chan *dlcMessageWithAck
+	// closed is closed, under channelLock, when the receiver is removed, after
+	// which its messages are no longer read.
+	closed chan void
+}
+
+type userChannelsType = map[string]map[*dlcReceiver]void
+
+// streamExpiry tracks the expiration of the access token of a
+// ReceiveDlcMessages stream. Its timer channels are nil, and so never ready,
+// for streams authenticated with a client certificate.
+type streamExpiry struct {
+	renewals    chan time.Time
+	expiresAt   time.Time
+	reauthTimer *time.Timer
+	expiryTimer *time.Timer
 }
-type userChannelsType = map[string]map[chan *dlcMessageWithAck]void
 
 // Controller represents the grpc server serving the user services.
 type Controller struct {
 	userService  usercommon.ServiceIf
 	userChannels userChannelsType
-	channelLock  sync.RWMutex
-	config       *usercommon.Config
+	// userStreams holds the expiries of the streams of each user ID, used to
+	// renew them. Protected by channelLock.
+	userStreams map[string]map[*streamExpiry]void
+	channelLock sync.RWMutex
+	config      *usercommon.Config
+	challenger  *pow.Challenger
+	auditLogger *audit.Logger
 }
 
 // NewController creates a new Controller struct.
 func NewController(
 	service usercommon.ServiceIf,
 	config *usercommon.Config) *Controller {
-	channels := make(map[string]map[chan *dlcMessageWithAck]void)
+	channels := make(map[string]map[*dlcReceiver]void)
 	return &Controller{
 		userService:  service,
 		userChannels: channels,
+		userStreams:  make(map[string]map[*streamExpiry]void),
 		config:       config,
 	}
 }
 
+// SetChallenger sets the challenger used to require a proof of work on user
+// registration.
+func (controller *Controller) SetChallenger(challenger *pow.Challenger) {
+	controller.challenger = challenger
+}
+
+// SetAuditLogger sets the logger used to record account events.
+func (controller *Controller) SetAuditLogger(auditLogger *audit.Logger) {
+	controller.auditLogger = auditLogger
+}
+
 // Close cleans up the server resources.
 func (controller *Controller) Close() {
 	controller.channelLock.Lock()
-	for _, channels := range controller.userChannels {
-		for channel := range channels {
-			close(channel)
+	for _, receivers := range controller.userChannels {
+		for receiver := range receivers {
+			close(receiver.closed)
 		}
 	}
+	controller.userChannels = make(userChannelsType)
 	controller.channelLock.Unlock()
 }
 
@@ -63,6 +132,28 @@ func (controller *Controller) Close() {
 func (controller *Controller) RegisterUser(
 	ctx context.Context,
 	request *UserRegisterRequest) (*UserRegisterResponse, error) {
+	if controller.config.RegistrationMode == usercommon.RegistrationModeClosed {
+		return nil, newDetailedError(ctx, servererror.PermissionDenied,
+			"Registration is closed.", servererror.ErrorDetailCodeRegistrationClosed)
+	}
+
+	if controller.challenger.IsEnabled() {
+		challenge := getMetadataValue(ctx, MetaKeyPowChallenge)
+		solution := getMetadataValue(ctx, MetaKeyPowSolution)
+		if challenge == "" || solution == "" {
+			return nil, newDetailedError(ctx, servererror.InvalidArguments,
+				"A proof of work is required to register.",
+				servererror.ErrorDetailCodeProofOfWorkRequired)
+		}
+		err := controller.challenger.Verify(
+			challenge, request.Name, solution, time.Now())
+		if err != nil {
+			return nil, newDetailedError(ctx, servererror.PermissionDenied,
+				"Invalid proof of work: "+err.Error(),
+				servererror.ErrorDetailCodeProofOfWorkInvalid)
+		}
+	}
+
 	userModel := usercommon.NewUser(request.Name, request.Password)
 
 	existingUser, err := controller.userService.FindFirstUser(ctx, &usercommon.User{
@@ -70,8 +161,21 @@ func (controller *Controller) RegisterUser(
 	}, nil)
 
 	if existingUser != nil {
-		return nil, servererror.NewAlreadyExistsStatus(
-			"User with same name or account already exists.").Err()
+		return nil, newDetailedError(ctx, servererror.AlreadyExistError,
+			"User with same name or account already exists.",
+			servererror.ErrorDetailCodeNameTaken)
+	}
+
+	if controller.config.RegistrationMode == usercommon.RegistrationModeInvite {
+		inviteCode := getMetadataValue(ctx, MetaKeyInviteCode)
+		if inviteCode == "" {
+			return nil, newDetailedError(ctx, servererror.PermissionDenied,
+				"An invite code is required to register.",
+				servererror.ErrorDetailCodeInviteRequired)
+		}
+		if err := controller.userService.RedeemInvite(ctx, inviteCode); err != nil {
+			return nil, servererror.GetGrpcStatus(ctx, err).Err()
+		}
 	}
 
 	createdUser, err := controller.userService.CreateUser(ctx, userModel)
@@ -80,6 +184,19 @@ func (controller *Controller) RegisterUser(
 		return nil, servererror.GetGrpcStatus(ctx, err).Err()
 	}
 
+	if controller.challenger.IsEnabled() {
+		interceptor.AfterCommit(ctx, func() {
+			controller.challenger.RecordRegistration(time.Now())
+		})
+	}
+
+	controller.auditLogger.Record(ctx, &audit.Event{
+		Type:     audit.EventRegistration,
+		UserID:   createdUser.ID,
+		UserName: createdUser.Name,
+		Success:  true,
+	})
+
 	response := UserRegisterResponse{
 		Id:   createdUser.ID,
 		Name: createdUser.Name,
@@ -93,10 +210,9 @@ func (controller *Controller) UnregisterUser(
 	ctx context.Context,
 	request *UnregisterUserRequest) (*Empty, error) {
 
-	userID := contexts.GetUserID(ctx)
-	user, err := controller.userService.FindFirstUser(ctx, &usercommon.User{ID: userID}, nil)
+	user, err := contextUser(ctx)
 	if err != nil {
-		return nil, servererror.GetGrpcStatus(ctx, err).Err()
+		return nil, err
 	}
 
 	err = controller.userService.DeleteUser(ctx, user)
@@ -104,6 +220,13 @@ func (controller *Controller) UnregisterUser(
 		return nil, servererror.GetGrpcStatus(ctx, err).Err()
 	}
 
+	controller.auditLogger.Record(ctx, &audit.Event{
+		Type:     audit.EventDeletion,
+		UserID:   user.ID,
+		UserName: user.Name,
+		Success:  true,
+	})
+
 	return empty, nil
 }
 
@@ -112,8 +235,15 @@ func (controller *Controller) GetUserList(
 	empty *Empty,
 	stream User_GetUserListServer) error {
 	ctx := stream.Context()
-	userID := contexts.GetUserID(ctx)
-	users, err := controller.userService.GetAllUsers(ctx)
+	userID, err := contextUserID(ctx)
+	if err != nil {
+		return err
+	}
+	var users []usercommon.User
+	err = interceptor.RunInTx(ctx, func(ctx context.Context) (err error) {
+		users, err = controller.userService.GetAllUsers(ctx)
+		return err
+	})
 
 	if err != nil {
 		return servererror.GetGrpcStatus(stream.Context(), err).Err()
@@ -134,81 +264,171 @@ func (controller *Controller) GetUserList(
 func (controller *Controller) ReceiveDlcMessages(
 	empty *Empty,
 	stream User_ReceiveDlcMessagesServer) error {
-	ctx := stream.Context()
-	userID := contexts.GetUserID(ctx)
-	user, err := controller.userService.FindFirstUser(
-		ctx, &usercommon.User{ID: userID}, nil)
+	return controller.receiveDlcMessages(stream.Context(), stream.Send, nil)
+}
+
+// ReceiveDlcEvents enables receiving messages from other users pertaining to
+// the DLC protocol, and a re-authentication request before the expiration of
+// the stream.
+func (controller *Controller) ReceiveDlcEvents(
+	empty *Empty,
+	stream StreamAuth_ReceiveDlcEventsServer) error {
+	return controller.receiveDlcMessages(
+		stream.Context(),
+		func(message *DlcMessage) error {
+			return stream.Send(&DlcEvent{Event: &DlcEvent_Message{Message: message}})
+		},
+		func(expiresAt time.Time) error {
+			return stream.Send(&DlcEvent{Event: &DlcEvent_ReauthRequired{
+				ReauthRequired: &ReauthRequired{ExpiresAt: expiresAt.Unix()}}})
+		})
+}
+
+// receiveDlcMessages sends the messages sent to the user of the given context
+// with send until the stream ends. sendReauth, if not nil, is called with the
+// expiration time of the stream shortly before it expires.
+func (controller *Controller) receiveDlcMessages(
+	ctx context.Context,
+	send func(message *DlcMessage) error,
+	sendReauth func(expiresAt time.Time) error) error {
+	user, err := contextUser(ctx)
 	if err != nil {
-		return servererror.GetGrpcStatus(ctx, err).Err()
+		return err
 	}
 
-	dlcChannel := make(chan *dlcMessageWithAck, 10)
-	controller.addUserChannel(dlcChannel, user.Name)
-	for messageWithAck := range dlcChannel {
+	// The stream ends when the user tokens are revoked, which happens when
+	// the user changes its password, logs out or unregisters.
+	revoked, stopRevocationWatch := token.UserTokensRevoked(user.ID)
+	defer stopRevocationWatch()
+	// The stream also ends when its access token expires without being
+	// renewed.
+	expiry := controller.addUserStream(ctx, user.ID)
+	defer controller.removeUserStream(user.ID, expiry)
+	receiver := controller.addUserChannel(user.Name)
+	controller.updateLastSeen(ctx, user)
+	// The stream context is canceled when the client disconnects.
+	defer controller.updateLastSeen(contexts.Detach(ctx), user)
+	metrics.ActiveDlcStreams.Inc()
+	defer metrics.ActiveDlcStreams.Dec()
+	for {
+		var reauth <-chan time.Time
+		if sendReauth != nil {
+			reauth = expiry.reauth()
+		}
+		var messageWithAck *dlcMessageWithAck
+		select {
+		case <-revoked:
+			controller.removeUserChannel(user, receiver)
+			rejectPendingMessages(receiver)
+			return servererror.GetGrpcStatus(ctx, token.ErrTokenRevoked).Err()
+		case <-expiry.expired():
+			controller.removeUserChannel(user, receiver)
+			rejectPendingMessages(receiver)
+			return servererror.GetGrpcStatus(ctx, token.ErrTokenExpired).Err()
+		case expiresAt := <-expiry.renewals:
+			expiry.renew(expiresAt, controller.config.StreamReauthNotice)
+			continue
+		case <-reauth:
+			if err := sendReauth(expiry.expiresAt); err != nil {
+				controller.removeUserChannel(user, receiver)
+				rejectPendingMessages(receiver)
+				return err
+			}
+			continue
+		case <-ctx.Done():
+			controller.removeUserChannel(user, receiver)
+			rejectPendingMessages(receiver)
+			return ctx.Err()
+		case <-receiver.closed:
+			// The controller was closed.
+			rejectPendingMessages(receiver)
+			return nil
+		case messageWithAck = <-receiver.messages:
+		}
 		message := messageWithAck.message
 		ackChannel := messageWithAck.ackChan
 		if message.OrgName == user.Name {
 			continue
 		}
 
-		err := stream.Send(message)
+		_, span := tracing.StartSpan(ctx, "usercontroller.DeliverDlcMessage",
+			trace.WithLinks(trace.Link{SpanContext: messageWithAck.spanContext}))
+		err := send(message)
+		tracing.EndSpan(span, err)
 		if err != nil {
-			controller.removeUserChannel(user, dlcChannel)
+			controller.removeUserChannel(user, receiver)
 			ackChannel <- notOk
+			rejectPendingMessages(receiver)
 			return err
 		}
 
 		ackChannel <- ok
 	}
-
-	return nil
-
 }
 
 // SendDlcMessage enables sending a message to a user
 func (controller *Controller) SendDlcMessage(
 	ctx context.Context, message *DlcMessage) (*Empty, error) {
 
-	userID := contexts.GetUserID(ctx)
-
-	user, err := controller.userService.FindFirstUser(
-		ctx, &usercommon.User{ID: userID}, nil)
-
+	user, err := contextUser(ctx)
 	if err != nil {
-		return nil, servererror.GetGrpcStatus(ctx, err).Err()
+		return nil, err
 	}
 
 	message.OrgName = user.Name
 
 	destUserName := message.DestName
 
-	channels, err := controller.getUserChannels(destUserName)
+	receivers, err := controller.getUserChannels(destUserName)
 
 	if err != nil {
-		return nil, err
+		metrics.RelayedDlcMessages.WithLabelValues(metrics.RelayResultFailed).Inc()
+		return nil, servererror.GetGrpcStatus(ctx, err).Err()
 	}
 
-	nbChannels := 0
-	ackChannel := make(chan int, len(channels))
+	_, span := tracing.StartSpan(ctx, "usercontroller.RelayDlcMessage")
+	defer span.End()
+	// Each receiver acknowledges the message on its own channel, so that
+	// the acknowledgement of a removed receiver is not awaited.
+	ackChannels := make([]chan int, len(receivers))
+	start := time.Now()
 
-	for channel := range channels {
-		nbChannels++
-		channel <- &dlcMessageWithAck{message: message, ackChan: ackChannel}
+	for i, receiver := range receivers {
+		ackChannels[i] = make(chan int, 1)
+		select {
+		case receiver.messages <- &dlcMessageWithAck{
+			message:     message,
+			ackChan:     ackChannels[i],
+			spanContext: span.SpanContext(),
+		}:
+		case <-receiver.closed:
+			ackChannels[i] <- notOk
+		case <-ctx.Done():
+			metrics.RelayedDlcMessages.WithLabelValues(metrics.RelayResultFailed).Inc()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
 	}
 
 	hasOk := false
 
-	for nbChannels > 0 {
-		ack := <-ackChannel
+	for i, receiver := range receivers {
+		ack, err := awaitAck(ctx, receiver, ackChannels[i])
+		if err != nil {
+			metrics.RelayedDlcMessages.WithLabelValues(metrics.RelayResultFailed).Inc()
+			return nil, status.FromContextError(err).Err()
+		}
+		metrics.ObserveSince(metrics.DlcMessageAckLatency, start)
 		hasOk = hasOk || ack == ok
-		nbChannels--
 	}
 
 	if !hasOk {
-		return nil, servererror.NewUnavailableStatus(
-			"Peer connection returned error.").Err()
+		metrics.RelayedDlcMessages.WithLabelValues(metrics.RelayResultFailed).Inc()
+		return nil, newDetailedError(ctx, servererror.Unavailable,
+			"Peer connection returned error.", servererror.ErrorDetailCodePeerUnavailable)
 	}
 
+	metrics.RelayedDlcMessages.WithLabelValues(metrics.RelayResultRelayed).Inc()
+
 	return empty, nil
 }
 
@@ -217,12 +437,9 @@ func (controller *Controller) GetConnectedUsers(
 	empty *Empty,
 	stream User_GetConnectedUsersServer) error {
 	ctx := stream.Context()
-	userID := contexts.GetUserID(ctx)
-
-	user, err := controller.userService.FindFirstUser(
-		ctx, &usercommon.User{ID: userID}, nil)
+	user, err := contextUser(ctx)
 	if err != nil {
-		return servererror.GetGrpcStatus(ctx, err).Err()
+		return err
 	}
 
 	controller.pingDlcChannels()
@@ -241,6 +458,162 @@ func (controller *Controller) GetConnectedUsers(
 	return nil
 }
 
+// CreateInvite creates an invite code enabling new users to register.
+func (controller *Controller) CreateInvite(
+	ctx context.Context, request *CreateInviteRequest) (*InviteInfo, error) {
+	userID, err := contextUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invite, err := controller.userService.CreateInvite(
+		ctx,
+		userID,
+		int(request.MaxUses),
+		time.Duration(request.Validity)*time.Second)
+	if err != nil {
+		return nil, servererror.GetGrpcStatus(ctx, err).Err()
+	}
+
+	return &InviteInfo{
+		Code:      invite.Code,
+		MaxUses:   int32(invite.MaxUses),
+		ExpiresAt: invite.ExpiresAt.Unix(),
+	}, nil
+}
+
+// ListUsers returns the registered users matching the request with their
+// account and activity times, ordered by ID. Only administrators can list the
+// users.
+func (controller *Controller) ListUsers(
+	request *ListUsersRequest, stream UserAdmin_ListUsersServer) error {
+	ctx := stream.Context()
+	adminID, err := contextUserID(ctx)
+	if err != nil {
+		return err
+	}
+	if !controller.config.IsAdmin(adminID) {
+		return newDetailedError(ctx, servererror.PermissionDenied,
+			"Only administrators can list the users.", servererror.ErrorDetailCodeAdminRequired)
+	}
+
+	condition := &usercommon.Condition{
+		Name:           request.Name,
+		Offset:         int(request.Offset),
+		Limit:          int(request.Limit),
+		SortConditions: []string{"id"},
+	}
+	if condition.Limit <= 0 {
+		condition.Limit = defaultListUsersLimit
+	} else if condition.Limit > maxListUsersLimit {
+		condition.Limit = maxListUsersLimit
+	}
+	if request.InactiveSince > 0 {
+		condition.InactiveSince = time.Unix(request.InactiveSince, 0)
+	}
+
+	var users []usercommon.User
+	err = interceptor.RunInTx(ctx, func(ctx context.Context) (err error) {
+		users, err = controller.userService.FindUserByCondition(ctx, condition)
+		return err
+	})
+	if err != nil {
+		return servererror.GetGrpcStatus(ctx, err).Err()
+	}
+
+	for _, user := range users {
+		if err := stream.Send(userModelToDetails(&user)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RestoreUser restores a user deleted less than the deletion grace period
+// ago. Only available to administrators.
+func (controller *Controller) RestoreUser(
+	ctx context.Context, request *RestoreUserRequest) (*UserDetails, error) {
+	adminID, err := contextUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !controller.config.IsAdmin(adminID) {
+		return nil, newDetailedError(ctx, servererror.PermissionDenied,
+			"Only administrators can restore users.", servererror.ErrorDetailCodeAdminRequired)
+	}
+
+	user, err := controller.userService.RestoreUser(ctx, request.Name)
+	if err != nil {
+		return nil, servererror.GetGrpcStatus(ctx, err).Err()
+	}
+
+	controller.auditLogger.Record(ctx, &audit.Event{
+		Type:     audit.EventRestoration,
+		UserID:   user.ID,
+		UserName: user.Name,
+		Success:  true,
+		Detail:   "restored by " + adminID,
+	})
+
+	return userModelToDetails(user), nil
+}
+
+// GetRegistrationChallenge returns a proof of work challenge to be solved in
+// order to register a user.
+func (controller *Controller) GetRegistrationChallenge(
+	ctx context.Context,
+	request *RegistrationChallengeRequest) (*RegistrationChallenge, error) {
+	if !controller.challenger.IsEnabled() {
+		return nil, newDetailedError(ctx, servererror.Unimplemented,
+			"Registration does not require a proof of work.",
+			servererror.ErrorDetailCodeProofOfWorkDisabled)
+	}
+	challenge, err := controller.challenger.NewChallenge(time.Now())
+	if err != nil {
+		return nil, newDetailedError(ctx, servererror.InternalError,
+			"Failed to create challenge.", servererror.ErrorDetailCodeUnknown)
+	}
+	return &RegistrationChallenge{
+		Challenge:  challenge.Value,
+		Difficulty: int32(challenge.Difficulty),
+		ExpiresAt:  challenge.ExpiresAt.Unix(),
+	}, nil
+}
+
+// RenewStreamToken extends the open ReceiveDlcMessages streams of the calling
+// user until the expiration of the access token used for the call.
+func (controller *Controller) RenewStreamToken(
+	ctx context.Context,
+	request *RenewStreamTokenRequest) (*RenewStreamTokenResponse, error) {
+	userID, err := contextUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt, ok := contexts.GetTokenExpiry(ctx)
+	if !ok {
+		return nil, newDetailedError(ctx, servererror.PreconditionError,
+			"Streams authenticated with a certificate do not expire.",
+			servererror.ErrorDetailCodeStreamRenewalUnsupported)
+	}
+	renewed := controller.renewUserStreams(userID, expiresAt)
+	return &RenewStreamTokenResponse{
+		ExpiresAt:      expiresAt.Unix(),
+		RenewedStreams: int32(renewed),
+	}, nil
+}
+
+func getMetadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	vals := md.Get(key)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
 func userModelToInfo(user *usercommon.User) *UserInfo {
 	userInfo := UserInfo{
 		Name: user.Name,
@@ -249,39 +622,241 @@ func userModelToInfo(user *usercommon.User) *UserInfo {
 	return &userInfo
 }
 
+func userModelToDetails(user *usercommon.User) *UserDetails {
+	return &UserDetails{
+		Name:        user.Name,
+		Id:          user.ID,
+		CreatedAt:   toUnix(&user.CreatedAt),
+		UpdatedAt:   toUnix(&user.UpdatedAt),
+		LastLoginAt: toUnix(user.LastLoginAt),
+		LastSeenAt:  toUnix(user.LastSeenAt),
+	}
+}
+
+// toUnix returns the Unix timestamp of the given time, 0 if unknown.
+func toUnix(t *time.Time) int64 {
+	if t == nil || t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// newDetailedError returns the status error with the given code, message and
+// detail code.
+func newDetailedError(
+	ctx context.Context,
+	code servererror.ErrorCode,
+	message string,
+	detailCode servererror.ErrorDetailCode) error {
+	return servererror.GetGrpcStatus(ctx, servererror.NewErrorWithDetail(
+		code, message, nil, detailCode, nil)).Err()
+}
+
+// contextUser returns the user calling a method, loaded by the user
+// interceptor.
+func contextUser(ctx context.Context) (*usercommon.User, error) {
+	user, ok := usercommon.GetContextUser(ctx)
+	if !ok {
+		return nil, newDetailedError(ctx, servererror.UnauthenticatedError,
+			"Unauthenticated request.", servererror.ErrorDetailCodeTokenRequired)
+	}
+	return user, nil
+}
+
+// contextUserID returns the ID of the user calling a method, also set for the
+// methods without transaction whose user is not loaded.
+func contextUserID(ctx context.Context) (string, error) {
+	userID, ok := contexts.LookupUserID(ctx)
+	if !ok {
+		return "", newDetailedError(ctx, servererror.UnauthenticatedError,
+			"Unauthenticated request.", servererror.ErrorDetailCodeTokenRequired)
+	}
+	return userID, nil
+}
+
+// updateLastSeen records that the user of a stream was seen. Failures are
+// ignored as they should not end the stream.
+func (controller *Controller) updateLastSeen(
+	ctx context.Context, user *usercommon.User) {
+	_ = interceptor.RunInTx(ctx, func(ctx context.Context) error {
+		return controller.userService.UpdateLastSeen(ctx, user.ID)
+	})
+}
+
+// awaitAck returns the acknowledgement of a message sent to the given
+// receiver, the message being considered not delivered if the receiver was
+// removed without acknowledging it.
+func awaitAck(ctx context.Context, receiver *dlcReceiver,
+	ackChannel chan int) (int, error) {
+	select {
+	case ack := <-ackChannel:
+		return ack, nil
+	case <-receiver.closed:
+		// A message received before the removal is acknowledged before it.
+		select {
+		case ack := <-ackChannel:
+			return ack, nil
+		default:
+			return notOk, nil
+		}
+	case <-ctx.Done():
+		return notOk, ctx.Err()
+	}
+}
+
+// getUserChannels returns a copy of the receivers of the user with the given
+// name.
 func (controller *Controller) getUserChannels(
-	name string) (map[chan *dlcMessageWithAck]void, error) {
+	name string) ([]*dlcReceiver, error) {
 	controller.channelLock.RLock()
 	defer controller.channelLock.RUnlock()
 	if channels, ok := controller.userChannels[name]; ok {
-		return channels, nil
+		receivers := make([]*dlcReceiver, 0, len(channels))
+		for receiver := range channels {
+			receivers = append(receivers, receiver)
+		}
+		return receivers, nil
 	}
 
-	return nil, servererror.NewNotFoundStatus("No such user").Err()
+	return nil, servererror.NewErrorWithDetail(servererror.NotFoundError,
+		"No such user", nil, servererror.ErrorDetailCodePeerNotConnected, nil)
 }
 
-func (controller *Controller) addUserChannel(
-	channel chan *dlcMessageWithAck, name string) {
+func (controller *Controller) addUserChannel(name string) *dlcReceiver {
+	receiver := &dlcReceiver{
+		messages: make(chan *dlcMessageWithAck, 10),
+		closed:   make(chan void),
+	}
 	controller.channelLock.Lock()
 	if controller.userChannels[name] == nil {
-		controller.userChannels[name] = make(map[chan *dlcMessageWithAck]void)
+		controller.userChannels[name] = make(map[*dlcReceiver]void)
 	}
-	controller.userChannels[name][channel] = member
+	controller.userChannels[name][receiver] = member
 	controller.channelLock.Unlock()
+	return receiver
 }
 
+// removeUserChannel removes the given receiver and marks it closed.
 func (controller *Controller) removeUserChannel(
-	user *usercommon.User, channel chan *dlcMessageWithAck) {
+	user *usercommon.User, receiver *dlcReceiver) {
 	controller.channelLock.Lock()
+	defer controller.channelLock.Unlock()
 	channels, ok := controller.userChannels[user.Name]
 	if !ok {
 		return
 	}
-	delete(channels, channel)
+	if _, ok := channels[receiver]; !ok {
+		return
+	}
+	close(receiver.closed)
+	delete(channels, receiver)
 	if len(channels) == 0 {
 		delete(controller.userChannels, user.Name)
 	}
-	controller.channelLock.Unlock()
+}
+
+// addUserStream returns the expiry of a new stream of the user with the given
+// ID, bound to the access token of the given stream context, and registers
+// it for renewal.
+func (controller *Controller) addUserStream(
+	ctx context.Context, userID string) *streamExpiry {
+	expiry := &streamExpiry{renewals: make(chan time.Time, 1)}
+	expiresAt, ok := contexts.GetTokenExpiry(ctx)
+	if !ok {
+		return expiry
+	}
+	expiry.reset(expiresAt, controller.config.StreamReauthNotice)
+	controller.channelLock.Lock()
+	defer controller.channelLock.Unlock()
+	if controller.userStreams[userID] == nil {
+		controller.userStreams[userID] = make(map[*streamExpiry]void)
+	}
+	controller.userStreams[userID][expiry] = member
+	return expiry
+}
+
+func (controller *Controller) removeUserStream(
+	userID string, expiry *streamExpiry) {
+	expiry.stop()
+	controller.channelLock.Lock()
+	defer controller.channelLock.Unlock()
+	streams, ok := controller.userStreams[userID]
+	if !ok {
+		return
+	}
+	delete(streams, expiry)
+	if len(streams) == 0 {
+		delete(controller.userStreams, userID)
+	}
+}
+
+// renewUserStreams sends the given expiration time to the streams of the
+// user with the given ID and returns their number.
+func (controller *Controller) renewUserStreams(
+	userID string, expiresAt time.Time) int {
+	controller.channelLock.Lock()
+	defer controller.channelLock.Unlock()
+	streams := controller.userStreams[userID]
+	for expiry := range streams {
+		// Replace a renewal not yet processed by the stream.
+		select {
+		case <-expiry.renewals:
+		default:
+		}
+		expiry.renewals <- expiresAt
+	}
+	return len(streams)
+}
+
+// reset schedules the re-authentication request and the end of the stream
+// for the given expiration time.
+func (expiry *streamExpiry) reset(expiresAt time.Time, notice time.Duration) {
+	expiry.stop()
+	expiry.expiresAt = expiresAt
+	expiry.reauthTimer = time.NewTimer(time.Until(expiresAt.Add(-notice)))
+	expiry.expiryTimer = time.NewTimer(time.Until(expiresAt))
+}
+
+// renew postpones the expiration of the stream, tokens expiring earlier than
+// the current one being ignored.
+func (expiry *streamExpiry) renew(expiresAt time.Time, notice time.Duration) {
+	if expiresAt.After(expiry.expiresAt) {
+		expiry.reset(expiresAt, notice)
+	}
+}
+
+func (expiry *streamExpiry) stop() {
+	if expiry.reauthTimer != nil {
+		expiry.reauthTimer.Stop()
+		expiry.expiryTimer.Stop()
+	}
+}
+
+func (expiry *streamExpiry) reauth() <-chan time.Time {
+	if expiry.reauthTimer == nil {
+		return nil
+	}
+	return expiry.reauthTimer.C
+}
+
+func (expiry *streamExpiry) expired() <-chan time.Time {
+	if expiry.expiryTimer == nil {
+		return nil
+	}
+	return expiry.expiryTimer.C
+}
+
+// rejectPendingMessages acknowledges the messages left in the given removed
+// receiver as not delivered so that their senders do not wait for them.
+func rejectPendingMessages(receiver *dlcReceiver) {
+	for {
+		select {
+		case messageWithAck := <-receiver.messages:
+			messageWithAck.ackChan <- notOk
+		default:
+			return
+		}
+	}
 }
 
 func (controller *Controller) getConnectedUsers() []string {
@@ -310,8 +885,8 @@ func (controller *Controller) pingDlcChannels() {
 	wg.Wait()
 }
 
-func (controller *Controller) getUserChannelsSafe() (map[string][]chan *dlcMessageWithAck, int) {
-	channelsToPing := make(map[string][]chan *dlcMessageWithAck)
+func (controller *Controller) getUserChannelsSafe() (map[string][]*dlcReceiver, int) {
+	channelsToPing := make(map[string][]*dlcReceiver)
 	count := 0
 	controller.channelLock.RLock()
 	for userName := range controller.userChannels {
@@ -324,11 +899,12 @@ func (controller *Controller) getUserChannelsSafe() (map[string][]chan *dlcMessa
 	return channelsToPing, count
 }
 
-func pingSingleChannel(wg *sync.WaitGroup, dest string, chanToPing chan *dlcMessageWithAck) {
+func pingSingleChannel(wg *sync.WaitGroup, dest string, receiver *dlcReceiver) {
 	defer wg.Done()
-	pingChannel := make(chan int)
+	// Buffered so that the receiver does not block after the ping timed out.
+	pingChannel := make(chan int, 1)
 	select {
-	case chanToPing <- &dlcMessageWithAck{
+	case receiver.messages <- &dlcMessageWithAck{
 		message: &DlcMessage{DestName: dest, OrgName: ""},
 		ackChan: pingChannel}:
 		// Ping in the channel unless it is full
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,12 +15,19 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"p2pderivatives-server/internal/common/contexts"
+	"p2pderivatives-server/internal/common/pow"
+	"p2pderivatives-server/internal/common/servererror"
+	"p2pderivatives-server/internal/common/token"
 	"p2pderivatives-server/internal/user/usercommon"
 	"p2pderivatives-server/internal/user/usercontroller"
+	"p2pderivatives-server/internal/user/userinterceptor"
+	"p2pderivatives-server/internal/user/userservice"
 	"p2pderivatives-server/test/mocks/mock_usercontroller"
 	"p2pderivatives-server/test/mocks/mock_userservice"
 
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -31,6 +39,21 @@ func createController() *usercontroller.Controller {
 	return usercontroller.NewController(service, userConfig)
 }
 
+// withUser returns the context of a request of the given user, as set by the
+// token and user interceptors.
+func withUser(ctx context.Context, id string, name string) context.Context {
+	return usercommon.SetContextUser(
+		contexts.SetUserID(ctx, id), &usercommon.User{ID: id, Name: name})
+}
+
+func assertDetailCode(t *testing.T, err error, code servererror.ErrorDetailCode) {
+	t.Helper()
+	details, ok := servererror.DecodeStatusDetails(err)
+	if assert.True(t, ok) && assert.Len(t, details.Details, 1) {
+		assert.Equal(t, code, details.Details[0].Code)
+	}
+}
+
 func createUserRegisterRequest(model *usercommon.User) *usercontroller.UserRegisterRequest {
 	return &usercontroller.UserRegisterRequest{
 		Name:     model.Name,
@@ -99,6 +122,7 @@ func TestRegisterUser_WithExistingUser_ReturnsAlreadyExistsError(t *testing.T) {
 	assert.Error(err)
 	assert.True(ok)
 	assert.Equal(codes.AlreadyExists, st.Code())
+	assertDetailCode(t, err, servererror.ErrorDetailCodeNameTaken)
 }
 
 func TestUnregisterUser_WithRegisteredUser_RemovedFromList(t *testing.T) {
@@ -109,7 +133,7 @@ func TestUnregisterUser_WithRegisteredUser_RemovedFromList(t *testing.T) {
 	request := createUserRegisterRequest(createUser())
 	ctx := context.Background()
 	response, _ := controller.RegisterUser(ctx, request)
-	ctx = contexts.SetUserID(ctx, response.Id)
+	ctx = withUser(ctx, response.Id, response.Name)
 	mockCtrl := gomock.NewController(t)
 	stream := mock_usercontroller.NewMockUser_GetUserListServer(mockCtrl)
 	stream.EXPECT().Send(nil).Times(0)
@@ -126,7 +150,7 @@ func TestUnregisterUser_WithRegisteredUser_RemovedFromList(t *testing.T) {
 	mockCtrl.Finish()
 }
 
-func TestUnregisterUser_WithNonRegisteredUser_ReturnsNotFoundError(t *testing.T) {
+func TestUnregisterUser_WithoutLoadedUser_ReturnsUnauthenticatedError(t *testing.T) {
 	// Arrange
 	assert := assert.New(t)
 	controller := createController()
@@ -142,7 +166,67 @@ func TestUnregisterUser_WithNonRegisteredUser_ReturnsNotFoundError(t *testing.T)
 	// Assert
 	assert.Error(err)
 	assert.True(ok)
-	assert.Equal(codes.NotFound, st.Code())
+	assert.Equal(codes.Unauthenticated, st.Code())
+	assertDetailCode(t, err, servererror.ErrorDetailCodeTokenRequired)
+}
+
+func TestCreateInvite_WithoutUser_ReturnsUnauthenticated(t *testing.T) {
+	// Arrange
+	controller := createController()
+	defer controller.Close()
+
+	// Act
+	_, err := controller.CreateInvite(
+		context.Background(), &usercontroller.CreateInviteRequest{MaxUses: 1})
+
+	// Assert
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeTokenRequired)
+}
+
+func TestRestoreUser_WithoutUser_ReturnsUnauthenticated(t *testing.T) {
+	// Arrange
+	controller := createAdminController("admin1")
+	defer controller.Close()
+
+	// Act
+	_, err := controller.RestoreUser(
+		context.Background(), &usercontroller.RestoreUserRequest{Name: "user1"})
+
+	// Assert
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeTokenRequired)
+}
+
+func TestListUsers_WithoutUser_ReturnsUnauthenticated(t *testing.T) {
+	// Arrange
+	controller := createAdminController("admin1")
+	defer controller.Close()
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockStream := mock_usercontroller.NewMockUserAdmin_ListUsersServer(mockCtrl)
+	mockStream.EXPECT().Context().Return(context.Background()).AnyTimes()
+
+	// Act
+	err := controller.ListUsers(&usercontroller.ListUsersRequest{}, mockStream)
+
+	// Assert
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeTokenRequired)
+}
+
+func TestRenewStreamToken_WithoutUser_ReturnsUnauthenticated(t *testing.T) {
+	// Arrange
+	controller := createController()
+	defer controller.Close()
+	ctx := contexts.SetTokenExpiry(context.Background(), time.Now().Add(time.Hour))
+
+	// Act
+	_, err := controller.RenewStreamToken(ctx, &usercontroller.RenewStreamTokenRequest{})
+
+	// Assert
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeTokenRequired)
 }
 
 func TestSendReceive_MessageIsReceived(t *testing.T) {
@@ -167,12 +251,12 @@ func TestSendReceive_MessageIsReceived(t *testing.T) {
 	response, _ := controller.RegisterUser(
 		ctx, createUserRegisterRequest(modelUser1))
 	id1 := response.Id
-	ctx1 := contexts.SetUserID(ctx, id1)
+	ctx1 := withUser(ctx, id1, modelUser1.Name)
 	mockStream.EXPECT().Context().Return(ctx1).AnyTimes()
 	response, _ = controller.RegisterUser(
 		ctx, createUserRegisterRequest(modelUser2))
 	id2 := response.Id
-	ctx2 := contexts.SetUserID(ctx, id2)
+	ctx2 := withUser(ctx, id2, modelUser2.Name)
 
 	// Act
 	go controller.ReceiveDlcMessages(&usercontroller.Empty{}, mockStream)
@@ -202,13 +286,14 @@ func TestSendMessage_NoReceiver_Error(t *testing.T) {
 	response, _ = controller.RegisterUser(
 		ctx, createUserRegisterRequest(modelUser2))
 	id2 := response.Id
-	ctx2 := contexts.SetUserID(ctx, id2)
+	ctx2 := withUser(ctx, id2, modelUser2.Name)
 
 	// Act
 	_, err := controller.SendDlcMessage(ctx2, message)
 
 	// Assert
-	assert.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodePeerNotConnected)
 }
 
 func TestSendReceive_MultipleReceiver_MessageIsReceived(t *testing.T) {
@@ -238,13 +323,13 @@ func TestSendReceive_MultipleReceiver_MessageIsReceived(t *testing.T) {
 	response, _ := controller.RegisterUser(
 		ctx, createUserRegisterRequest(modelUser1))
 	id1 := response.Id
-	ctx1 := contexts.SetUserID(ctx, id1)
+	ctx1 := withUser(ctx, id1, modelUser1.Name)
 	mockStream1.EXPECT().Context().Return(ctx1).AnyTimes()
 	mockStream2.EXPECT().Context().Return(ctx1).AnyTimes()
 	response, _ = controller.RegisterUser(
 		ctx, createUserRegisterRequest(modelUser2))
 	id2 := response.Id
-	ctx2 := contexts.SetUserID(ctx, id2)
+	ctx2 := withUser(ctx, id2, modelUser2.Name)
 
 	// Act
 	go controller.ReceiveDlcMessages(&usercontroller.Empty{}, mockStream1)
@@ -282,12 +367,12 @@ func TestSendMessage_ToClosedStream_Error(t *testing.T) {
 	response, _ := controller.RegisterUser(
 		ctx, createUserRegisterRequest(modelUser1))
 	id1 := response.Id
-	ctx1 := contexts.SetUserID(ctx, id1)
+	ctx1 := withUser(ctx, id1, modelUser1.Name)
 	mockStream.EXPECT().Context().Return(ctx1).AnyTimes()
 	response, _ = controller.RegisterUser(
 		ctx, createUserRegisterRequest(modelUser2))
 	id2 := response.Id
-	ctx2 := contexts.SetUserID(ctx, id2)
+	ctx2 := withUser(ctx, id2, modelUser2.Name)
 
 	// Act
 	go controller.ReceiveDlcMessages(&usercontroller.Empty{}, mockStream)
@@ -297,10 +382,50 @@ func TestSendMessage_ToClosedStream_Error(t *testing.T) {
 	wg.Wait()
 
 	// Assert
-	assert.Error(t, err)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodePeerUnavailable)
 	mockCtrl.Finish()
 }
 
+func TestSendMessage_WithDeadlineToBlockedStream_ReturnsDeadlineExceeded(t *testing.T) {
+	controller := createController()
+	defer controller.Close()
+	modelUser1 := createUser()
+	modelUser2 := createUser()
+	ctx := context.Background()
+	message := &usercontroller.DlcMessage{
+		DestName: modelUser1.Name,
+		Payload:  []byte("Hello"),
+		OrgName:  modelUser2.Name,
+	}
+	release := make(chan struct{})
+	defer close(release)
+	mockCtrl := gomock.NewController(t)
+	mockStream := mock_usercontroller.NewMockUser_ReceiveDlcMessagesServer(mockCtrl)
+	mockStream.EXPECT().Send(message).Times(1).DoAndReturn(
+		func(message *usercontroller.DlcMessage) error {
+			<-release
+			return nil
+		})
+	response, _ := controller.RegisterUser(
+		ctx, createUserRegisterRequest(modelUser1))
+	ctx1 := withUser(ctx, response.Id, modelUser1.Name)
+	mockStream.EXPECT().Context().Return(ctx1).AnyTimes()
+	response, _ = controller.RegisterUser(
+		ctx, createUserRegisterRequest(modelUser2))
+	ctx2, cancel := context.WithTimeout(
+		withUser(ctx, response.Id, modelUser2.Name), 50*time.Millisecond)
+	defer cancel()
+
+	// Act
+	go controller.ReceiveDlcMessages(&usercontroller.Empty{}, mockStream)
+	time.Sleep(time.Millisecond * 5)
+	_, err := controller.SendDlcMessage(ctx2, message)
+
+	// Assert
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
 func TestGetConnectedUsers_ReturnsConnectedUsers(t *testing.T) {
 	controller := createController()
 	var wg sync.WaitGroup
@@ -317,17 +442,17 @@ func TestGetConnectedUsers_ReturnsConnectedUsers(t *testing.T) {
 	response, _ := controller.RegisterUser(
 		ctx, createUserRegisterRequest(modelUser1))
 	id1 := response.Id
-	ctx1 := contexts.SetUserID(ctx, id1)
+	ctx1 := withUser(ctx, id1, modelUser1.Name)
 	mockStream1.EXPECT().Context().Return(ctx1).AnyTimes()
 	response, _ = controller.RegisterUser(
 		ctx, createUserRegisterRequest(modelUser2))
 	id2 := response.Id
-	ctx2 := contexts.SetUserID(ctx, id2)
+	ctx2 := withUser(ctx, id2, modelUser2.Name)
 	mockStream2.EXPECT().Context().Return(ctx2).AnyTimes()
 	response, _ = controller.RegisterUser(
 		ctx, createUserRegisterRequest(modelUser3))
 	id3 := response.Id
-	ctx3 := contexts.SetUserID(ctx, id3)
+	ctx3 := withUser(ctx, id3, modelUser3.Name)
 	mockStream3.EXPECT().Context().Return(ctx3).AnyTimes()
 
 	// mock pings
@@ -358,3 +483,609 @@ func TestGetConnectedUsers_ReturnsConnectedUsers(t *testing.T) {
 	assert.NoError(t, err)
 	mockCtrl.Finish()
 }
+
+func TestRegisterUser_WithClosedRegistration_ReturnsPermissionDenied(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	userConfig := usercommon.DefaultUserConfiguration()
+	userConfig.RegistrationMode = usercommon.RegistrationModeClosed
+	controller := usercontroller.NewController(
+		mock_userservice.NewServiceMock(), userConfig)
+	defer controller.Close()
+	request := createUserRegisterRequest(createUser())
+
+	// Act
+	_, err := controller.RegisterUser(context.Background(), request)
+	st, ok := status.FromError(err)
+
+	// Assert
+	assert.True(ok)
+	assert.Equal(codes.PermissionDenied, st.Code())
+	assertDetailCode(t, err, servererror.ErrorDetailCodeRegistrationClosed)
+}
+
+func TestRegisterUser_InviteModeWithoutCode_ReturnsPermissionDenied(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	userConfig := usercommon.DefaultUserConfiguration()
+	userConfig.RegistrationMode = usercommon.RegistrationModeInvite
+	controller := usercontroller.NewController(
+		mock_userservice.NewServiceMock(), userConfig)
+	defer controller.Close()
+	request := createUserRegisterRequest(createUser())
+
+	// Act
+	_, err := controller.RegisterUser(context.Background(), request)
+	st, ok := status.FromError(err)
+
+	// Assert
+	assert.True(ok)
+	assert.Equal(codes.PermissionDenied, st.Code())
+	assertDetailCode(t, err, servererror.ErrorDetailCodeInviteRequired)
+}
+
+func TestRegisterUser_InviteModeWithCode_IsRegisteredOnce(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	userConfig := usercommon.DefaultUserConfiguration()
+	userConfig.RegistrationMode = usercommon.RegistrationModeInvite
+	controller := usercontroller.NewController(
+		mock_userservice.NewServiceMock(), userConfig)
+	defer controller.Close()
+	creator := createUser()
+	ctx := contexts.SetUserID(context.Background(), creator.ID)
+	invite, err := controller.CreateInvite(
+		ctx, &usercontroller.CreateInviteRequest{MaxUses: 1, Validity: 3600})
+	assert.NoError(err)
+	inviteCtx := metadata.NewIncomingContext(
+		context.Background(),
+		metadata.Pairs(usercontroller.MetaKeyInviteCode, invite.Code))
+
+	// Act
+	_, err1 := controller.RegisterUser(
+		inviteCtx, createUserRegisterRequest(createUser()))
+	_, err2 := controller.RegisterUser(
+		inviteCtx, createUserRegisterRequest(createUser()))
+	st, _ := status.FromError(err2)
+
+	// Assert
+	assert.NoError(err1)
+	assert.Equal(codes.PermissionDenied, st.Code())
+	assertDetailCode(t, err2, servererror.ErrorDetailCodeInviteInvalid)
+}
+
+func createPowController() *usercontroller.Controller {
+	controller := createController()
+	challenger, _ := pow.NewChallenger(&pow.Config{
+		Enabled:      true,
+		Difficulty:   4,
+		ChallengeTTL: time.Minute,
+	})
+	controller.SetChallenger(challenger)
+	return controller
+}
+
+func TestRegisterUser_WithoutProofOfWork_ReturnsInvalidArgument(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createPowController()
+	defer controller.Close()
+	request := createUserRegisterRequest(createUser())
+
+	// Act
+	_, err := controller.RegisterUser(context.Background(), request)
+	st, _ := status.FromError(err)
+
+	// Assert
+	assert.Equal(codes.InvalidArgument, st.Code())
+	assertDetailCode(t, err, servererror.ErrorDetailCodeProofOfWorkRequired)
+}
+
+func TestRegisterUser_WithInvalidProofOfWork_ReturnsPermissionDenied(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createPowController()
+	defer controller.Close()
+	request := createUserRegisterRequest(createUser())
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		usercontroller.MetaKeyPowChallenge, "challenge",
+		usercontroller.MetaKeyPowSolution, "solution"))
+
+	// Act
+	_, err := controller.RegisterUser(ctx, request)
+
+	// Assert
+	assert.Equal(codes.PermissionDenied, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeProofOfWorkInvalid)
+}
+
+func TestRegisterUser_WithProofOfWork_IsRegistered(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createPowController()
+	defer controller.Close()
+	request := createUserRegisterRequest(createUser())
+	challenge, err := controller.GetRegistrationChallenge(
+		context.Background(), &usercontroller.RegistrationChallengeRequest{})
+	assert.NoError(err)
+	solution := pow.Solve(
+		challenge.Challenge, request.Name, int(challenge.Difficulty))
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		usercontroller.MetaKeyPowChallenge, challenge.Challenge,
+		usercontroller.MetaKeyPowSolution, solution))
+
+	// Act
+	response, err := controller.RegisterUser(ctx, request)
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(request.Name, response.Name)
+}
+
+func TestGetRegistrationChallenge_WhenDisabled_ReturnsUnimplemented(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createController()
+	defer controller.Close()
+
+	// Act
+	_, err := controller.GetRegistrationChallenge(
+		context.Background(), &usercontroller.RegistrationChallengeRequest{})
+	st, _ := status.FromError(err)
+
+	// Assert
+	assert.Equal(codes.Unimplemented, st.Code())
+	assertDetailCode(t, err, servererror.ErrorDetailCodeProofOfWorkDisabled)
+}
+
+func createAdminController(adminID string) *usercontroller.Controller {
+	userConfig := usercommon.DefaultUserConfiguration()
+	userConfig.AdminIDs = []string{adminID}
+	service := mock_userservice.NewServiceMock()
+	return usercontroller.NewController(service, userConfig)
+}
+
+func TestListUsers_AsAdmin_ReturnsUserDetails(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createAdminController("admin1")
+	defer controller.Close()
+	modelUser := createUser()
+	response, _ := controller.RegisterUser(
+		context.Background(), createUserRegisterRequest(modelUser))
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockStream := mock_usercontroller.NewMockUserAdmin_ListUsersServer(mockCtrl)
+	mockStream.EXPECT().Context().Return(
+		contexts.SetUserID(context.Background(), "admin1")).AnyTimes()
+	var details []*usercontroller.UserDetails
+	mockStream.EXPECT().Send(gomock.Any()).DoAndReturn(
+		func(user *usercontroller.UserDetails) error {
+			details = append(details, user)
+			return nil
+		}).AnyTimes()
+
+	// Act
+	err := controller.ListUsers(
+		&usercontroller.ListUsersRequest{Name: modelUser.Name}, mockStream)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(details, 1)
+	assert.Equal(response.Id, details[0].Id)
+	assert.NotZero(details[0].CreatedAt)
+	assert.Zero(details[0].LastLoginAt)
+}
+
+func TestListUsers_AsUser_ReturnsPermissionDenied(t *testing.T) {
+	// Arrange
+	controller := createAdminController("admin1")
+	defer controller.Close()
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	mockStream := mock_usercontroller.NewMockUserAdmin_ListUsersServer(mockCtrl)
+	mockStream.EXPECT().Context().Return(
+		contexts.SetUserID(context.Background(), "user1")).AnyTimes()
+
+	// Act
+	err := controller.ListUsers(&usercontroller.ListUsersRequest{}, mockStream)
+
+	// Assert
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeAdminRequired)
+}
+
+func TestRestoreUser_AsAdmin_RestoresDeletedUser(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createAdminController("admin1")
+	defer controller.Close()
+	modelUser := createUser()
+	ctx := context.Background()
+	response, _ := controller.RegisterUser(ctx, createUserRegisterRequest(modelUser))
+	controller.UnregisterUser(
+		withUser(ctx, response.Id, response.Name), &usercontroller.UnregisterUserRequest{})
+	request := &usercontroller.RestoreUserRequest{Name: modelUser.Name}
+
+	// Act
+	details, err := controller.RestoreUser(contexts.SetUserID(ctx, "admin1"), request)
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(response.Id, details.Id)
+	assert.Equal(modelUser.Name, details.Name)
+}
+
+func TestRestoreUser_AsUser_ReturnsPermissionDenied(t *testing.T) {
+	// Arrange
+	controller := createAdminController("admin1")
+	defer controller.Close()
+	ctx := contexts.SetUserID(context.Background(), "user1")
+
+	// Act
+	_, err := controller.RestoreUser(ctx, &usercontroller.RestoreUserRequest{Name: "name"})
+
+	// Assert
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeAdminRequired)
+}
+
+func TestRestoreUser_WithUnknownUser_ReturnsNotFound(t *testing.T) {
+	// Arrange
+	controller := createAdminController("admin1")
+	defer controller.Close()
+	ctx := contexts.SetUserID(context.Background(), "admin1")
+
+	// Act
+	_, err := controller.RestoreUser(ctx, &usercontroller.RestoreUserRequest{Name: "name"})
+
+	// Assert
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeUserNotFound)
+}
+
+func TestReceiveDlcMessages_OnConnect_UpdatesLastSeen(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createAdminController("admin1")
+	modelUser := createUser()
+	response, _ := controller.RegisterUser(
+		context.Background(), createUserRegisterRequest(modelUser))
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	receiveStream := mock_usercontroller.NewMockUser_ReceiveDlcMessagesServer(mockCtrl)
+	receiveStream.EXPECT().Context().Return(
+		withUser(context.Background(), response.Id, response.Name)).AnyTimes()
+	listStream := mock_usercontroller.NewMockUserAdmin_ListUsersServer(mockCtrl)
+	listStream.EXPECT().Context().Return(
+		contexts.SetUserID(context.Background(), "admin1")).AnyTimes()
+	var details []*usercontroller.UserDetails
+	listStream.EXPECT().Send(gomock.Any()).DoAndReturn(
+		func(user *usercontroller.UserDetails) error {
+			details = append(details, user)
+			return nil
+		}).AnyTimes()
+
+	done := make(chan struct{})
+
+	// Act
+	go func() {
+		controller.ReceiveDlcMessages(&usercontroller.Empty{}, receiveStream)
+		close(done)
+	}()
+	time.Sleep(time.Millisecond * 5)
+	controller.Close()
+	<-done
+	err := controller.ListUsers(
+		&usercontroller.ListUsersRequest{Name: modelUser.Name}, listStream)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(details, 1)
+	assert.NotZero(details[0].LastSeenAt)
+}
+
+func TestReceiveDlcMessages_OnUnregister_EndsWithRevokedStatus(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createController()
+	defer controller.Close()
+	ctx := context.Background()
+	response, _ := controller.RegisterUser(ctx, createUserRegisterRequest(createUser()))
+	userCtx := withUser(ctx, response.Id, response.Name)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	receiveStream := mock_usercontroller.NewMockUser_ReceiveDlcMessagesServer(mockCtrl)
+	receiveStream.EXPECT().Context().Return(userCtx).AnyTimes()
+	errChannel := make(chan error)
+	go func() {
+		errChannel <- controller.ReceiveDlcMessages(&usercontroller.Empty{}, receiveStream)
+	}()
+	time.Sleep(time.Millisecond * 5)
+
+	// Act
+	_, err := controller.UnregisterUser(userCtx, &usercontroller.UnregisterUserRequest{})
+
+	// Assert
+	assert.NoError(err)
+	select {
+	case err = <-errChannel:
+		assert.Equal(codes.Unauthenticated, status.Code(err))
+	case <-time.After(time.Second):
+		assert.Fail("stream did not end")
+	}
+}
+
+func TestReceiveDlcMessages_OnDisconnect_Ends(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createController()
+	defer controller.Close()
+	response, _ := controller.RegisterUser(
+		context.Background(), createUserRegisterRequest(createUser()))
+	streamCtx, disconnect := context.WithCancel(
+		withUser(context.Background(), response.Id, response.Name))
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	receiveStream := mock_usercontroller.NewMockUser_ReceiveDlcMessagesServer(mockCtrl)
+	receiveStream.EXPECT().Context().Return(streamCtx).AnyTimes()
+	errChannel := make(chan error)
+	go func() {
+		errChannel <- controller.ReceiveDlcMessages(&usercontroller.Empty{}, receiveStream)
+	}()
+	time.Sleep(time.Millisecond * 5)
+
+	// Act
+	disconnect()
+
+	// Assert
+	select {
+	case err := <-errChannel:
+		assert.Equal(context.Canceled, err)
+	case <-time.After(time.Second):
+		assert.Fail("stream did not end")
+	}
+}
+
+func TestReceiveDlcMessages_AfterStreamEnds_StopsWatchingRevocations(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createController()
+	defer controller.Close()
+	ctx := context.Background()
+	response, _ := controller.RegisterUser(ctx, createUserRegisterRequest(createUser()))
+	streamCtx, disconnect := context.WithCancel(withUser(ctx, response.Id, response.Name))
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	receiveStream := mock_usercontroller.NewMockUser_ReceiveDlcMessagesServer(mockCtrl)
+	receiveStream.EXPECT().Context().Return(streamCtx).AnyTimes()
+	errChannel := make(chan error)
+	go func() {
+		errChannel <- controller.ReceiveDlcMessages(&usercontroller.Empty{}, receiveStream)
+	}()
+	time.Sleep(time.Millisecond * 5)
+	watchersDuringStream := token.RevocationWatchers(response.Id)
+
+	// Act
+	disconnect()
+	<-errChannel
+
+	// Assert
+	assert.Equal(1, watchersDuringStream)
+	assert.Equal(0, token.RevocationWatchers(response.Id))
+}
+
+func TestReceiveDlcEvents_OnTokenExpiry_RequestsReauthAndEnds(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createController()
+	defer controller.Close()
+	ctx := context.Background()
+	response, _ := controller.RegisterUser(ctx, createUserRegisterRequest(createUser()))
+	expiresAt := time.Now().Add(50 * time.Millisecond)
+	streamCtx := contexts.SetTokenExpiry(withUser(ctx, response.Id, response.Name), expiresAt)
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	receiveStream := mock_usercontroller.NewMockStreamAuth_ReceiveDlcEventsServer(mockCtrl)
+	receiveStream.EXPECT().Context().Return(streamCtx).AnyTimes()
+	var sent *usercontroller.DlcEvent
+	receiveStream.EXPECT().Send(gomock.Any()).DoAndReturn(
+		func(event *usercontroller.DlcEvent) error {
+			sent = event
+			return nil
+		})
+
+	// Act
+	err := controller.ReceiveDlcEvents(&usercontroller.Empty{}, receiveStream)
+
+	// Assert
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	if assert.NotNil(sent) && assert.NotNil(sent.GetReauthRequired()) {
+		assert.Equal(expiresAt.Unix(), sent.GetReauthRequired().ExpiresAt)
+	}
+}
+
+func TestReceiveDlcEvents_WithMessage_SendsMessageEvent(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createController()
+	defer controller.Close()
+	ctx := context.Background()
+	sender, _ := controller.RegisterUser(ctx, createUserRegisterRequest(createUser()))
+	receiver, _ := controller.RegisterUser(ctx, createUserRegisterRequest(createUser()))
+	streamCtx, disconnect := context.WithCancel(withUser(ctx, receiver.Id, receiver.Name))
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	receiveStream := mock_usercontroller.NewMockStreamAuth_ReceiveDlcEventsServer(mockCtrl)
+	receiveStream.EXPECT().Context().Return(streamCtx).AnyTimes()
+	received := make(chan *usercontroller.DlcEvent, 1)
+	receiveStream.EXPECT().Send(gomock.Any()).DoAndReturn(
+		func(event *usercontroller.DlcEvent) error {
+			received <- event
+			return nil
+		})
+	errChannel := make(chan error)
+	go func() {
+		errChannel <- controller.ReceiveDlcEvents(&usercontroller.Empty{}, receiveStream)
+	}()
+	time.Sleep(time.Millisecond * 5)
+
+	// Act
+	_, err := controller.SendDlcMessage(
+		withUser(ctx, sender.Id, sender.Name),
+		&usercontroller.DlcMessage{DestName: receiver.Name, Payload: []byte("payload")})
+
+	// Assert
+	assert.NoError(err)
+	event := <-received
+	if assert.NotNil(event.GetMessage()) {
+		assert.Equal(sender.Name, event.GetMessage().OrgName)
+		assert.Equal("payload", string(event.GetMessage().Payload))
+	}
+	disconnect()
+	assert.Equal(context.Canceled, <-errChannel)
+}
+
+func TestReceiveDlcMessages_OnTokenExpiry_EndsWithoutReauthMessage(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createController()
+	defer controller.Close()
+	ctx := context.Background()
+	response, _ := controller.RegisterUser(ctx, createUserRegisterRequest(createUser()))
+	streamCtx := contexts.SetTokenExpiry(
+		withUser(ctx, response.Id, response.Name), time.Now().Add(50*time.Millisecond))
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	receiveStream := mock_usercontroller.NewMockUser_ReceiveDlcMessagesServer(mockCtrl)
+	receiveStream.EXPECT().Context().Return(streamCtx).AnyTimes()
+	receiveStream.EXPECT().Send(gomock.Any()).Times(0)
+
+	// Act
+	err := controller.ReceiveDlcMessages(&usercontroller.Empty{}, receiveStream)
+
+	// Assert
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+}
+
+func TestRenewStreamToken_WithFreshToken_ExtendsOpenStreams(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createController()
+	defer controller.Close()
+	response, _ := controller.RegisterUser(
+		context.Background(), createUserRegisterRequest(createUser()))
+	userCtx := withUser(context.Background(), response.Id, response.Name)
+	streamCtx, disconnect := context.WithCancel(
+		contexts.SetTokenExpiry(userCtx, time.Now().Add(100*time.Millisecond)))
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	receiveStream := mock_usercontroller.NewMockUser_ReceiveDlcMessagesServer(mockCtrl)
+	receiveStream.EXPECT().Context().Return(streamCtx).AnyTimes()
+	receiveStream.EXPECT().Send(gomock.Any()).Return(nil).AnyTimes()
+	errChannel := make(chan error)
+	go func() {
+		errChannel <- controller.ReceiveDlcMessages(&usercontroller.Empty{}, receiveStream)
+	}()
+	time.Sleep(time.Millisecond * 5)
+	expiresAt := time.Now().Add(time.Hour)
+
+	// Act
+	renewal, err := controller.RenewStreamToken(
+		contexts.SetTokenExpiry(userCtx, expiresAt),
+		&usercontroller.RenewStreamTokenRequest{})
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(expiresAt.Unix(), renewal.ExpiresAt)
+	assert.Equal(int32(1), renewal.RenewedStreams)
+	select {
+	case err = <-errChannel:
+		assert.Fail("stream ended", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	disconnect()
+	assert.Equal(context.Canceled, <-errChannel)
+}
+
+func TestRenewStreamToken_WithoutToken_ReturnsFailedPrecondition(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	controller := createController()
+	ctx := contexts.SetUserID(context.Background(), "user1")
+
+	// Act
+	_, err := controller.RenewStreamToken(ctx, &usercontroller.RenewStreamTokenRequest{})
+
+	// Assert
+	assert.Equal(codes.FailedPrecondition, status.Code(err))
+	assertDetailCode(t, err, servererror.ErrorDetailCodeStreamRenewalUnsupported)
+}
+
+// countingService counts the users looked up by the controller.
+type countingService struct {
+	*mock_userservice.ServiceMock
+	finds int64
+}
+
+func (service *countingService) FindFirstUser(
+	ctx context.Context,
+	condition *usercommon.User,
+	orders []string) (*usercommon.User, error) {
+	atomic.AddInt64(&service.finds, 1)
+	return service.ServiceMock.FindFirstUser(ctx, condition, orders)
+}
+
+func BenchmarkSendDlcMessage(b *testing.B) {
+	for _, bench := range []struct {
+		name   string
+		cached bool
+	}{
+		{name: "uncached", cached: false},
+		{name: "cached", cached: true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			userConfig := usercommon.DefaultUserConfiguration()
+			counter := &countingService{ServiceMock: mock_userservice.NewServiceMock()}
+			var service usercommon.ServiceIf = counter
+			if bench.cached {
+				service = userservice.NewCachedService(counter, userConfig)
+			}
+			controller := usercontroller.NewController(service, userConfig)
+			defer controller.Close()
+			ctx := context.Background()
+			receiver := createUser()
+			response, _ := controller.RegisterUser(ctx, createUserRegisterRequest(receiver))
+			receiverCtx, disconnect := context.WithCancel(withUser(ctx, response.Id, response.Name))
+			defer disconnect()
+			response, _ = controller.RegisterUser(ctx, createUserRegisterRequest(createUser()))
+			// The sender is loaded by the user interceptor as in the server.
+			senderCtx := contexts.SetUserID(ctx, response.Id)
+			sendDlcMessage := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return controller.SendDlcMessage(ctx, req.(*usercontroller.DlcMessage))
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: "/usercontroller.User/SendDlcMessage"}
+			userInterceptor := userinterceptor.UnaryInterceptor(service)
+			mockCtrl := gomock.NewController(b)
+			defer mockCtrl.Finish()
+			receiveStream := mock_usercontroller.NewMockUser_ReceiveDlcMessagesServer(mockCtrl)
+			receiveStream.EXPECT().Context().Return(receiverCtx).AnyTimes()
+			receiveStream.EXPECT().Send(gomock.Any()).Return(nil).AnyTimes()
+			go controller.ReceiveDlcMessages(&usercontroller.Empty{}, receiveStream)
+			time.Sleep(time.Millisecond * 5)
+			atomic.StoreInt64(&counter.finds, 0)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := userInterceptor(senderCtx, &usercontroller.DlcMessage{
+					DestName: receiver.Name,
+					Payload:  []byte("Hello"),
+				}, info, sendDlcMessage)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&counter.finds))/float64(b.N), "lookups/op")
+		})
+	}
+}
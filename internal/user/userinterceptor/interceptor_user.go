@@ -0,0 +1,99 @@
+package userinterceptor
+
+import (
+	"context"
+	"errors"
+	"p2pderivatives-server/internal/common/contexts"
+	"p2pderivatives-server/internal/common/grpc/methods"
+	"p2pderivatives-server/internal/common/grpc/pbbase"
+	"p2pderivatives-server/internal/common/servererror"
+	"p2pderivatives-server/internal/common/token"
+	"p2pderivatives-server/internal/database/interceptor"
+	"p2pderivatives-server/internal/user/usercommon"
+
+	"google.golang.org/grpc"
+)
+
+// ErrUserNotFound is an error returned when the user of the provided token or
+// certificate no longer exists.
+var ErrUserNotFound = servererror.NewErrorWithDetail(servererror.UnauthenticatedError, "user not found", nil, servererror.ErrorDetailCodeUserNotFound, nil)
+
+// UnaryInterceptor loads the user of authenticated requests into their
+// context, where handlers retrieve it with usercommon.GetContextUser. Must
+// come after the token and transaction interceptors. Methods without
+// transaction are not given the user.
+func UnaryInterceptor(service usercommon.ServiceIf) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !needsUser(ctx, info.FullMethod) {
+			return handler(ctx, req)
+		}
+		newCtx, err := loadUser(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+		return handler(newCtx, req)
+	}
+}
+
+// StreamInterceptor loads the user of authenticated streams into their
+// context, in a transaction of its own. Must come after the token and
+// transaction interceptors.
+func StreamInterceptor(service usercommon.ServiceIf) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !needsUser(stream.Context(), info.FullMethod) {
+			return handler(srv, stream)
+		}
+		newCtx, err := loadUser(stream.Context(), service)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &wrappedStream{stream, newCtx})
+	}
+}
+
+type wrappedStream struct {
+	grpc.ServerStream
+	WrappedContext context.Context
+}
+
+// Context returns the wrapper's WrappedContext, overwriting the nested
+// grpc.ServerStream.Context()
+func (w *wrappedStream) Context() context.Context {
+	return w.WrappedContext
+}
+
+// needsUser returns whether the request is authenticated and can access the
+// DB.
+func needsUser(ctx context.Context, methodName string) bool {
+	_, ok := contexts.LookupUserID(ctx)
+	return ok && methods.TxOption(methodName) != pbbase.TxOption_NoTx
+}
+
+func loadUser(ctx context.Context, service usercommon.ServiceIf) (context.Context, error) {
+	userID, _ := contexts.LookupUserID(ctx)
+	var user *usercommon.User
+	err := interceptor.RunInTx(ctx, func(ctx context.Context) (err error) {
+		user, err = service.FindFirstUser(ctx, &usercommon.User{ID: userID}, nil)
+		return err
+	})
+	if err != nil {
+		var serverErr *servererror.Error
+		if errors.As(err, &serverErr) && serverErr.Code == servererror.NotFoundError {
+			return ctx, servererror.GetGrpcStatus(ctx, ErrUserNotFound).Err()
+		}
+		return ctx, servererror.GetGrpcStatus(ctx, err).Err()
+	}
+	if isTokenRevoked(ctx, user) {
+		return ctx, servererror.GetGrpcStatus(ctx, token.ErrTokenRevoked).Err()
+	}
+	return usercommon.SetContextUser(ctx, user), nil
+}
+
+// isTokenRevoked returns whether the access token of the request was issued
+// before the last revocation of the tokens of the given user, which may have
+// been done by another server instance.
+func isTokenRevoked(ctx context.Context, user *usercommon.User) bool {
+	issuedAt, ok := contexts.GetTokenIssuedAt(ctx)
+	return ok && user.TokensValidAfter != nil &&
+		!issuedAt.After(*user.TokensValidAfter)
+}
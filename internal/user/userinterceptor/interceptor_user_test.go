@@ -0,0 +1,146 @@
+package userinterceptor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"p2pderivatives-server/internal/common/contexts"
+	"p2pderivatives-server/internal/user/usercommon"
+	"p2pderivatives-server/internal/user/userinterceptor"
+	"p2pderivatives-server/test/mocks/mock_userservice"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const fullMethod = "/usercontroller.User/SendDlcMessage"
+
+func createService() *mock_userservice.ServiceMock {
+	service := mock_userservice.NewServiceMock()
+	service.CreateUser(context.Background(), &usercommon.User{ID: "user1", Name: "user1"})
+	return service
+}
+
+func runUnary(
+	ctx context.Context,
+	service usercommon.ServiceIf) (user *usercommon.User, loaded bool, err error) {
+	_, err = userinterceptor.UnaryInterceptor(service)(
+		ctx, nil, &grpc.UnaryServerInfo{FullMethod: fullMethod},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			user, loaded = usercommon.GetContextUser(ctx)
+			return nil, nil
+		})
+	return user, loaded, err
+}
+
+func TestUnaryInterceptor_WithExistingUser_LoadsUser(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx := contexts.SetUserID(context.Background(), "user1")
+
+	// Act
+	user, loaded, err := runUnary(ctx, createService())
+
+	// Assert
+	assert.NoError(err)
+	assert.True(loaded)
+	assert.Equal("user1", user.Name)
+}
+
+func TestUnaryInterceptor_WithUnknownUser_ReturnsUnauthenticated(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx := contexts.SetUserID(context.Background(), "deleted")
+
+	// Act
+	_, _, err := runUnary(ctx, createService())
+
+	// Assert
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+	assert.EqualError(err, "rpc error: code = Unauthenticated desc = user not found")
+}
+
+func TestUnaryInterceptor_WithTokenIssuedBeforeRevocation_ReturnsUnauthenticated(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	revokedAt := time.Now()
+	service := createService()
+	service.CreateUser(context.Background(), &usercommon.User{
+		ID: "user2", Name: "user2", TokensValidAfter: &revokedAt})
+	ctx := contexts.SetTokenIssuedAt(
+		contexts.SetUserID(context.Background(), "user2"),
+		revokedAt.Add(-time.Second))
+
+	// Act
+	_, loaded, err := runUnary(ctx, service)
+
+	// Assert
+	assert.False(loaded)
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+	assert.EqualError(err, "rpc error: code = Unauthenticated desc = accessToken revoked")
+}
+
+func TestUnaryInterceptor_WithTokenIssuedAfterRevocation_LoadsUser(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	revokedAt := time.Now()
+	service := createService()
+	service.CreateUser(context.Background(), &usercommon.User{
+		ID: "user2", Name: "user2", TokensValidAfter: &revokedAt})
+	ctx := contexts.SetTokenIssuedAt(
+		contexts.SetUserID(context.Background(), "user2"),
+		revokedAt.Add(time.Second))
+
+	// Act
+	_, loaded, err := runUnary(ctx, service)
+
+	// Assert
+	assert.NoError(err)
+	assert.True(loaded)
+}
+
+func TestUnaryInterceptor_WithoutUserID_DoesNotLoadUser(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+
+	// Act
+	_, loaded, err := runUnary(context.Background(), createService())
+
+	// Assert
+	assert.NoError(err)
+	assert.False(loaded)
+}
+
+func TestStreamInterceptor_WithExistingUser_LoadsUser(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx := contexts.SetUserID(context.Background(), "user1")
+	var user *usercommon.User
+
+	// Act
+	err := userinterceptor.StreamInterceptor(createService())(
+		nil, &mockStream{MockContext: ctx},
+		&grpc.StreamServerInfo{FullMethod: "/usercontroller.User/ReceiveDlcMessages"},
+		func(srv interface{}, stream grpc.ServerStream) error {
+			user, _ = usercommon.GetContextUser(stream.Context())
+			return nil
+		})
+
+	// Assert
+	assert.NoError(err)
+	if assert.NotNil(user) {
+		assert.Equal("user1", user.ID)
+	}
+}
+
+type mockStream struct {
+	grpc.ServerStream
+	MockContext context.Context
+}
+
+func (m *mockStream) Context() context.Context {
+	return m.MockContext
+}
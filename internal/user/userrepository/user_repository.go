@@ -2,10 +2,13 @@ package userrepository
 
 import (
 	context "context"
+	"p2pderivatives-server/internal/common/tracing"
 	"p2pderivatives-server/internal/database/interceptor"
 	"p2pderivatives-server/internal/user/usercommon"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Repository represents a repository to store User related data.
@@ -23,7 +26,9 @@ func (repo *Repository) extractTx(ctx context.Context) *gorm.DB {
 		panic("Repository called without DB tx in context.")
 	}
 
-	return tx
+	// Queries are run with the context so that they are traced as part of the
+	// request.
+	return tx.WithContext(ctx)
 }
 
 // FindFirstUser returns first User matching with specified condition.
@@ -32,6 +37,8 @@ func (repo *Repository) FindFirstUser(
 	condition interface{},
 	orders []string,
 ) (*usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.FindFirstUser")
+	defer span.End()
 	tx := repo.extractTx(ctx)
 	query := tx.Where(condition)
 	if orders == nil {
@@ -54,6 +61,8 @@ func (repo *Repository) FindUsers(
 	limit int,
 	orders []string,
 ) (result []usercommon.User, err error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.FindUsers")
+	defer span.End()
 	tx := repo.extractTx(ctx)
 	query := tx.Where(condition)
 	if offset >= 0 {
@@ -79,6 +88,8 @@ func (repo *Repository) FindUserByCondition(
 	ctx context.Context,
 	condition *usercommon.Condition,
 ) (result []usercommon.User, err error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.FindUserByCondition")
+	defer span.End()
 	tx := repo.extractTx(ctx)
 	filterCondition := &usercommon.User{
 		ID:   condition.ID,
@@ -97,12 +108,18 @@ func (repo *Repository) FindUserByCondition(
 	if len(condition.IDs) > 0 {
 		query = query.Where("id in (?)", condition.IDs)
 	}
+	if !condition.InactiveSince.IsZero() {
+		query = query.Where(
+			"COALESCE(last_login_at, created_at) < ?", condition.InactiveSince)
+	}
 	err = query.Find(&result).Error
 	return
 }
 
 // GetAllUsers returns all the users registered in the system
 func (repo *Repository) GetAllUsers(ctx context.Context) (users []usercommon.User, err error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.GetAllUsers")
+	defer span.End()
 	tx := repo.extractTx(ctx)
 	err = tx.Find(&users).Error
 	return
@@ -111,6 +128,8 @@ func (repo *Repository) GetAllUsers(ctx context.Context) (users []usercommon.Use
 // CountUsers returns the number of Users matching specified condition
 func (repo *Repository) CountUsers(ctx context.Context, condition interface{}) (
 	count int64, err error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.CountUsers")
+	defer span.End()
 	tx := repo.extractTx(ctx)
 	var users []usercommon.User
 	err = tx.Where(condition).Find(&users).Count(&count).Error
@@ -119,18 +138,24 @@ func (repo *Repository) CountUsers(ctx context.Context, condition interface{}) (
 
 // CreateUser inserts new User record
 func (repo *Repository) CreateUser(ctx context.Context, user *usercommon.User) error {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.CreateUser")
+	defer span.End()
 	tx := repo.extractTx(ctx)
 	return repo.createUser(tx, user)
 }
 
 // UpdateUser updates User record
 func (repo *Repository) UpdateUser(ctx context.Context, user *usercommon.User) error {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.UpdateUser")
+	defer span.End()
 	tx := repo.extractTx(ctx)
 	return repo.updateUser(tx, user)
 }
 
 // DeleteUser deletes User record
 func (repo *Repository) DeleteUser(ctx context.Context, user *usercommon.User) error {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.DeleteUser")
+	defer span.End()
 	if user.ID == "" {
 		return nil // To avoid deleting all, return here.
 	}
@@ -141,6 +166,8 @@ func (repo *Repository) DeleteUser(ctx context.Context, user *usercommon.User) e
 // CreateUsers inserts new User records.
 func (repo *Repository) CreateUsers(
 	ctx context.Context, users []*usercommon.User) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.CreateUsers")
+	defer span.End()
 	tx := repo.extractTx(ctx)
 	for _, user := range users {
 		err = repo.createUser(tx, user)
@@ -154,6 +181,8 @@ func (repo *Repository) CreateUsers(
 // UpdateUsers updates user records
 func (repo *Repository) UpdateUsers(
 	ctx context.Context, users []*usercommon.User) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.UpdateUsers")
+	defer span.End()
 	tx := repo.extractTx(ctx)
 	for _, user := range users {
 		err = repo.updateUser(tx, user)
@@ -166,6 +195,8 @@ func (repo *Repository) UpdateUsers(
 
 // DeleteUsers deletes User records
 func (repo *Repository) DeleteUsers(ctx context.Context, users []*usercommon.User) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.DeleteUsers")
+	defer span.End()
 	tx := repo.extractTx(ctx)
 	for _, user := range users {
 		err = repo.deleteUser(tx, user)
@@ -176,17 +207,239 @@ func (repo *Repository) DeleteUsers(ctx context.Context, users []*usercommon.Use
 	return
 }
 
+// UpdateLastLogin sets the last login time of the user with the given ID.
+func (repo *Repository) UpdateLastLogin(
+	ctx context.Context, userID string, at time.Time) error {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.UpdateLastLogin")
+	defer span.End()
+	tx := repo.extractTx(ctx)
+	return repo.updateActivity(tx, userID, "last_login_at", at)
+}
+
+// UpdateLastSeen sets the last seen time of the user with the given ID.
+func (repo *Repository) UpdateLastSeen(
+	ctx context.Context, userID string, at time.Time) error {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.UpdateLastSeen")
+	defer span.End()
+	tx := repo.extractTx(ctx)
+	return repo.updateActivity(tx, userID, "last_seen_at", at)
+}
+
+// IsNameTaken returns whether the given normalized name is used by a user,
+// including the deleted users that were not purged, or reserved after the
+// purge of its user.
+func (repo *Repository) IsNameTaken(
+	ctx context.Context, normalizedName string, now time.Time) (bool, error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.IsNameTaken")
+	defer span.End()
+	tx := repo.extractTx(ctx)
+	var count int64
+	err := tx.Unscoped().Model(&usercommon.User{}).
+		Where("normalized_name = ?", normalizedName).
+		Count(&count).Error
+	if err != nil || count > 0 {
+		return count > 0, err
+	}
+	err = tx.Model(&usercommon.NameReservation{}).
+		Where("normalized_name = ? AND expires_at > ?", normalizedName, now).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// FindDeletedUser returns the deleted user with the given normalized name.
+func (repo *Repository) FindDeletedUser(
+	ctx context.Context, normalizedName string) (*usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.FindDeletedUser")
+	defer span.End()
+	tx := repo.extractTx(ctx)
+	var user usercommon.User
+	err := tx.Unscoped().
+		Where("normalized_name = ? AND deleted_at IS NOT NULL", normalizedName).
+		First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RestoreUser restores the given deleted user.
+func (repo *Repository) RestoreUser(ctx context.Context, user *usercommon.User) error {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.RestoreUser")
+	defer span.End()
+	tx := repo.extractTx(ctx)
+	err := tx.Unscoped().Model(user).Update("deleted_at", nil).Error
+	if err != nil {
+		return err
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+// PurgeUsers permanently deletes the users deleted before the given time
+// with the invites they created, reserves their names until the given time
+// and returns them.
+func (repo *Repository) PurgeUsers(
+	ctx context.Context,
+	deletedBefore time.Time,
+	reservedUntil time.Time) ([]usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.PurgeUsers")
+	defer span.End()
+	tx := repo.extractTx(ctx)
+	var users []usercommon.User
+	err := tx.Unscoped().Where("deleted_at < ?", deletedBefore).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		user := &users[i]
+		if user.NormalizedName != nil {
+			reservation := &usercommon.NameReservation{
+				NormalizedName: *user.NormalizedName,
+				ExpiresAt:      reservedUntil,
+			}
+			err = tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(reservation).Error
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = tx.Where("creator_id = ?", user.ID).Delete(&usercommon.Invite{}).Error
+		if err != nil {
+			return nil, err
+		}
+		if err = tx.Unscoped().Delete(user).Error; err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// DeleteExpiredNameReservations deletes the name reservations expired at the
+// given time.
+func (repo *Repository) DeleteExpiredNameReservations(
+	ctx context.Context, now time.Time) error {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.DeleteExpiredNameReservations")
+	defer span.End()
+	tx := repo.extractTx(ctx)
+	return tx.Where("expires_at <= ?", now).
+		Delete(&usercommon.NameReservation{}).Error
+}
+
+// FindInvite returns the invite with the given code.
+func (repo *Repository) FindInvite(
+	ctx context.Context, code string) (*usercommon.Invite, error) {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.FindInvite")
+	defer span.End()
+	if code == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	tx := repo.extractTx(ctx)
+	var result usercommon.Invite
+	err := tx.Where(&usercommon.Invite{Code: code}).First(&result).Error
+	return &result, err
+}
+
+// CreateInvite inserts new Invite record
+func (repo *Repository) CreateInvite(ctx context.Context, invite *usercommon.Invite) error {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.CreateInvite")
+	defer span.End()
+	tx := repo.extractTx(ctx)
+	return tx.Create(invite).Error
+}
+
+// UseInvite increments the number of uses of the given invite if it has uses
+// left, and returns gorm.ErrRecordNotFound otherwise.
+func (repo *Repository) UseInvite(ctx context.Context, invite *usercommon.Invite) error {
+	ctx, span := tracing.StartSpan(ctx, "userrepository.UseInvite")
+	defer span.End()
+	tx := repo.extractTx(ctx)
+	result := tx.Model(&usercommon.Invite{}).
+		Where("code = ? AND uses < max_uses", invite.Code).
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	invite.Uses++
+	return nil
+}
+
 func (repo *Repository) createUser(tx *gorm.DB, user *usercommon.User) error {
+	normalized, err := usercommon.NormalizeName(user.Name)
+	if err != nil {
+		return err
+	}
+	user.NormalizedName = &normalized
+	user.Version = 1
 	return tx.Create(user).Error
 }
 
 func (repo *Repository) updateUser(tx *gorm.DB, user *usercommon.User) error {
-	return tx.Save(user).Error
+	// The update only applies to the version of the user that was read, so
+	// that concurrent updates do not overwrite each other. The creation and
+	// activity times are set separately and never overwritten, nor is the
+	// token revocation time unless a new one is given.
+	omitted := []string{"CreatedAt", "LastLoginAt", "LastSeenAt"}
+	if user.TokensValidAfter == nil {
+		omitted = append(omitted, "TokensValidAfter")
+	}
+	// The normalized name read with the user is kept unless the name changed.
+	// Users registered before normalization was introduced may have been left
+	// without normalized name because theirs was invalid or taken, and keep
+	// none.
+	if user.NormalizedName == nil {
+		omitted = append(omitted, "NormalizedName")
+	} else if normalized, err := usercommon.NormalizeName(user.Name); err != nil {
+		user.NormalizedName = nil
+	} else if normalized != *user.NormalizedName {
+		user.NormalizedName = &normalized
+	}
+	version := user.Version
+	user.Version++
+	result := tx.Select("*").
+		Omit(omitted...).
+		Where("version = ?", version).
+		Save(user)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = usercommon.ErrOptimisticLock
+	}
+	if result.Error != nil {
+		user.Version = version
+	}
+	return result.Error
+}
+
+// updateActivity sets the given activity time column of a user, without
+// changing its version or update time as activity is not a modification of
+// the user.
+func (repo *Repository) updateActivity(
+	tx *gorm.DB, userID string, column string, at time.Time) error {
+	result := tx.Model(&usercommon.User{}).
+		Where("id = ?", userID).
+		UpdateColumn(column, at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
 }
 
 func (repo *Repository) deleteUser(tx *gorm.DB, user *usercommon.User) error {
 	if user.ID == "" {
 		return nil // To avoid deleting all, return here.
 	}
+	// The tokens are revoked so that they cannot be used if the user is
+	// restored.
+	err := tx.Model(user).UpdateColumns(map[string]interface{}{
+		"refresh_token":      "",
+		"tokens_valid_after": time.Now(),
+	}).Error
+	if err != nil {
+		return err
+	}
 	return tx.Delete(user).Error
 }
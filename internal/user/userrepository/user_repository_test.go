@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"p2pderivatives-server/internal/database/interceptor"
 	"testing"
+	"time"
 
 	"p2pderivatives-server/internal/user/usercommon"
 	"p2pderivatives-server/test"
@@ -15,7 +16,8 @@ import (
 
 // createTxAndUserRepo creates a new DB transaction and user repository.
 func createContextRepoAndTx() (ctx context.Context, repo *Repository, tx *gorm.DB) {
-	ormInstance := test.InitializeORM(&usercommon.User{})
+	ormInstance := test.InitializeORM(
+		&usercommon.User{}, &usercommon.Invite{}, &usercommon.NameReservation{})
 	tx = ormInstance.GetDB().Begin()
 	ctx = interceptor.SaveTx(context.Background(), tx)
 	repo = NewRepository()
@@ -96,6 +98,18 @@ func TestRepository_CreateUser(t *testing.T) {
 	assert.NotNil(t, foundResults[0].Password)
 }
 
+func TestRepository_CreateUser_SameNormalizedName_Fails(t *testing.T) {
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+
+	err := repo.CreateUser(ctx, usercommon.NewUser("hoge_taro", "password1"))
+	assert.NoError(t, err)
+
+	err = repo.CreateUser(ctx, usercommon.NewUser("Hoge_Taro", "password2"))
+
+	assert.Error(t, err)
+}
+
 func TestRepository_UpdateUser(t *testing.T) {
 	ctx, repo, tx := createContextRepoAndTx()
 	defer tx.Rollback()
@@ -132,6 +146,167 @@ func TestRepository_UpdateUser(t *testing.T) {
 	assert.NotNil(t, updatedResults[0].Password)
 }
 
+func TestRepository_UpdateUser_WithCollidingLegacyNames_KeepsNormalizedNames(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+	normalized := "hoge_taro"
+	// Users registered before normalization, the second one being left
+	// without normalized name by the migration as it collides.
+	first := usercommon.NewUser("hoge_taro", "password1")
+	first.NormalizedName = &normalized
+	first.Version = 1
+	second := usercommon.NewUser("Hoge_Taro", "password2")
+	second.Version = 1
+	insertTestDataToTx(tx, []*usercommon.User{first, second})
+
+	loadedFirst, _ := repo.FindFirstUser(ctx, usercommon.User{ID: first.ID}, nil)
+	loadedSecond, _ := repo.FindFirstUser(ctx, usercommon.User{ID: second.ID}, nil)
+	loadedFirst.Password = "password3"
+	loadedSecond.Password = "password4"
+
+	// Act
+	firstErr := repo.UpdateUser(ctx, loadedFirst)
+	secondErr := repo.UpdateUser(ctx, loadedSecond)
+
+	// Assert
+	assert.NoError(firstErr)
+	assert.NoError(secondErr)
+	storedFirst, _ := repo.FindFirstUser(ctx, usercommon.User{ID: first.ID}, nil)
+	storedSecond, _ := repo.FindFirstUser(ctx, usercommon.User{ID: second.ID}, nil)
+	if assert.NotNil(storedFirst.NormalizedName) {
+		assert.Equal(normalized, *storedFirst.NormalizedName)
+	}
+	assert.Nil(storedSecond.NormalizedName)
+	assert.Equal("password4", storedSecond.Password)
+}
+
+func TestRepository_UpdateUser_WithNewName_SetsNormalizedName(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+	user := usercommon.NewUser("hoge_taro", "password1")
+	repo.CreateUser(ctx, user)
+	loaded, _ := repo.FindFirstUser(ctx, usercommon.User{ID: user.ID}, nil)
+	loaded.Name = "Piyo_Taro"
+
+	// Act
+	err := repo.UpdateUser(ctx, loaded)
+
+	// Assert
+	assert.NoError(err)
+	stored, _ := repo.FindFirstUser(ctx, usercommon.User{ID: user.ID}, nil)
+	if assert.NotNil(stored.NormalizedName) {
+		assert.Equal("piyo_taro", *stored.NormalizedName)
+	}
+}
+
+func TestRepository_UpdateUser_WithoutNormalizedName_KeepsStoredOne(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+	user := usercommon.NewUser("hoge_taro", "password1")
+	repo.CreateUser(ctx, user)
+
+	// Act
+	err := repo.UpdateUser(ctx, &usercommon.User{
+		ID: user.ID, Name: user.Name, Password: "password2", Version: user.Version})
+
+	// Assert
+	assert.NoError(err)
+	stored, _ := repo.FindFirstUser(ctx, usercommon.User{ID: user.ID}, nil)
+	if assert.NotNil(stored.NormalizedName) {
+		assert.Equal("hoge_taro", *stored.NormalizedName)
+	}
+}
+
+func TestRepository_UpdateUser_StaleVersion_Fails(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+	user := usercommon.NewUser("hoge_taro", "password1")
+	repo.CreateUser(ctx, user)
+	first, _ := repo.FindFirstUser(ctx, usercommon.User{ID: user.ID}, nil)
+	second, _ := repo.FindFirstUser(ctx, usercommon.User{ID: user.ID}, nil)
+	first.Name = "piyo_taro"
+	second.Name = "fuga_taro"
+
+	// Act
+	firstErr := repo.UpdateUser(ctx, first)
+	secondErr := repo.UpdateUser(ctx, second)
+
+	// Assert
+	assert.NoError(firstErr)
+	assert.Equal(usercommon.ErrOptimisticLock, secondErr)
+	assert.Equal(uint(1), second.Version)
+	stored, _ := repo.FindFirstUser(ctx, usercommon.User{ID: user.ID}, nil)
+	assert.Equal("piyo_taro", stored.Name)
+	assert.Equal(uint(2), stored.Version)
+}
+
+func TestRepository_UpdateUser_WithoutTokensValidAfter_KeepsStoredTime(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+	user := usercommon.NewUser("hoge_taro", "password1")
+	repo.CreateUser(ctx, user)
+	revokedAt := time.Now()
+	user.TokensValidAfter = &revokedAt
+	repo.UpdateUser(ctx, user)
+
+	// Act
+	err := repo.UpdateUser(ctx, &usercommon.User{
+		ID: user.ID, Name: user.Name, Password: "password2", Version: user.Version})
+
+	// Assert
+	assert.NoError(err)
+	stored, _ := repo.FindFirstUser(ctx, usercommon.User{ID: user.ID}, nil)
+	if assert.NotNil(stored.TokensValidAfter) {
+		assert.True(revokedAt.Equal(*stored.TokensValidAfter))
+	}
+}
+
+func TestRepository_UpdateLastLogin_KeepsVersionAndSurvivesUpdates(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+	user := usercommon.NewUser("hoge_taro", "password1")
+	repo.CreateUser(ctx, user)
+	staleUser, _ := repo.FindFirstUser(ctx, usercommon.User{ID: user.ID}, nil)
+	loginTime := time.Now().Add(-time.Minute)
+
+	// Act
+	err := repo.UpdateLastLogin(ctx, user.ID, loginTime)
+	staleUser.Name = "piyo_taro"
+	updateErr := repo.UpdateUser(ctx, staleUser)
+
+	// Assert
+	assert.NoError(err)
+	assert.NoError(updateErr)
+	stored, _ := repo.FindFirstUser(ctx, usercommon.User{ID: user.ID}, nil)
+	assert.Equal(uint(2), stored.Version)
+	assert.False(stored.CreatedAt.IsZero())
+	assert.Equal(user.CreatedAt.Unix(), stored.CreatedAt.Unix())
+	assert.NotNil(stored.LastLoginAt)
+	assert.Equal(loginTime.Unix(), stored.LastLoginAt.Unix())
+	assert.Nil(stored.LastSeenAt)
+}
+
+func TestRepository_UpdateLastSeen_UnknownUser_ReturnsNotFound(t *testing.T) {
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+
+	err := repo.UpdateLastSeen(ctx, "unknown", time.Now())
+
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+}
+
 func TestRepository_DeleteUser(t *testing.T) {
 	ctx, repo, tx := createContextRepoAndTx()
 	defer tx.Rollback()
@@ -330,3 +505,133 @@ func TestRepository_FindUserByCondition(t *testing.T) {
 	assert.Equal(t, "id00", results3[0].ID)
 	assert.Equal(t, "id01", results3[1].ID)
 }
+
+func TestRepository_UseInvite(t *testing.T) {
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+
+	invite, _ := usercommon.NewInvite("creator", 1, time.Now().Add(time.Hour))
+	_ = repo.CreateInvite(ctx, invite)
+
+	err1 := repo.UseInvite(ctx, invite)
+	err2 := repo.UseInvite(ctx, invite)
+	found, _ := repo.FindInvite(ctx, invite.Code)
+
+	assert.NoError(t, err1)
+	assert.Equal(t, gorm.ErrRecordNotFound, err2)
+	assert.Equal(t, 1, found.Uses)
+}
+
+func TestRepository_FindUserByCondition_InactiveSince(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+	now := time.Now()
+	users := createTestUserData(3)
+	for _, user := range users {
+		user.CreatedAt = now.Add(-48 * time.Hour)
+	}
+	insertTestDataToTx(tx, users)
+	repo.UpdateLastLogin(ctx, "id01", now.Add(-time.Hour))
+	repo.UpdateLastLogin(ctx, "id02", now.Add(-36*time.Hour))
+	condition := &usercommon.Condition{
+		SortConditions: []string{"id"},
+		InactiveSince:  now.Add(-24 * time.Hour),
+	}
+
+	// Act
+	results, err := repo.FindUserByCondition(ctx, condition)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(results, 2)
+	assert.Equal("id00", results[0].ID)
+	assert.Equal("id02", results[1].ID)
+}
+
+func TestRepository_DeleteUser_KeepsNameTakenAndRestorable(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+	user := usercommon.NewUser("Hoge_Taro", "password1")
+	user.RefreshToken = "refresh"
+	repo.CreateUser(ctx, user)
+
+	// Act
+	deleteErr := repo.DeleteUser(ctx, user)
+	_, findErr := repo.FindFirstUser(ctx, usercommon.User{ID: user.ID}, nil)
+	taken, takenErr := repo.IsNameTaken(ctx, "hoge_taro", time.Now())
+	deleted, deletedErr := repo.FindDeletedUser(ctx, "hoge_taro")
+	wasDeleted := deleted.DeletedAt.Valid
+	restoreErr := repo.RestoreUser(ctx, deleted)
+	restored, restoredErr := repo.FindFirstUser(ctx, usercommon.User{ID: user.ID}, nil)
+
+	// Assert
+	assert.NoError(deleteErr)
+	assert.Equal(gorm.ErrRecordNotFound, findErr)
+	assert.NoError(takenErr)
+	assert.True(taken)
+	assert.NoError(deletedErr)
+	assert.Equal(user.ID, deleted.ID)
+	assert.True(wasDeleted)
+	assert.Empty(deleted.RefreshToken)
+	assert.NotNil(deleted.TokensValidAfter)
+	assert.NoError(restoreErr)
+	assert.NoError(restoredErr)
+	assert.False(restored.DeletedAt.Valid)
+}
+
+func TestRepository_PurgeUsers_DeletesUsersAndInvitesAndReservesNames(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+	now := time.Now()
+	users := createTestUserData(2)
+	repo.CreateUsers(ctx, users)
+	invite, _ := usercommon.NewInvite("id00", 1, now.Add(time.Hour))
+	repo.CreateInvite(ctx, invite)
+	repo.DeleteUser(ctx, users[0])
+	repo.DeleteUser(ctx, users[1])
+	tx.Unscoped().Model(users[0]).Update("deleted_at", now.Add(-48*time.Hour))
+
+	// Act
+	purged, err := repo.PurgeUsers(ctx, now.Add(-24*time.Hour), now.Add(time.Hour))
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(purged, 1)
+	assert.Equal("id00", purged[0].ID)
+	_, err = repo.FindDeletedUser(ctx, "name00")
+	assert.Equal(gorm.ErrRecordNotFound, err)
+	_, err = repo.FindDeletedUser(ctx, "name01")
+	assert.NoError(err)
+	_, err = repo.FindInvite(ctx, invite.Code)
+	assert.Equal(gorm.ErrRecordNotFound, err)
+	taken, _ := repo.IsNameTaken(ctx, "name00", now)
+	assert.True(taken)
+	taken, _ = repo.IsNameTaken(ctx, "name00", now.Add(2*time.Hour))
+	assert.False(taken)
+}
+
+func TestRepository_DeleteExpiredNameReservations(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ctx, repo, tx := createContextRepoAndTx()
+	defer tx.Rollback()
+	now := time.Now()
+	tx.Create(&usercommon.NameReservation{NormalizedName: "expired", ExpiresAt: now})
+	tx.Create(&usercommon.NameReservation{
+		NormalizedName: "reserved", ExpiresAt: now.Add(time.Hour)})
+
+	// Act
+	err := repo.DeleteExpiredNameReservations(ctx, now)
+
+	// Assert
+	assert.NoError(err)
+	var names []string
+	tx.Model(&usercommon.NameReservation{}).Pluck("normalized_name", &names)
+	assert.Equal([]string{"reserved"}, names)
+}
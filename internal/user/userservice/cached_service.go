@@ -0,0 +1,224 @@
+package userservice
+
+import (
+	"container/list"
+	context "context"
+	"p2pderivatives-server/internal/common/metrics"
+	"p2pderivatives-server/internal/database/interceptor"
+	"p2pderivatives-server/internal/user/usercommon"
+	"sync"
+	"time"
+)
+
+// CachedService is a user service caching the users found by ID, which is
+// how the callers of the message relay methods are identified. Users are
+// evicted from the cache when they are modified through the service, and
+// expire after a TTL bounding the time during which the modifications made
+// by other server instances are not seen.
+//
+// Modified users are evicted both when modified and when the transaction of
+// the modification ends, as they could otherwise be cached again in between
+// with the state read by a concurrent request before the commit, or with the
+// uncommitted state read later in the transaction if it is rollbacked.
+type CachedService struct {
+	usercommon.ServiceIf
+	cache *userCache
+}
+
+// NewCachedService returns a service caching the users found by ID by the
+// given service, up to the cache size and for the cache TTL of the given
+// configuration.
+func NewCachedService(
+	service usercommon.ServiceIf, config *usercommon.Config) *CachedService {
+	return &CachedService{
+		ServiceIf: service,
+		cache:     newUserCache(config.UserCacheSize, config.UserCacheTTL),
+	}
+}
+
+// FindFirstUser returns the first user matching the given condition, from the
+// cache if the condition only has an ID.
+func (s *CachedService) FindFirstUser(
+	ctx context.Context,
+	condition *usercommon.User,
+	orders []string) (*usercommon.User, error) {
+	if condition == nil || condition.ID == "" ||
+		*condition != (usercommon.User{ID: condition.ID}) {
+		return s.ServiceIf.FindFirstUser(ctx, condition, orders)
+	}
+	if user, ok := s.cache.get(condition.ID, time.Now()); ok {
+		metrics.UserCacheLookups.WithLabelValues(metrics.CacheResultHit).Inc()
+		return user, nil
+	}
+	metrics.UserCacheLookups.WithLabelValues(metrics.CacheResultMiss).Inc()
+	user, err := s.ServiceIf.FindFirstUser(ctx, condition, orders)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.add(user, time.Now())
+	return user, nil
+}
+
+// UpdateUser updates the user and evicts it from the cache.
+func (s *CachedService) UpdateUser(
+	ctx context.Context, condition *usercommon.User) (*usercommon.User, error) {
+	defer s.evict(ctx, condition.ID)
+	return s.ServiceIf.UpdateUser(ctx, condition)
+}
+
+// DeleteUser deletes the user and evicts it from the cache.
+func (s *CachedService) DeleteUser(
+	ctx context.Context, condition *usercommon.User) error {
+	if condition.ID == "" {
+		defer s.evictAll(ctx)
+	} else {
+		defer s.evict(ctx, condition.ID)
+	}
+	return s.ServiceIf.DeleteUser(ctx, condition)
+}
+
+// AuthenticateUser authenticates the user and evicts it from the cache as
+// its last login time is updated.
+func (s *CachedService) AuthenticateUser(
+	ctx context.Context,
+	account, password string) (*usercommon.User, *usercommon.TokenInfo, error) {
+	user, tokenInfo, err := s.ServiceIf.AuthenticateUser(ctx, account, password)
+	if user != nil {
+		s.evict(ctx, user.ID)
+	}
+	return user, tokenInfo, err
+}
+
+// ChangeUserPassword changes the password of the user and evicts it from the
+// cache.
+func (s *CachedService) ChangeUserPassword(
+	ctx context.Context,
+	userID, newPassword, oldPassword string) (*usercommon.User, error) {
+	defer s.evict(ctx, userID)
+	return s.ServiceIf.ChangeUserPassword(ctx, userID, newPassword, oldPassword)
+}
+
+// UpdateLastSeen updates the last seen time of the user and evicts it from
+// the cache.
+func (s *CachedService) UpdateLastSeen(ctx context.Context, userID string) error {
+	defer s.evict(ctx, userID)
+	return s.ServiceIf.UpdateLastSeen(ctx, userID)
+}
+
+// RestoreUser restores the deleted user and evicts it from the cache.
+func (s *CachedService) RestoreUser(
+	ctx context.Context, name string) (*usercommon.User, error) {
+	user, err := s.ServiceIf.RestoreUser(ctx, name)
+	if user != nil {
+		s.evict(ctx, user.ID)
+	}
+	return user, err
+}
+
+// PurgeDeletedUsers purges the users whose grace period expired and evicts
+// them from the cache.
+func (s *CachedService) PurgeDeletedUsers(
+	ctx context.Context) ([]usercommon.User, error) {
+	users, err := s.ServiceIf.PurgeDeletedUsers(ctx)
+	for _, user := range users {
+		s.evict(ctx, user.ID)
+	}
+	return users, err
+}
+
+// evict removes the user with the given ID from the cache, now and when the
+// transaction of the context ends.
+func (s *CachedService) evict(ctx context.Context, id string) {
+	s.cache.remove(id)
+	interceptor.OnCompletion(ctx, func(bool) { s.cache.remove(id) })
+}
+
+// evictAll clears the cache, now and when the transaction of the context
+// ends.
+func (s *CachedService) evictAll(ctx context.Context) {
+	s.cache.clear()
+	interceptor.OnCompletion(ctx, func(bool) { s.cache.clear() })
+}
+
+// userCache is a LRU cache of users by ID whose entries expire after a TTL.
+// It is disabled when its size is not positive.
+type userCache struct {
+	sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	// lru holds the cache entries, the most recently used first.
+	lru *list.List
+}
+
+type userCacheEntry struct {
+	user      usercommon.User
+	expiresAt time.Time
+}
+
+func newUserCache(size int, ttl time.Duration) *userCache {
+	return &userCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get returns a copy of the cached user with the given ID unless expired at
+// the given time.
+func (c *userCache) get(id string, now time.Time) (*usercommon.User, bool) {
+	c.Lock()
+	defer c.Unlock()
+	element, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*userCacheEntry)
+	if !now.Before(entry.expiresAt) {
+		c.lru.Remove(element)
+		delete(c.entries, id)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	user := entry.user
+	return &user, true
+}
+
+// add caches a copy of the given user, evicting the least recently used user
+// if the cache is full.
+func (c *userCache) add(user *usercommon.User, now time.Time) {
+	if c.size <= 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	entry := &userCacheEntry{user: *user, expiresAt: now.Add(c.ttl)}
+	if element, ok := c.entries[user.ID]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[user.ID] = c.lru.PushFront(entry)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*userCacheEntry).user.ID)
+	}
+}
+
+func (c *userCache) remove(id string) {
+	c.Lock()
+	defer c.Unlock()
+	if element, ok := c.entries[id]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, id)
+	}
+}
+
+func (c *userCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
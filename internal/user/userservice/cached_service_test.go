@@ -0,0 +1,221 @@
+package userservice_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"p2pderivatives-server/internal/common/grpc/pbbase"
+	"p2pderivatives-server/internal/common/servererror"
+	"p2pderivatives-server/internal/database/interceptor"
+	"p2pderivatives-server/internal/user/usercommon"
+	"p2pderivatives-server/internal/user/userservice"
+	"p2pderivatives-server/test"
+	"p2pderivatives-server/test/mocks/mock_userrepository"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// countingRepository counts the users read from the repository.
+type countingRepository struct {
+	*mock_userrepository.RepositoryMock
+	finds int
+}
+
+func (repo *countingRepository) FindFirstUser(
+	ctx context.Context,
+	condition interface{},
+	orders []string) (*usercommon.User, error) {
+	repo.finds++
+	return repo.RepositoryMock.FindFirstUser(ctx, condition, orders)
+}
+
+func createCachedService(
+	size int, ttl time.Duration) (*countingRepository, *userservice.CachedService) {
+	repo := &countingRepository{RepositoryMock: mock_userrepository.NewRepositoryMock()}
+	config := usercommon.DefaultUserConfiguration()
+	config.UserCacheSize = size
+	config.UserCacheTTL = ttl
+	service, _ := userservice.NewService(repo, config, &servererror.ServiceError{})
+	return repo, userservice.NewCachedService(service, config)
+}
+
+func createCachedUsers(repo *countingRepository, ids ...string) {
+	for _, id := range ids {
+		repo.CreateUser(context.Background(), &usercommon.User{ID: id, Name: id})
+	}
+}
+
+func TestCachedService_FindFirstUserByID_ReadsRepositoryOnce(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, service := createCachedService(10, time.Minute)
+	createCachedUsers(repo, "user1")
+	ctx := context.Background()
+
+	// Act
+	first, err1 := service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+	second, err2 := service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+
+	// Assert
+	assert.NoError(err1)
+	assert.NoError(err2)
+	assert.Equal("user1", second.Name)
+	assert.NotSame(first, second)
+	assert.Equal(1, repo.finds)
+}
+
+func TestCachedService_FindFirstUserByOtherCondition_IsNotCached(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, service := createCachedService(10, time.Minute)
+	createCachedUsers(repo, "user1")
+	ctx := context.Background()
+	condition := &usercommon.User{ID: "user1", Name: "user1"}
+
+	// Act
+	service.FindFirstUser(ctx, condition, nil)
+	service.FindFirstUser(ctx, condition, nil)
+
+	// Assert
+	assert.Equal(2, repo.finds)
+}
+
+func TestCachedService_AfterTTL_ReadsRepositoryAgain(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, service := createCachedService(10, time.Millisecond)
+	createCachedUsers(repo, "user1")
+	ctx := context.Background()
+
+	// Act
+	service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+	time.Sleep(2 * time.Millisecond)
+	service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+
+	// Assert
+	assert.Equal(2, repo.finds)
+}
+
+func TestCachedService_WhenFull_EvictsLeastRecentlyUsed(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, service := createCachedService(2, time.Minute)
+	createCachedUsers(repo, "user1", "user2", "user3")
+	ctx := context.Background()
+	for _, id := range []string{"user1", "user2", "user1", "user3"} {
+		service.FindFirstUser(ctx, &usercommon.User{ID: id}, nil)
+	}
+	repo.finds = 0
+
+	// Act
+	service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+	service.FindFirstUser(ctx, &usercommon.User{ID: "user3"}, nil)
+	service.FindFirstUser(ctx, &usercommon.User{ID: "user2"}, nil)
+
+	// Assert
+	assert.Equal(1, repo.finds)
+}
+
+func TestCachedService_WithZeroSize_IsDisabled(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, service := createCachedService(0, time.Minute)
+	createCachedUsers(repo, "user1")
+	ctx := context.Background()
+
+	// Act
+	service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+	service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+
+	// Assert
+	assert.Equal(2, repo.finds)
+}
+
+func TestCachedService_OnDelete_EvictsUser(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, service := createCachedService(10, time.Minute)
+	createCachedUsers(repo, "user1")
+	ctx := context.Background()
+	service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+
+	// Act
+	err := service.DeleteUser(ctx, &usercommon.User{ID: "user1"})
+	_, findErr := service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+
+	// Assert
+	assert.NoError(err)
+	assert.Error(findErr)
+}
+
+func TestCachedService_OnUpdate_EvictsUser(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, service := createCachedService(10, time.Minute)
+	createCachedUsers(repo, "user1")
+	ctx := context.Background()
+	user, _ := service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+	user.RequireChangePassword = true
+
+	// Act
+	_, err := service.UpdateUser(ctx, user)
+	updated, findErr := service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+
+	// Assert
+	assert.NoError(err)
+	assert.NoError(findErr)
+	assert.True(updated.RequireChangePassword)
+}
+
+func TestCachedService_UserReadAgainBeforeTransactionEnds_IsEvicted(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo, service := createCachedService(10, time.Minute)
+	createCachedUsers(repo, "user1")
+	ormInstance := test.InitializeORM()
+	defer ormInstance.Finalize()
+	txInterceptor := interceptor.TransactionUnaryServerInterceptor(
+		test.GetTestLogger(test.GetTestConfig()).NewEntry(),
+		func(string) pbbase.TxOption { return pbbase.TxOption_ReadWrite },
+		func(string) sql.IsolationLevel { return sql.LevelDefault },
+		&interceptor.RetryConfig{},
+		ormInstance)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		service.UpdateUser(ctx, &usercommon.User{ID: "user1", RequireChangePassword: true})
+		service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+		return nil, errors.New("error")
+	}
+
+	// Act
+	txInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	findsBefore := repo.finds
+	service.FindFirstUser(context.Background(), &usercommon.User{ID: "user1"}, nil)
+
+	// Assert
+	assert.Equal(findsBefore+1, repo.finds)
+}
+
+func BenchmarkFindFirstUserByID(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		size int
+	}{
+		{name: "uncached", size: 0},
+		{name: "cached", size: 1000},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			repo, service := createCachedService(bench.size, time.Minute)
+			createCachedUsers(repo, "user1")
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				service.FindFirstUser(ctx, &usercommon.User{ID: "user1"}, nil)
+			}
+			b.ReportMetric(float64(repo.finds)/float64(b.N), "queries/op")
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package userservice
+
+import (
+	"context"
+	"time"
+
+	"p2pderivatives-server/internal/audit"
+	"p2pderivatives-server/internal/database/interceptor"
+	"p2pderivatives-server/internal/user/usercommon"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Purger permanently deletes the users whose deletion grace period expired.
+type Purger struct {
+	service     usercommon.ServiceIf
+	db          interceptor.DB
+	log         *logrus.Entry
+	auditLogger *audit.Logger
+}
+
+// NewPurger creates a new Purger struct.
+func NewPurger(
+	service usercommon.ServiceIf,
+	db interceptor.DB,
+	log *logrus.Entry,
+	auditLogger *audit.Logger) *Purger {
+	return &Purger{
+		service:     service,
+		db:          db,
+		log:         log,
+		auditLogger: auditLogger,
+	}
+}
+
+// Purge purges the users in a transaction of its own and returns them.
+func (purger *Purger) Purge(ctx context.Context) ([]usercommon.User, error) {
+	tx := purger.db.GetDB().Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	users, err := purger.service.PurgeDeletedUsers(interceptor.SaveTx(ctx, tx))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		purger.auditLogger.Record(ctx, &audit.Event{
+			Type:     audit.EventPurge,
+			UserID:   user.ID,
+			UserName: user.Name,
+			Success:  true,
+		})
+	}
+	return users, nil
+}
+
+// Run purges the users at the given interval until the context is done.
+func (purger *Purger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			users, err := purger.Purge(ctx)
+			if err != nil {
+				purger.log.Errorf("failed to purge deleted users: %v", err)
+			} else if len(users) > 0 {
+				purger.log.Infof("purged %d deleted users", len(users))
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package userservice_test
+
+import (
+	"context"
+	"testing"
+
+	"p2pderivatives-server/internal/audit"
+	"p2pderivatives-server/internal/common/servererror"
+	"p2pderivatives-server/internal/user/usercommon"
+	"p2pderivatives-server/internal/user/userservice"
+	"p2pderivatives-server/test"
+	"p2pderivatives-server/test/mocks/mock_userrepository"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurgerPurge_AfterGracePeriod_PurgesAndRecordsEvents(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	ormInstance := test.InitializeORM(&audit.Event{})
+	auditRepository := audit.NewRepository(ormInstance.GetDB())
+	auditLogger, _ := audit.NewLogger(&audit.Config{Enabled: true}, auditRepository)
+	config := usercommon.DefaultUserConfiguration()
+	config.DeletionGracePeriod = 0
+	service, _ := userservice.NewService(
+		mock_userrepository.NewRepositoryMock(), config, &servererror.ServiceError{})
+	ctx := context.Background()
+	user := usercommon.NewUser(name, password)
+	service.CreateUser(ctx, user)
+	service.DeleteUser(ctx, user)
+	purger := userservice.NewPurger(
+		service, ormInstance, logrus.NewEntry(logrus.New()), auditLogger)
+
+	// Act
+	purged, err := purger.Purge(ctx)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(purged, 1)
+	events, _ := auditRepository.FindEvents(
+		ctx, &audit.Filter{Type: audit.EventPurge}, 0, 10)
+	assert.Len(events, 1)
+	assert.Equal(user.ID, events[0].UserID)
+}
@@ -2,9 +2,15 @@ package userservice
 
 import (
 	context "context"
+	"errors"
+	"p2pderivatives-server/internal/common/metrics"
 	"p2pderivatives-server/internal/common/servererror"
 	"p2pderivatives-server/internal/common/token"
+	"p2pderivatives-server/internal/common/tracing"
+	"p2pderivatives-server/internal/database/interceptor"
 	"p2pderivatives-server/internal/user/usercommon"
+	"regexp"
+	"time"
 	"unicode"
 
 	"github.com/cryptogarageinc/server-common-go/pkg/database/orm"
@@ -17,35 +23,64 @@ import (
 type Service struct {
 	userConfig     *usercommon.Config
 	userRepository usercommon.RepositoryIf
+	namePattern    *regexp.Regexp
 	*servererror.ServiceError
 }
 
-// NewService creates a new UserService instance.
+// NewService creates a new UserService instance, failing if the name pattern
+// of the given configuration is not a valid regular expression.
 func NewService(
 	repository usercommon.RepositoryIf,
 	config *usercommon.Config,
-	serviceError *servererror.ServiceError) *Service {
+	serviceError *servererror.ServiceError) (*Service, error) {
+	var namePattern *regexp.Regexp
+	if config.NamePattern != "" {
+		var err error
+		namePattern, err = regexp.Compile(config.NamePattern)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &Service{
 		userRepository: repository,
 		userConfig:     config,
+		namePattern:    namePattern,
 		ServiceError:   serviceError,
-	}
+	}, nil
 }
 
 // CreateUser creates a new user in the system.
 func (s *Service) CreateUser(ctx context.Context, condition *usercommon.User) (*usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.CreateUser")
+	defer span.End()
 	if !VerifyNewPassword(condition.Password) {
-		return nil, s.CreateServiceError(ctx, servererror.InvalidArguments, "Failed to create user, password does not meet policy", nil)
+		return nil, s.CreateServiceErrorWithFieldViolations(ctx, servererror.InvalidArguments, "Failed to create user, password does not meet policy", nil, servererror.ErrorDetailCodePasswordInvalid,
+			[]servererror.FieldViolation{{Field: "password", Description: "The password does not meet the password policy."}})
+	}
+
+	normalizedName, ok := VerifyNewName(condition.Name, s.namePattern, s.userConfig.ReservedNames)
+	if !ok {
+		return nil, s.CreateServiceErrorWithFieldViolations(ctx, servererror.InvalidArguments, "Failed to create user, name does not meet policy", nil, servererror.ErrorDetailCodeNameInvalid,
+			[]servererror.FieldViolation{{Field: "name", Description: "The name is reserved or does not match the name pattern."}})
+	}
+
+	// The names of unregistered users are kept so that their messages are not
+	// received by another user.
+	taken, err := s.userRepository.IsNameTaken(ctx, normalizedName, time.Now())
+	if err != nil {
+		return nil, s.createInternalError(ctx, servererror.DbError, "Failed to create User.", err)
+	} else if taken {
+		return nil, s.CreateServiceErrorWithDetail(ctx, servererror.AlreadyExistError, "User with same name already exists.", nil, servererror.ErrorDetailCodeNameTaken, nil)
 	}
 
 	hashedPasswordCondition, err := s.createHashedPasswordUser(condition)
 
 	if err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.InternalError, "Failed to create User.", err)
+		return nil, s.createInternalError(ctx, servererror.InternalError, "Failed to create User.", err)
 	}
 
 	if err := s.userRepository.CreateUser(ctx, hashedPasswordCondition); err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.DbError, "Failed to create User.", err)
+		return nil, s.createInternalError(ctx, servererror.DbError, "Failed to create User.", err)
 	}
 	return hashedPasswordCondition, nil
 }
@@ -53,12 +88,14 @@ func (s *Service) CreateUser(ctx context.Context, condition *usercommon.User) (*
 // FindFirstUser returns the first user matching the given condition.
 func (s *Service) FindFirstUser(
 	ctx context.Context, condition *usercommon.User, orders []string) (*usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.FindFirstUser")
+	defer span.End()
 	findUsers, err := s.userRepository.FindFirstUser(ctx, condition, orders)
 	if err != nil {
 		if !orm.IsRecordNotFoundError(err) {
-			return nil, s.CreateServiceError(ctx, servererror.DbError, "Failed to find User.", err)
+			return nil, s.createInternalError(ctx, servererror.DbError, "Failed to find User.", err)
 		}
-		return nil, s.CreateServiceError(ctx, servererror.NotFoundError, "User is not found.", err)
+		return nil, s.createUserNotFoundError(ctx, "User is not found.", err)
 	}
 	return findUsers, nil
 }
@@ -67,12 +104,14 @@ func (s *Service) FindFirstUser(
 // name.
 func (s *Service) FindFirstUserByName(
 	ctx context.Context, name string) (*usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.FindFirstUserByName")
+	defer span.End()
 	findUsers, err := s.userRepository.FindFirstUser(ctx, usercommon.User{Name: name}, nil)
 	if err != nil {
 		if !orm.IsRecordNotFoundError(err) {
-			return nil, s.CreateServiceError(ctx, servererror.DbError, "Failed to find User.", err)
+			return nil, s.createInternalError(ctx, servererror.DbError, "Failed to find User.", err)
 		}
-		return nil, s.CreateServiceError(ctx, servererror.NotFoundError, "User is not found.", err)
+		return nil, s.createUserNotFoundError(ctx, "User is not found.", err)
 	}
 	return findUsers, nil
 }
@@ -81,118 +120,239 @@ func (s *Service) FindFirstUserByName(
 func (s *Service) FindUsers(
 	ctx context.Context, user *usercommon.User, offset int,
 	limit int, orders []string) ([]usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.FindUsers")
+	defer span.End()
 	condition := usercommon.User{
 		ID:   user.ID,
 		Name: user.Name,
 	}
 	findUser, err := s.userRepository.FindUsers(ctx, condition, offset, limit, orders)
 	if err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.NotFoundError, "User is not found.", err)
+		return nil, s.createUserNotFoundError(ctx, "User is not found.", err)
 	}
 	return findUser, nil
 }
 
 // GetAllUsers returns all the users registered in the system.
 func (s *Service) GetAllUsers(ctx context.Context) (users []usercommon.User, err error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.GetAllUsers")
+	defer span.End()
 	users, err = s.userRepository.GetAllUsers(ctx)
+	if err != nil {
+		return nil, s.createInternalError(ctx, servererror.DbError, "Failed to find users.", err)
+	}
 	return
 }
 
 // UpdateUser update a user information and returns the new user
 func (s *Service) UpdateUser(ctx context.Context, condition *usercommon.User) (*usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.UpdateUser")
+	defer span.End()
 	targetUser, err := s.userRepository.FindFirstUser(ctx, &usercommon.User{ID: condition.ID}, nil)
 	if err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.NotFoundError, "Failed to find user", err)
+		return nil, s.createUserNotFoundError(ctx, "Failed to find user", err)
 	}
 	condition.Password = targetUser.Password
+	if condition.Version == 0 {
+		condition.Version = targetUser.Version
+	}
+	if condition.NormalizedName == nil {
+		condition.NormalizedName = targetUser.NormalizedName
+	}
 	if err := s.userRepository.UpdateUser(ctx, condition); err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.NotFoundError, "Failed to update User", err)
+		return nil, s.createUpdateUserError(ctx, servererror.NotFoundError, "Failed to update User", err)
 	}
 	return condition, nil
 }
 
-// ChangeUserPassword changes the user password and returns the new user
+// ChangeUserPassword changes the user password and returns the new user. The
+// tokens previously issued to the user are revoked once the change is
+// committed.
 func (s *Service) ChangeUserPassword(
 	ctx context.Context,
 	userID string,
 	newPassword string,
 	oldPassword string,
 ) (*usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.ChangeUserPassword")
+	defer span.End()
 	targetUser, err := s.FindFirstUser(ctx, &usercommon.User{ID: userID}, nil)
 	if err != nil {
 		// Use the same message when returning different type of errors on
 		// password change.
-		return nil, s.CreateServiceError(ctx, servererror.InvalidArguments, "Failed to update user password", err)
+		return nil, s.CreateServiceErrorWithDetail(ctx, servererror.InvalidArguments, "Failed to update user password", err, servererror.ErrorDetailCodeCredentialsInvalid, nil)
 	}
-	if !s.isPasswordValid(oldPassword, targetUser.Password) {
+	// The new password is verified first so that its error does not tell
+	// whether the old password is valid.
+	if !VerifyNewPassword(newPassword) {
 		// Use the same message when returning different type of errors on
 		// password change.
-		return nil, s.CreateServiceError(ctx, servererror.InvalidArguments, "Failed to update user password", nil)
+		return nil, s.CreateServiceErrorWithDetail(ctx, servererror.InvalidArguments, "Failed to update user password", nil, servererror.ErrorDetailCodePasswordInvalid, nil)
 	}
-	if !VerifyNewPassword(newPassword) {
+	if !s.isPasswordValid(oldPassword, targetUser.Password) {
 		// Use the same message when returning different type of errors on
 		// password change.
-		return nil, s.CreateServiceError(ctx, servererror.InvalidArguments, "Failed to update user password", nil)
+		return nil, s.CreateServiceErrorWithDetail(ctx, servererror.InvalidArguments, "Failed to update user password", nil, servererror.ErrorDetailCodeCredentialsInvalid, nil)
 	}
 
+	revokedAt := time.Now()
 	hashedPasswordUser, err := s.createHashedPasswordUser(&usercommon.User{
 		ID:                    targetUser.ID,
 		Name:                  targetUser.Name,
+		NormalizedName:        targetUser.NormalizedName,
 		Password:              newPassword,
 		RequireChangePassword: false,
-		RefreshToken:          targetUser.RefreshToken,
+		Version:               targetUser.Version,
+		TokensValidAfter:      &revokedAt,
 	})
 
 	if err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.InternalError, "Failed to update user password", err)
+		return nil, s.createInternalError(ctx, servererror.InternalError, "Failed to update user password", err)
 	}
 
 	if err := s.userRepository.UpdateUser(ctx, hashedPasswordUser); err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.NotFoundError, "Failed to update User", err)
+		return nil, s.createUpdateUserError(ctx, servererror.NotFoundError, "Failed to update User", err)
 	}
+	revokeUserTokens(ctx, hashedPasswordUser.ID)
 	return hashedPasswordUser, nil
 }
 
 // ResetUserPassword resets the password of the user associated with the given
-// account and return the reset user.
+// account and return the reset user. The tokens previously issued to the user
+// are revoked once the reset is committed.
 func (s *Service) ResetUserPassword(
 	ctx context.Context,
 	name string,
 	newPassword string,
 ) (*usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.ResetUserPassword")
+	defer span.End()
 	targetUser, err := s.FindFirstUserByName(ctx, name)
 	if err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.NotFoundError, "Failed to find user", err)
+		return nil, s.createUserNotFoundError(ctx, "Failed to find user", err)
 	}
 	if !verifyNewPassword(newPassword) {
-		return nil, s.CreateServiceError(ctx, servererror.PreconditionError, "Failed to verify new password", nil)
+		return nil, s.CreateServiceErrorWithDetail(ctx, servererror.PreconditionError, "Failed to verify new password", nil, servererror.ErrorDetailCodePasswordInvalid, nil)
 	}
+	revokedAt := time.Now()
 	hashedPasswordUser, err := s.createHashedPasswordUser(&usercommon.User{
 		ID:                    targetUser.ID,
 		Name:                  targetUser.Name,
+		NormalizedName:        targetUser.NormalizedName,
 		Password:              newPassword,
 		RequireChangePassword: true,
+		Version:               targetUser.Version,
+		TokensValidAfter:      &revokedAt,
 	})
 
 	if err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.InternalError, "Failed to reset password", err)
+		return nil, s.createInternalError(ctx, servererror.InternalError, "Failed to reset password", err)
 	}
 
 	if err := s.userRepository.UpdateUser(ctx, hashedPasswordUser); err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.NotFoundError, "Failed to update User", err)
+		return nil, s.createUpdateUserError(ctx, servererror.NotFoundError, "Failed to update User", err)
 	}
+	revokeUserTokens(ctx, hashedPasswordUser.ID)
 	return hashedPasswordUser, nil
 }
 
-// DeleteUser deletes the user associated with the given condition.
+// DeleteUser deletes the user associated with the given condition and revokes
+// its tokens once the deletion is committed. The user can be restored until the end of the deletion grace
+// period, after which it is purged.
 func (s *Service) DeleteUser(ctx context.Context, condition *usercommon.User) error {
+	ctx, span := tracing.StartSpan(ctx, "userservice.DeleteUser")
+	defer span.End()
 	if err := s.userRepository.DeleteUser(ctx, condition); err != nil {
-		return s.CreateServiceError(ctx, servererror.NotFoundError, "Failed to delete User.", err)
+		return s.createUserNotFoundError(ctx, "Failed to delete User.", err)
+	}
+	if condition.ID != "" {
+		revokeUserTokens(ctx, condition.ID)
 	}
 
 	return nil
 }
 
+// RestoreUser restores the deleted user with the given name if its deletion
+// grace period did not expire.
+func (s *Service) RestoreUser(ctx context.Context, name string) (*usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.RestoreUser")
+	defer span.End()
+	normalizedName, err := usercommon.NormalizeName(name)
+	if err != nil {
+		return nil, s.createUserNotFoundError(ctx, "No deleted user with this name.", err)
+	}
+	user, err := s.userRepository.FindDeletedUser(ctx, normalizedName)
+	if orm.IsRecordNotFoundError(err) {
+		return nil, s.createUserNotFoundError(ctx, "No deleted user with this name.", err)
+	} else if err != nil {
+		return nil, s.createInternalError(ctx, servererror.DbError, "Failed to restore user.", err)
+	}
+	// The user may not have been purged yet.
+	if time.Since(user.DeletedAt.Time) > s.userConfig.DeletionGracePeriod {
+		return nil, s.CreateServiceErrorWithDetail(ctx, servererror.PreconditionError, "The deletion grace period of the user expired.", nil, servererror.ErrorDetailCodeRestorationExpired, nil)
+	}
+	if err := s.userRepository.RestoreUser(ctx, user); err != nil {
+		return nil, s.createInternalError(ctx, servererror.DbError, "Failed to restore user.", err)
+	}
+	return user, nil
+}
+
+// PurgeDeletedUsers permanently deletes the users whose deletion grace period
+// expired together with their invites, reserves their names and returns
+// them. Expired name reservations are deleted.
+func (s *Service) PurgeDeletedUsers(ctx context.Context) ([]usercommon.User, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.PurgeDeletedUsers")
+	defer span.End()
+	now := time.Now()
+	users, err := s.userRepository.PurgeUsers(
+		ctx,
+		now.Add(-s.userConfig.DeletionGracePeriod),
+		now.Add(s.userConfig.NameReservation))
+	if err != nil {
+		return nil, s.createInternalError(ctx, servererror.DbError, "Failed to purge users.", err)
+	}
+	if err := s.userRepository.DeleteExpiredNameReservations(ctx, now); err != nil {
+		return nil, s.createInternalError(ctx, servererror.DbError, "Failed to delete name reservations.", err)
+	}
+	return users, nil
+}
+
+// createUpdateUserError creates the error of a failed user update, with the
+// given code and message unless the user was modified by another request.
+func (s *Service) createUpdateUserError(
+	ctx context.Context, code servererror.ErrorCode, message string, err error) error {
+	if errors.Is(err, usercommon.ErrOptimisticLock) {
+		// The request can be retried right away as it reads the user again.
+		return servererror.WithRetryDelay(s.CreateServiceErrorWithDetail(
+			ctx,
+			servererror.OptimisticLockError,
+			"User was modified by another request.",
+			err,
+			servererror.ErrorDetailCodeConcurrentUpdate,
+			nil), 0)
+	}
+	if code == servererror.NotFoundError {
+		return s.createUserNotFoundError(ctx, message, err)
+	}
+	return s.createInternalError(ctx, code, message, err)
+}
+
+// createUserNotFoundError creates a not found error of a user with the given
+// message.
+func (s *Service) createUserNotFoundError(
+	ctx context.Context, message string, err error) error {
+	return s.CreateServiceErrorWithDetail(
+		ctx, servererror.NotFoundError, message, err, servererror.ErrorDetailCodeUserNotFound, nil)
+}
+
+// createInternalError creates an error with the given code and message for a
+// failure that the client cannot fix.
+func (s *Service) createInternalError(
+	ctx context.Context, code servererror.ErrorCode, message string, err error) error {
+	return s.CreateServiceErrorWithDetail(
+		ctx, code, message, err, servererror.ErrorDetailCodeUnknown, nil)
+}
+
 func (s *Service) createHashedPasswordUser(
 	user *usercommon.User) (*usercommon.User, error) {
 	if user.Password == "" {
@@ -208,98 +368,211 @@ func (s *Service) createHashedPasswordUser(
 	updatedUser := &usercommon.User{
 		ID:                    user.ID,
 		Name:                  user.Name,
+		NormalizedName:        user.NormalizedName,
 		Password:              protectedForm,
 		RequireChangePassword: user.RequireChangePassword,
 		RefreshToken:          user.RefreshToken,
+		Version:               user.Version,
+		TokensValidAfter:      user.TokensValidAfter,
 	}
 
 	return updatedUser, nil
 }
 
+// revokeUserTokens revokes the tokens of the user with the given ID in this
+// server once the transaction of the given context is committed, ending its
+// message streams. Other servers reject the tokens issued before the
+// TokensValidAfter time of the user.
+func revokeUserTokens(ctx context.Context, userID string) {
+	interceptor.AfterCommit(ctx, func() {
+		token.RevokeUserTokens(userID)
+	})
+}
+
 // AuthenticateUser checks that the password provided matches the one of the
 // associated user. If it does, returns the matching user and the token info to
 // be used as authentication, otherwise returns an error.
 func (s *Service) AuthenticateUser(
 	ctx context.Context, name, password string) (*usercommon.User, *usercommon.TokenInfo, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.AuthenticateUser")
+	defer span.End()
 	condition := usercommon.User{
 		Name: name,
 	}
 	userInfo, err := s.userRepository.FindFirstUser(ctx, condition, []string{})
 	if err != nil {
-		return nil, nil, s.CreateServiceError(
+		return nil, nil, s.CreateServiceErrorWithDetail(
 			ctx, servererror.UnauthenticatedError, "Fail to authenticate user.", err,
+			servererror.ErrorDetailCodeCredentialsInvalid, nil,
 		)
 	}
 	isPasswordValid := s.isPasswordValid(password, userInfo.Password)
 	if !isPasswordValid {
-		return nil, nil, s.CreateServiceError(
+		return nil, nil, s.CreateServiceErrorWithDetail(
 			ctx, servererror.UnauthenticatedError, "Fail to authenticate user.", err,
+			servererror.ErrorDetailCodeCredentialsInvalid, nil,
 		)
 	}
 	tokenInfo, err := s.generateUserToken(ctx, userInfo)
 	if err != nil {
 		return nil, nil, err
 	}
+	now := time.Now()
+	if err := s.userRepository.UpdateLastLogin(ctx, userInfo.ID, now); err != nil {
+		return nil, nil, s.createInternalError(
+			ctx, servererror.DbError, "Failed to record user login.", err,
+		)
+	}
+	userInfo.LastLoginAt = &now
 	return userInfo, tokenInfo, nil
 }
 
+// UpdateLastSeen records that the user with the given ID was seen now.
+func (s *Service) UpdateLastSeen(ctx context.Context, userID string) error {
+	ctx, span := tracing.StartSpan(ctx, "userservice.UpdateLastSeen")
+	defer span.End()
+	if err := s.userRepository.UpdateLastSeen(ctx, userID, time.Now()); err != nil {
+		return s.createUserNotFoundError(ctx, "Failed to update user last seen time.", err)
+	}
+	return nil
+}
+
 // FindUserByCondition returns the set of users matching the given condition.
 func (s *Service) FindUserByCondition(
 	ctx context.Context, condition *usercommon.Condition) ([]usercommon.User, error) {
-	return s.userRepository.FindUserByCondition(ctx, condition)
+	ctx, span := tracing.StartSpan(ctx, "userservice.FindUserByCondition")
+	defer span.End()
+	users, err := s.userRepository.FindUserByCondition(ctx, condition)
+	if err != nil {
+		return nil, s.createInternalError(ctx, servererror.DbError, "Failed to find users.", err)
+	}
+	return users, nil
 }
 
-//RevokeRefreshToken revokes the given refresh token.
+//RevokeRefreshToken revokes the given refresh token, and the access tokens of
+//its user once the revocation is committed, ending its message streams.
 func (s *Service) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	ctx, span := tracing.StartSpan(ctx, "userservice.RevokeRefreshToken")
+	defer span.End()
 	refreshTokenID, err := token.VerifyToken(refreshToken)
 	if err != nil {
-		return s.CreateServiceError(ctx, servererror.InvalidArguments, "Failed to verify refresh token", err)
+		return s.CreateServiceErrorWithDetail(ctx, servererror.InvalidArguments, "Failed to verify refresh token", err, servererror.ErrorDetailCodeRefreshTokenInvalid, nil)
 	}
 	condition := usercommon.User{
 		RefreshToken: refreshTokenID,
 	}
 	user, err := s.userRepository.FindFirstUser(ctx, condition, []string{})
 	if err != nil {
-		return s.CreateServiceError(ctx, servererror.NotFoundError, "user with specific RefreshToken not found", err)
+		return s.CreateServiceErrorWithDetail(ctx, servererror.NotFoundError, "user with specific RefreshToken not found", err, servererror.ErrorDetailCodeRefreshTokenInvalid, nil)
 	}
+	revokedAt := time.Now()
 	user.RefreshToken = ""
+	user.TokensValidAfter = &revokedAt
 	if err = s.userRepository.UpdateUser(ctx, user); err != nil {
-		return s.CreateServiceError(ctx, servererror.DbError, "failed to update user info", err)
+		return s.createUpdateUserError(ctx, servererror.DbError, "failed to update user info", err)
 	}
+	revokeUserTokens(ctx, user.ID)
 	return nil
 }
 
 //RefreshUserToken refreshes the given token and returns the new token info.
 func (s *Service) RefreshUserToken(ctx context.Context, refreshToken string) (*usercommon.TokenInfo, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.RefreshUserToken")
+	defer span.End()
 	refreshTokenID, err := token.VerifyToken(refreshToken)
 	if err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.InvalidArguments, "Failed to verify refresh token", err)
+		return nil, s.CreateServiceErrorWithDetail(ctx, servererror.InvalidArguments, "Failed to verify refresh token", err, servererror.ErrorDetailCodeRefreshTokenInvalid, nil)
 	}
 	condition := usercommon.User{
 		RefreshToken: refreshTokenID,
 	}
 	user, err := s.userRepository.FindFirstUser(ctx, condition, []string{})
 	if err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.NotFoundError, "user with specific RefreshToken not found", err)
+		return nil, s.CreateServiceErrorWithDetail(ctx, servererror.NotFoundError, "user with specific RefreshToken not found", err, servererror.ErrorDetailCodeRefreshTokenInvalid, nil)
 	}
 	return s.generateUserToken(ctx, user)
 }
 
+// CreateInvite creates an invite code that can be used to register up to
+// maxUses users before the given validity elapses. Zero values are replaced
+// with the configured defaults, which also bound the invites created by users
+// that are not administrators.
+func (s *Service) CreateInvite(
+	ctx context.Context,
+	creatorID string,
+	maxUses int,
+	validity time.Duration) (*usercommon.Invite, error) {
+	ctx, span := tracing.StartSpan(ctx, "userservice.CreateInvite")
+	defer span.End()
+	var violations []servererror.FieldViolation
+	if maxUses < 0 {
+		violations = append(violations, servererror.FieldViolation{Field: "max_uses", Description: "Must not be negative."})
+	}
+	if validity < 0 {
+		violations = append(violations, servererror.FieldViolation{Field: "validity", Description: "Must not be negative."})
+	}
+	if len(violations) != 0 {
+		return nil, s.CreateServiceErrorWithFieldViolations(ctx, servererror.InvalidArguments, "Invalid invite parameters.", nil, servererror.ErrorDetailCodeInviteParametersInvalid, violations)
+	}
+	if maxUses == 0 {
+		maxUses = s.userConfig.InviteMaxUses
+	}
+	if validity == 0 {
+		validity = s.userConfig.InviteValidity
+	}
+	if !s.userConfig.IsAdmin(creatorID) &&
+		(maxUses > s.userConfig.InviteMaxUses || validity > s.userConfig.InviteValidity) {
+		return nil, s.CreateServiceErrorWithDetail(ctx, servererror.PermissionDenied, "Invite parameters exceed the allowed limits.", nil, servererror.ErrorDetailCodeInviteLimitExceeded, nil)
+	}
+
+	invite, err := usercommon.NewInvite(creatorID, maxUses, time.Now().UTC().Add(validity))
+	if err != nil {
+		return nil, s.createInternalError(ctx, servererror.InternalError, "Failed to create invite.", err)
+	}
+	if err := s.userRepository.CreateInvite(ctx, invite); err != nil {
+		return nil, s.createInternalError(ctx, servererror.DbError, "Failed to create invite.", err)
+	}
+	return invite, nil
+}
+
+// RedeemInvite consumes one use of the invite associated with the given code.
+func (s *Service) RedeemInvite(ctx context.Context, code string) error {
+	ctx, span := tracing.StartSpan(ctx, "userservice.RedeemInvite")
+	defer span.End()
+	invite, err := s.userRepository.FindInvite(ctx, code)
+	if err != nil {
+		if !orm.IsRecordNotFoundError(err) {
+			return s.createInternalError(ctx, servererror.DbError, "Failed to find invite.", err)
+		}
+		return s.CreateServiceErrorWithDetail(ctx, servererror.PermissionDenied, "Invalid invite code.", err, servererror.ErrorDetailCodeInviteInvalid, nil)
+	}
+	if !invite.IsRedeemable(time.Now().UTC()) {
+		return s.CreateServiceErrorWithDetail(ctx, servererror.PermissionDenied, "Invite code is expired or used up.", nil, servererror.ErrorDetailCodeInviteExhausted, nil)
+	}
+	if err := s.userRepository.UseInvite(ctx, invite); err != nil {
+		if !orm.IsRecordNotFoundError(err) {
+			return s.createInternalError(ctx, servererror.DbError, "Failed to redeem invite.", err)
+		}
+		return s.CreateServiceErrorWithDetail(ctx, servererror.PermissionDenied, "Invite code is expired or used up.", err, servererror.ErrorDetailCodeInviteExhausted, nil)
+	}
+	return nil
+}
+
 //generateUserToken generates a JWT token for the given user.
 func (s *Service) generateUserToken(ctx context.Context, userInfo *usercommon.User) (*usercommon.TokenInfo, error) {
 	//Generate JWT Token
 	accessToken, expiresIn, err := token.GenerateAccessToken(userInfo.ID)
 	if err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.InternalError, "Failed to generate access token.", err)
+		return nil, s.createInternalError(ctx, servererror.InternalError, "Failed to generate access token.", err)
 	}
 	refreshTokenID := uuid.New().String()
 	refreshToken, err := token.GenerateRefreshToken(refreshTokenID)
 	if err != nil {
-		return nil, s.CreateServiceError(ctx, servererror.InternalError, "Failed to generate refresh token.", err)
+		return nil, s.createInternalError(ctx, servererror.InternalError, "Failed to generate refresh token.", err)
 	}
 	userInfo.RefreshToken = refreshTokenID
 	if err = s.userRepository.UpdateUser(ctx, userInfo); err != nil {
-		return nil, err
+		return nil, s.createUpdateUserError(ctx, servererror.DbError, "Failed to store refresh token.", err)
 	}
 	return &usercommon.TokenInfo{
 		AccessToken:  accessToken,
@@ -309,6 +582,7 @@ func (s *Service) generateUserToken(ctx context.Context, userInfo *usercommon.Us
 }
 
 func (s *Service) isPasswordValid(password, protectedForm string) bool {
+	defer metrics.ObserveSince(metrics.PasswordHashDuration, time.Now())
 	return crypto.IsPasswordValid(
 		password,
 		protectedForm,
@@ -326,6 +600,7 @@ func (s *Service) getPasswordProtectedForm(password string) (string, error) {
 		return "", err
 	}
 
+	defer metrics.ObserveSince(metrics.PasswordHashDuration, time.Now())
 	protectedForm := crypto.GetPasswordProtectedForm(
 		password,
 		salt,
@@ -2,7 +2,9 @@ package userservice_test
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"p2pderivatives-server/internal/common/servererror"
 	"p2pderivatives-server/internal/common/token"
@@ -17,10 +19,33 @@ import (
 func createRepoAndService() (repo *mock_userrepository.RepositoryMock, service *userservice.Service) {
 	repo = mock_userrepository.NewRepositoryMock()
 	config := usercommon.DefaultUserConfiguration()
-	service = userservice.NewService(repo, config, &servererror.ServiceError{})
+	service, _ = userservice.NewService(repo, config, &servererror.ServiceError{})
 	return
 }
 
+func TestNewService_WithInvalidNamePattern_Fails(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	config := usercommon.DefaultUserConfiguration()
+	config.NamePattern = "^[a-z"
+
+	// Act
+	service, err := userservice.NewService(
+		mock_userrepository.NewRepositoryMock(), config, &servererror.ServiceError{})
+
+	// Assert
+	assert.Error(err)
+	assert.Nil(service)
+}
+
+func assertDetailCode(t *testing.T, err error, code servererror.ErrorDetailCode) {
+	t.Helper()
+	serr, ok := err.(*servererror.Error)
+	if assert.True(t, ok) && assert.Len(t, serr.Details, 1) {
+		assert.Equal(t, code, serr.Details[0].Code)
+	}
+}
+
 func initToken() {
 	tokenConfig := token.Config{}
 	conf := test.GetTestConfig()
@@ -167,6 +192,46 @@ func TestService_CreateUserWithInvalidPassword_Fails(t *testing.T) {
 	_, err := service.CreateUser(context.Background(), user)
 
 	assert.Error(t, err)
+	assertDetailCode(t, err, servererror.ErrorDetailCodePasswordInvalid)
+}
+
+func TestService_CreateUserWithReservedName_Fails(t *testing.T) {
+	_, service := createRepoAndService()
+
+	user := &usercommon.User{
+		ID:       "id1",
+		Name:     "Admin",
+		Password: "Pass1@lalalala",
+	}
+
+	_, err := service.CreateUser(context.Background(), user)
+
+	assert.Error(t, err)
+	serr, ok := err.(*servererror.Error)
+	assert.True(t, ok)
+	assert.Equal(t, servererror.InvalidArguments, serr.Code)
+	assertDetailCode(t, err, servererror.ErrorDetailCodeNameInvalid)
+}
+
+func TestService_CreateUserWithSameNameDifferentCase_Fails(t *testing.T) {
+	repo, service := createRepoAndService()
+	mock_userrepository.InsertTestUserData(repo, []*usercommon.User{
+		{ID: "id1", Name: "hoge_taro1", Password: "Pass1"},
+	})
+
+	user := &usercommon.User{
+		ID:       "id2",
+		Name:     "HOGE_Taro1",
+		Password: "Pass1@lalalala",
+	}
+
+	_, err := service.CreateUser(context.Background(), user)
+
+	assert.Error(t, err)
+	serr, ok := err.(*servererror.Error)
+	assert.True(t, ok)
+	assert.Equal(t, servererror.AlreadyExistError, serr.Code)
+	assertDetailCode(t, err, servererror.ErrorDetailCodeNameTaken)
 }
 
 func TestService_UpdateUser(t *testing.T) {
@@ -191,6 +256,7 @@ func TestService_UpdateUser(t *testing.T) {
 		Name:                  "piyo_taro",
 		Password:              orgResults[0].Password,
 		RequireChangePassword: orgResults[0].RequireChangePassword,
+		Version:               orgResults[0].Version + 1,
 	}
 
 	actual, _ := service.UpdateUser(ctx, &usercommon.User{
@@ -215,6 +281,89 @@ func TestService_UpdateUser(t *testing.T) {
 	assert.NotNil(t, updatedResults[0].Password)
 }
 
+func TestService_UpdateUser_StaleVersion_ReturnsConcurrentUpdateError(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	_, service := createRepoAndService()
+	ctx := context.Background()
+	user, _ := service.CreateUser(ctx, usercommon.NewUser("hoge_taro", "P@ssw0rd1"))
+	service.UpdateUser(ctx, &usercommon.User{ID: user.ID, Name: "piyo_taro"})
+
+	// Act
+	_, err := service.UpdateUser(ctx, &usercommon.User{
+		ID:      user.ID,
+		Name:    "fuga_taro",
+		Version: user.Version,
+	})
+
+	// Assert
+	serr, ok := err.(*servererror.Error)
+	assert.True(ok)
+	assert.Equal(servererror.OptimisticLockError, serr.Code)
+	assert.Len(serr.Details, 1)
+	assert.Equal(servererror.ErrorDetailCodeConcurrentUpdate, serr.Details[0].Code)
+	assert.True(serr.Retryable)
+}
+
+// failingUpdateRepository fails the updates of users with the given error.
+type failingUpdateRepository struct {
+	*mock_userrepository.RepositoryMock
+	err error
+}
+
+func (repo *failingUpdateRepository) UpdateUser(
+	ctx context.Context, user *usercommon.User) error {
+	return repo.err
+}
+
+func TestServiceAuthenticateUser_WithConcurrentUpdate_ReturnsConcurrentUpdateError(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo := &failingUpdateRepository{
+		RepositoryMock: mock_userrepository.NewRepositoryMock(),
+		err:            usercommon.ErrOptimisticLock,
+	}
+	service, _ := userservice.NewService(
+		repo, usercommon.DefaultUserConfiguration(), &servererror.ServiceError{})
+	ctx := context.Background()
+	service.CreateUser(ctx, usercommon.NewUser(name, password))
+	initToken()
+
+	// Act
+	_, _, err := service.AuthenticateUser(ctx, name, password)
+
+	// Assert
+	serr, ok := err.(*servererror.Error)
+	if assert.True(ok) {
+		assert.Equal(servererror.OptimisticLockError, serr.Code)
+		assert.True(serr.Retryable)
+	}
+}
+
+func TestServiceAuthenticateUser_WithUpdateFailure_ReturnsDbError(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo := &failingUpdateRepository{
+		RepositoryMock: mock_userrepository.NewRepositoryMock(),
+		err:            errors.New("connection lost"),
+	}
+	service, _ := userservice.NewService(
+		repo, usercommon.DefaultUserConfiguration(), &servererror.ServiceError{})
+	ctx := context.Background()
+	service.CreateUser(ctx, usercommon.NewUser(name, password))
+	initToken()
+
+	// Act
+	_, _, err := service.AuthenticateUser(ctx, name, password)
+
+	// Assert
+	serr, ok := err.(*servererror.Error)
+	if assert.True(ok) {
+		assert.Equal(servererror.DbError, serr.Code)
+		assert.Equal("Failed to store refresh token.", serr.Message)
+	}
+}
+
 func TestService_DeleteUser(t *testing.T) {
 	repo, service := createRepoAndService()
 	ctx := context.Background()
@@ -298,6 +447,7 @@ func TestService_ChangeUserPassword_InvalidPassword_Fails(t *testing.T) {
 
 	assert.Error(err)
 	assert.Nil(newPasswordUser)
+	assertDetailCode(t, err, servererror.ErrorDetailCodePasswordInvalid)
 }
 
 func TestService_ChangeUserPassword_BadOldPassword_Fails(t *testing.T) {
@@ -322,6 +472,35 @@ func TestService_ChangeUserPassword_BadOldPassword_Fails(t *testing.T) {
 
 	assert.Error(err)
 	assert.Nil(newPasswordUser)
+	assertDetailCode(t, err, servererror.ErrorDetailCodeCredentialsInvalid)
+}
+
+func TestService_ChangeUserPassword_UnknownUser_FailsLikeBadOldPassword(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	_, service := createRepoAndService()
+
+	// Act
+	newPasswordUser, err := service.ChangeUserPassword(
+		context.Background(), "unknown", "newP@ssw0rd", "oldP@ssw0rd")
+
+	// Assert
+	assert.Nil(newPasswordUser)
+	assert.EqualError(err, "Failed to update user password")
+	assertDetailCode(t, err, servererror.ErrorDetailCodeCredentialsInvalid)
+}
+
+func TestService_ChangeUserPassword_InvalidPasswordAndBadOldPassword_ReturnsPasswordInvalid(t *testing.T) {
+	// Arrange
+	_, service := createRepoAndService()
+	ctx := context.Background()
+	orgUser, _ := service.CreateUser(ctx, usercommon.NewUser("name1", "oldP@ssw0rd"))
+
+	// Act
+	_, err := service.ChangeUserPassword(ctx, orgUser.ID, "test", "n0tTh3G00dPASS")
+
+	// Assert
+	assertDetailCode(t, err, servererror.ErrorDetailCodePasswordInvalid)
 }
 
 func TestService_ResetUserPassword(t *testing.T) {
@@ -346,6 +525,42 @@ func TestService_ResetUserPassword(t *testing.T) {
 	assert.True(t, newPasswordUser.RequireChangePassword)
 }
 
+func TestService_ResetUserPassword_WithInvalidPassword_Fails(t *testing.T) {
+	// Arrange
+	service, ctx := initTestHelper()
+
+	// Act
+	_, err := service.ResetUserPassword(ctx, name, "test")
+
+	// Assert
+	assertDetailCode(t, err, servererror.ErrorDetailCodePasswordInvalid)
+}
+
+func TestService_ResetUserPassword_WithUnknownUser_Fails(t *testing.T) {
+	// Arrange
+	service, ctx := initTestHelper()
+
+	// Act
+	_, err := service.ResetUserPassword(ctx, "unknown", "newP@ssw0rd")
+
+	// Assert
+	assertDetailCode(t, err, servererror.ErrorDetailCodeUserNotFound)
+}
+
+func TestService_FindFirstUser_WithUnknownID_ReturnsUserNotFound(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	_, service := createRepoAndService()
+
+	// Act
+	_, err := service.FindFirstUser(
+		context.Background(), &usercommon.User{ID: "unknown"}, nil)
+
+	// Assert
+	assert.Equal(servererror.NotFoundError, err.(*servererror.Error).Code)
+	assertDetailCode(t, err, servererror.ErrorDetailCodeUserNotFound)
+}
+
 func TestServiceAuthenticateUser_WithValidPassword_Succeeds(t *testing.T) {
 	// Arrange
 	assert := assert.New(t)
@@ -372,6 +587,20 @@ func TestServiceAuthenticateUser_WithInvalidPassword_Error(t *testing.T) {
 	assert.Error(err)
 	assert.Nil(tokenInfo)
 	assert.Nil(actual)
+	assertDetailCode(t, err, servererror.ErrorDetailCodeCredentialsInvalid)
+}
+
+func TestServiceAuthenticateUser_WithUnknownName_FailsLikeInvalidPassword(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+
+	// Act
+	_, _, err := service.AuthenticateUser(ctx, "unknown", password)
+
+	// Assert
+	assert.EqualError(err, "Fail to authenticate user.")
+	assertDetailCode(t, err, servererror.ErrorDetailCodeCredentialsInvalid)
 }
 
 func TestRevokeRefreshToken_WithCorrectToken_IsRevoked(t *testing.T) {
@@ -389,6 +618,28 @@ func TestRevokeRefreshToken_WithCorrectToken_IsRevoked(t *testing.T) {
 	assert.Empty(user.RefreshToken)
 }
 
+func TestRevokeRefreshToken_WithCorrectToken_RevokesAccessTokens(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+	authenticated, tokenInfo, _ := service.AuthenticateUser(ctx, name, password)
+	revoked, stop := token.UserTokensRevoked(authenticated.ID)
+	defer stop()
+
+	// Act
+	err := service.RevokeRefreshToken(ctx, tokenInfo.RefreshToken)
+	user, _ := service.FindFirstUserByName(ctx, name)
+
+	// Assert
+	assert.NoError(err)
+	assert.NotNil(user.TokensValidAfter)
+	select {
+	case <-revoked:
+	default:
+		assert.Fail("tokens not revoked")
+	}
+}
+
 func TestRefreshUserToken_WithCorrectRefreshToken_IsRefreshed(t *testing.T) {
 	// Arrange
 	assert := assert.New(t)
@@ -405,6 +656,30 @@ func TestRefreshUserToken_WithCorrectRefreshToken_IsRefreshed(t *testing.T) {
 	assert.NotEqual(orgUser.RefreshToken, refreshedUser.RefreshToken)
 }
 
+func TestRefreshUserToken_WithInvalidRefreshToken_Fails(t *testing.T) {
+	// Arrange
+	service, ctx := initTestHelper()
+
+	// Act
+	_, err := service.RefreshUserToken(ctx, "invalid")
+
+	// Assert
+	assertDetailCode(t, err, servererror.ErrorDetailCodeRefreshTokenInvalid)
+}
+
+func TestRefreshUserToken_WithRevokedRefreshToken_Fails(t *testing.T) {
+	// Arrange
+	service, ctx := initTestHelper()
+	_, tokenInfo, _ := service.AuthenticateUser(ctx, name, password)
+	service.RevokeRefreshToken(ctx, tokenInfo.RefreshToken)
+
+	// Act
+	_, err := service.RefreshUserToken(ctx, tokenInfo.RefreshToken)
+
+	// Assert
+	assertDetailCode(t, err, servererror.ErrorDetailCodeRefreshTokenInvalid)
+}
+
 const (
 	name        = "test"
 	password    = "P@assw0rd"
@@ -419,3 +694,290 @@ func initTestHelper() (*userservice.Service, context.Context) {
 	initToken()
 	return service, ctx
 }
+
+func TestServiceAuthenticateUser_WithValidPassword_SetsLastLogin(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+
+	// Act
+	actual, _, err := service.AuthenticateUser(ctx, name, password)
+
+	// Assert
+	assert.NoError(err)
+	stored, _ := service.FindFirstUser(ctx, &usercommon.User{ID: actual.ID}, nil)
+	assert.NotNil(stored.LastLoginAt)
+	assert.Equal(actual.LastLoginAt, stored.LastLoginAt)
+}
+
+func TestServiceUpdateLastSeen_SetsLastSeen(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+	user, _ := service.FindFirstUserByName(ctx, name)
+
+	// Act
+	err := service.UpdateLastSeen(ctx, user.ID)
+
+	// Assert
+	assert.NoError(err)
+	stored, _ := service.FindFirstUser(ctx, &usercommon.User{ID: user.ID}, nil)
+	assert.NotNil(stored.LastSeenAt)
+}
+
+func TestServiceChangeUserPassword_RevokesTokens(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+	user, tokenInfo, _ := service.AuthenticateUser(ctx, name, password)
+
+	// Act
+	_, err := service.ChangeUserPassword(ctx, user.ID, "newP@ssw0rd", password)
+
+	// Assert
+	assert.NoError(err)
+	_, err = token.VerifyToken(tokenInfo.AccessToken)
+	assert.Equal(token.ErrTokenRevoked, err)
+	_, err = service.RefreshUserToken(ctx, tokenInfo.RefreshToken)
+	assert.Error(err)
+}
+
+func TestServiceDeleteUser_RevokesTokens(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+	user, tokenInfo, _ := service.AuthenticateUser(ctx, name, password)
+
+	// Act
+	err := service.DeleteUser(ctx, user)
+
+	// Assert
+	assert.NoError(err)
+	_, err = token.VerifyToken(tokenInfo.AccessToken)
+	assert.Equal(token.ErrTokenRevoked, err)
+	service.RestoreUser(ctx, name)
+	_, err = service.RefreshUserToken(ctx, tokenInfo.RefreshToken)
+	assert.Error(err)
+}
+
+func TestServiceCreateInvite_WithDefaults_Succeeds(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+	config := usercommon.DefaultUserConfiguration()
+
+	// Act
+	invite, err := service.CreateInvite(ctx, "user-id", 0, 0)
+
+	// Assert
+	assert.NoError(err)
+	assert.NotEmpty(invite.Code)
+	assert.Equal(config.InviteMaxUses, invite.MaxUses)
+	assert.True(invite.ExpiresAt.After(time.Now()))
+}
+
+func TestServiceCreateInvite_ExceedingLimitsAsUser_Fails(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+
+	// Act
+	invite, err := service.CreateInvite(ctx, "user-id", 10, 0)
+
+	// Assert
+	assert.Nil(invite)
+	assert.Error(err)
+	assert.Equal(servererror.PermissionDenied, err.(*servererror.Error).Code)
+	assertDetailCode(t, err, servererror.ErrorDetailCodeInviteLimitExceeded)
+}
+
+func TestServiceCreateInvite_WithNegativeParameters_Fails(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+
+	// Act
+	_, err := service.CreateInvite(ctx, "user-id", -1, -time.Hour)
+
+	// Assert
+	assert.Len(err.(*servererror.Error).FieldViolations, 2)
+	assertDetailCode(t, err, servererror.ErrorDetailCodeInviteParametersInvalid)
+}
+
+func TestServiceCreateInvite_ExceedingLimitsAsAdmin_Succeeds(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo := mock_userrepository.NewRepositoryMock()
+	config := usercommon.DefaultUserConfiguration()
+	config.AdminIDs = []string{"admin-id"}
+	service, _ := userservice.NewService(repo, config, &servererror.ServiceError{})
+
+	// Act
+	invite, err := service.CreateInvite(
+		context.Background(), "admin-id", 10, 30*24*time.Hour)
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(10, invite.MaxUses)
+}
+
+func TestServiceRedeemInvite_UntilUsedUp_Fails(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+	invite, _ := service.CreateInvite(ctx, "user-id", 1, 0)
+
+	// Act
+	err1 := service.RedeemInvite(ctx, invite.Code)
+	err2 := service.RedeemInvite(ctx, invite.Code)
+
+	// Assert
+	assert.NoError(err1)
+	assert.Error(err2)
+	assert.Equal(servererror.PermissionDenied, err2.(*servererror.Error).Code)
+	assertDetailCode(t, err2, servererror.ErrorDetailCodeInviteExhausted)
+}
+
+func TestServiceRedeemInvite_WithUnknownCode_Fails(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+
+	// Act
+	err := service.RedeemInvite(ctx, "unknown")
+
+	// Assert
+	assert.Error(err)
+	assert.Equal(servererror.PermissionDenied, err.(*servererror.Error).Code)
+	assertDetailCode(t, err, servererror.ErrorDetailCodeInviteInvalid)
+}
+
+func TestServiceCreateUser_WithNameOfDeletedUser_Fails(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+	user, _ := service.FindFirstUserByName(ctx, name)
+	service.DeleteUser(ctx, user)
+
+	// Act
+	_, err := service.CreateUser(ctx, usercommon.NewUser(name, password))
+
+	// Assert
+	assert.Error(err)
+	serr, ok := err.(*servererror.Error)
+	assert.True(ok)
+	assert.Equal(servererror.AlreadyExistError, serr.Code)
+	assertDetailCode(t, err, servererror.ErrorDetailCodeNameTaken)
+}
+
+func TestServiceRestoreUser_WithinGracePeriod_Succeeds(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	service, ctx := initTestHelper()
+	user, _ := service.FindFirstUserByName(ctx, name)
+	service.DeleteUser(ctx, user)
+
+	// Act
+	restored, err := service.RestoreUser(ctx, name)
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(user.ID, restored.ID)
+	_, err = service.FindFirstUserByName(ctx, name)
+	assert.NoError(err)
+}
+
+func TestServiceRestoreUser_AfterGracePeriod_Fails(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo := mock_userrepository.NewRepositoryMock()
+	config := usercommon.DefaultUserConfiguration()
+	config.DeletionGracePeriod = 0
+	service, _ := userservice.NewService(repo, config, &servererror.ServiceError{})
+	ctx := context.Background()
+	user := usercommon.NewUser(name, password)
+	service.CreateUser(ctx, user)
+	service.DeleteUser(ctx, user)
+
+	// Act
+	_, err := service.RestoreUser(ctx, name)
+
+	// Assert
+	assert.Error(err)
+	serr, ok := err.(*servererror.Error)
+	assert.True(ok)
+	assert.Equal(servererror.PreconditionError, serr.Code)
+	assertDetailCode(t, err, servererror.ErrorDetailCodeRestorationExpired)
+}
+
+func TestServiceRestoreUser_WithUnknownName_Fails(t *testing.T) {
+	// Arrange
+	service, ctx := initTestHelper()
+
+	// Act
+	_, err := service.RestoreUser(ctx, "unknown")
+
+	// Assert
+	assertDetailCode(t, err, servererror.ErrorDetailCodeUserNotFound)
+}
+
+func TestServiceUpdateLastSeen_WithUnknownUser_Fails(t *testing.T) {
+	// Arrange
+	service, ctx := initTestHelper()
+
+	// Act
+	err := service.UpdateLastSeen(ctx, "unknown")
+
+	// Assert
+	assertDetailCode(t, err, servererror.ErrorDetailCodeUserNotFound)
+}
+
+// failingRepository fails to purge users.
+type failingRepository struct {
+	*mock_userrepository.RepositoryMock
+}
+
+func (repo *failingRepository) PurgeUsers(
+	ctx context.Context, deletedBefore, reservedUntil time.Time) ([]usercommon.User, error) {
+	return nil, errors.New("database is unavailable")
+}
+
+func TestServicePurgeDeletedUsers_WithDbError_ReturnsUnknown(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo := &failingRepository{RepositoryMock: mock_userrepository.NewRepositoryMock()}
+	service, _ := userservice.NewService(
+		repo, usercommon.DefaultUserConfiguration(), &servererror.ServiceError{})
+
+	// Act
+	_, err := service.PurgeDeletedUsers(context.Background())
+
+	// Assert
+	assert.Equal(servererror.DbError, err.(*servererror.Error).Code)
+	assertDetailCode(t, err, servererror.ErrorDetailCodeUnknown)
+}
+
+func TestServicePurgeDeletedUsers_AfterGracePeriod_ReservesName(t *testing.T) {
+	// Arrange
+	assert := assert.New(t)
+	repo := mock_userrepository.NewRepositoryMock()
+	config := usercommon.DefaultUserConfiguration()
+	config.DeletionGracePeriod = 0
+	service, _ := userservice.NewService(repo, config, &servererror.ServiceError{})
+	ctx := context.Background()
+	user := usercommon.NewUser(name, password)
+	service.CreateUser(ctx, user)
+	service.DeleteUser(ctx, user)
+
+	// Act
+	purged, err := service.PurgeDeletedUsers(ctx)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(purged, 1)
+	assert.Equal(user.ID, purged[0].ID)
+	_, err = service.RestoreUser(ctx, name)
+	assert.Error(err)
+	_, err = service.CreateUser(ctx, usercommon.NewUser(name, password))
+	assert.Error(err)
+}
@@ -1,6 +1,8 @@
 package userservice
 
 import (
+	"p2pderivatives-server/internal/user/usercommon"
+	"regexp"
 	"unicode"
 )
 
@@ -38,3 +40,23 @@ func isSpecialCharacter(c rune) bool {
 	}
 	return false
 }
+
+// VerifyNewName checks that the provided name satisfies the naming policy and
+// returns its normalized form.
+func VerifyNewName(
+	name string, pattern *regexp.Regexp, reservedNames []string) (string, bool) {
+	normalized, err := usercommon.NormalizeName(name)
+	if err != nil {
+		return "", false
+	}
+	if pattern != nil && !pattern.MatchString(normalized) {
+		return "", false
+	}
+	for _, reserved := range reservedNames {
+		normalizedReserved, err := usercommon.NormalizeName(reserved)
+		if err == nil && normalizedReserved == normalized {
+			return "", false
+		}
+	}
+	return normalized, true
+}
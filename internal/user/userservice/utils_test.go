@@ -1,6 +1,7 @@
 package userservice
 
 import (
+	"regexp"
 	"testing"
 )
 
@@ -28,3 +29,30 @@ func TestUser_VerifyNewPassword(t *testing.T) {
 		})
 	}
 }
+
+func TestUser_VerifyNewName(t *testing.T) {
+	pattern := regexp.MustCompile("^[a-z0-9][a-z0-9_.-]*$")
+	reserved := []string{"admin", "root"}
+	testCases := []struct {
+		name     string
+		testName string
+		isOk     bool
+	}{
+		{name: "OK           :", testName: "alice", isOk: true},
+		{name: "Upper case   :", testName: "Alice", isOk: true},
+		{name: "Reserved     :", testName: "admin", isOk: false},
+		{name: "Reserved case:", testName: "Root", isOk: false},
+		{name: "Bad start    :", testName: "_alice", isOk: false},
+		{name: "Space        :", testName: "alice bob", isOk: false},
+		{name: "Empty        :", testName: "", isOk: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, actual := VerifyNewName(tc.testName, pattern, reserved)
+			if tc.isOk != actual {
+				t.Errorf("Fail %s isOk=%v", tc.testName, tc.isOk)
+			}
+		})
+	}
+}
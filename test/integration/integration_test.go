@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 	"p2pderivatives-server/internal/authentication"
+	"p2pderivatives-server/internal/common/servererror"
 	"p2pderivatives-server/internal/common/token"
 	"p2pderivatives-server/internal/user/usercommon"
 	"p2pderivatives-server/internal/user/usercontroller"
@@ -79,7 +80,7 @@ func TestIntegration(t *testing.T) {
 	assertMessaging(
 		assert, userClient1, userClient2, user1, user2, accessToken1, accessToken2)
 
-	assertUpdatePassword(assert, authClient1, accessToken1)
+	accessToken1 = assertUpdatePassword(assert, authClient1, accessToken1)
 
 	assertUserUnregister(assert, userClient2, user2, accessToken2)
 
@@ -149,7 +150,12 @@ func assertFailedLogin(
 	if ok {
 		assert.Equal(codes.Unauthenticated, statusErr.Code())
 		assert.Equal("Fail to authenticate user.", statusErr.Message())
-		assert.Empty(statusErr.Details())
+		// The same detail code is returned whether the name or the password
+		// is wrong.
+		details, _ := servererror.DecodeStatusDetails(err)
+		assert.Equal([]servererror.ErrorDetail{
+			{Code: servererror.ErrorDetailCodeCredentialsInvalid}}, details.Details)
+		assert.Equal("Fail to authenticate user.", details.LocalizedMessage)
 	}
 }
 
@@ -255,7 +261,7 @@ func assertMessaging(
 }
 
 func assertUpdatePassword(
-	assert *assert.Assertions, authClient authentication.AuthenticationClient, accessToken string) {
+	assert *assert.Assertions, authClient authentication.AuthenticationClient, accessToken string) string {
 
 	ctx := metadata.AppendToOutgoingContext(
 		context.Background(), token.MetaKeyAuthentication, accessToken)
@@ -268,9 +274,14 @@ func assertUpdatePassword(
 
 	assert.NoError(err)
 
+	// The tokens issued before the password change are revoked.
+	_, err = authClient.UpdatePassword(ctx, request)
+
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+
 	loginRequest := &authentication.LoginRequest{Name: user1.Name, Password: newPassword}
 
-	_, err = authClient.Login(context.Background(), loginRequest)
+	loginResponse, err := authClient.Login(context.Background(), loginRequest)
 
 	assert.NoError(err)
 
@@ -279,6 +290,8 @@ func assertUpdatePassword(
 	_, err = authClient.Login(context.Background(), loginRequest)
 
 	assert.Error(err)
+
+	return loginResponse.Token.AccessToken
 }
 
 func assertGetConnectedUser(assert *assert.Assertions, serverAddress string, client usercontroller.UserClient, accessToken string) {
@@ -327,6 +340,7 @@ func assertGetConnectedUser(assert *assert.Assertions, serverAddress string, cli
 
 	// assert
 	assert.NoError(err)
+	time.Sleep(10 * time.Millisecond)
 	userNames = getConnectedUser(assert, client, ctx)
 	assert.NotContains(userNames, shutdownUser.Name)
 	assert.Contains(userNames, user3.Name)
@@ -3,19 +3,29 @@ package mock_userrepository
 import (
 	context "context"
 	"errors"
-	"p2pderivatives-server/internal/common/servererror"
 	"p2pderivatives-server/internal/user/usercommon"
 	"sort"
+	"time"
+
+	"gorm.io/gorm"
 )
 
 // RepositoryMock is a mock for the usercommon.RepositoryIf interface.
 type RepositoryMock struct {
-	storage map[string]*usercommon.User
+	storage      map[string]*usercommon.User
+	deleted      map[string]*usercommon.User
+	invites      map[string]*usercommon.Invite
+	reservations map[string]time.Time
 }
 
 // NewRepositoryMock creates a new RepositoryMock instance.
 func NewRepositoryMock() *RepositoryMock {
-	return &RepositoryMock{storage: make(map[string]*usercommon.User)}
+	return &RepositoryMock{
+		storage:      make(map[string]*usercommon.User),
+		deleted:      make(map[string]*usercommon.User),
+		invites:      make(map[string]*usercommon.Invite),
+		reservations: make(map[string]time.Time),
+	}
 }
 
 // CountUsers return the number of user matching the given condition.
@@ -25,6 +35,9 @@ func (repo *RepositoryMock) CountUsers(ctx context.Context, condition interface{
 
 // CreateUser creates a new usercommon.
 func (repo *RepositoryMock) CreateUser(ctx context.Context, user *usercommon.User) error {
+	user.Version = 1
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
 	repo.storage[user.ID] = makeUserCopy(user)
 	return nil
 }
@@ -37,9 +50,16 @@ func (repo *RepositoryMock) CreateUsers(ctx context.Context, users []*usercommon
 	return nil
 }
 
-// DeleteUser deletes a usercommon.
+// DeleteUser soft deletes a usercommon.
 func (repo *RepositoryMock) DeleteUser(ctx context.Context, user *usercommon.User) error {
-	delete(repo.storage, user.ID)
+	if stored, ok := repo.storage[user.ID]; ok {
+		now := time.Now()
+		stored.RefreshToken = ""
+		stored.TokensValidAfter = &now
+		stored.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+		repo.deleted[user.ID] = stored
+		delete(repo.storage, user.ID)
+	}
 	return nil
 }
 
@@ -64,6 +84,8 @@ func (repo *RepositoryMock) FindFirstUser(
 			return repo.FindFirstUserByName(ctx, condition)
 		} else if model.RefreshToken != "" {
 			return repo.FindFirstUserByRefreshToken(ctx, condition)
+		} else if model.NormalizedName != nil {
+			return repo.FindFirstUserByNormalizedName(ctx, condition)
 		}
 		panic("No implemented.")
 	}
@@ -71,7 +93,7 @@ func (repo *RepositoryMock) FindFirstUser(
 	result, ok := repo.storage[model.ID]
 
 	if !ok {
-		return nil, servererror.NewError(servererror.NotFoundError, "Not Found", nil)
+		return nil, gorm.ErrRecordNotFound
 	}
 
 	return result, nil
@@ -96,7 +118,7 @@ func (repo *RepositoryMock) FindFirstUserByName(
 		}
 	}
 
-	return nil, errors.New("Not found")
+	return nil, gorm.ErrRecordNotFound
 }
 
 // FindFirstUserByRefreshToken return the user matching the given condition.
@@ -118,12 +140,61 @@ func (repo *RepositoryMock) FindFirstUserByRefreshToken(
 		}
 	}
 
-	return nil, errors.New("Not found")
+	return nil, gorm.ErrRecordNotFound
+}
+
+// FindFirstUserByNormalizedName return the user matching the given condition.
+func (repo *RepositoryMock) FindFirstUserByNormalizedName(
+	ctx context.Context, condition interface{}) (*usercommon.User, error) {
+
+	query, ok := condition.(usercommon.User)
+	if !ok {
+		query = *condition.(*usercommon.User)
+	}
+
+	if query.NormalizedName == nil {
+		panic("No normalized name in query.")
+	}
+
+	for _, user := range repo.storage {
+		normalized, err := usercommon.NormalizeName(user.Name)
+		if err == nil && normalized == *query.NormalizedName {
+			return makeUserCopy(user), nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
 }
 
-// FindUserByCondition is not implemented.
+// FindUserByCondition returns the users matching the name and inactivity
+// conditions, ordered by ID.
 func (repo *RepositoryMock) FindUserByCondition(ctx context.Context, condition *usercommon.Condition) (result []usercommon.User, err error) {
-	panic("Not implemented")
+	users, _ := repo.GetAllUsers(ctx)
+	sort.Sort(ByName{users: users})
+	result = make([]usercommon.User, 0)
+	for _, user := range users {
+		if condition.Name != "" && user.Name != condition.Name {
+			continue
+		}
+		if !condition.InactiveSince.IsZero() {
+			lastActivity := user.CreatedAt
+			if user.LastLoginAt != nil {
+				lastActivity = *user.LastLoginAt
+			}
+			if !lastActivity.Before(condition.InactiveSince) {
+				continue
+			}
+		}
+		result = append(result, user)
+	}
+	if condition.Offset >= len(result) {
+		return result[:0], nil
+	}
+	result = result[condition.Offset:]
+	if condition.Limit > 0 && condition.Limit < len(result) {
+		result = result[:condition.Limit]
+	}
+	return result, nil
 }
 
 // ByName struct to order users by name.
@@ -177,8 +248,21 @@ func (repo *RepositoryMock) GetAllUsers(ctx context.Context) ([]usercommon.User,
 	return users, nil
 }
 
-// UpdateUser updates user data.
+// UpdateUser updates user data, failing with usercommon.ErrOptimisticLock
+// if the stored user has another version.
 func (repo *RepositoryMock) UpdateUser(ctx context.Context, user *usercommon.User) error {
+	if stored, ok := repo.storage[user.ID]; ok && stored.Version != user.Version {
+		return usercommon.ErrOptimisticLock
+	}
+	user.Version++
+	if stored, ok := repo.storage[user.ID]; ok {
+		user.CreatedAt = stored.CreatedAt
+		user.LastLoginAt = stored.LastLoginAt
+		user.LastSeenAt = stored.LastSeenAt
+		if user.TokensValidAfter == nil {
+			user.TokensValidAfter = stored.TokensValidAfter
+		}
+	}
 	repo.storage[user.ID] = makeUserCopy(user)
 	return nil
 }
@@ -192,6 +276,137 @@ func (repo *RepositoryMock) UpdateUsers(ctx context.Context, users []*usercommon
 	return nil
 }
 
+// UpdateLastLogin sets the last login time of a user.
+func (repo *RepositoryMock) UpdateLastLogin(
+	ctx context.Context, userID string, at time.Time) error {
+	stored, ok := repo.storage[userID]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	stored.LastLoginAt = &at
+	return nil
+}
+
+// UpdateLastSeen sets the last seen time of a user.
+func (repo *RepositoryMock) UpdateLastSeen(
+	ctx context.Context, userID string, at time.Time) error {
+	stored, ok := repo.storage[userID]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	stored.LastSeenAt = &at
+	return nil
+}
+
+// IsNameTaken returns whether a user, deleted or not, has the given
+// normalized name or if the name is reserved.
+func (repo *RepositoryMock) IsNameTaken(
+	ctx context.Context, normalizedName string, now time.Time) (bool, error) {
+	for _, users := range []map[string]*usercommon.User{repo.storage, repo.deleted} {
+		for _, user := range users {
+			normalized, err := usercommon.NormalizeName(user.Name)
+			if err == nil && normalized == normalizedName {
+				return true, nil
+			}
+		}
+	}
+	expiresAt, ok := repo.reservations[normalizedName]
+	return ok && expiresAt.After(now), nil
+}
+
+// FindDeletedUser returns the deleted user with the given normalized name.
+func (repo *RepositoryMock) FindDeletedUser(
+	ctx context.Context, normalizedName string) (*usercommon.User, error) {
+	for _, user := range repo.deleted {
+		normalized, err := usercommon.NormalizeName(user.Name)
+		if err == nil && normalized == normalizedName {
+			return makeUserCopy(user), nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// RestoreUser restores a deleted user.
+func (repo *RepositoryMock) RestoreUser(ctx context.Context, user *usercommon.User) error {
+	stored, ok := repo.deleted[user.ID]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	stored.DeletedAt = gorm.DeletedAt{}
+	user.DeletedAt = gorm.DeletedAt{}
+	repo.storage[user.ID] = stored
+	delete(repo.deleted, user.ID)
+	return nil
+}
+
+// PurgeUsers permanently deletes the users deleted before the given time.
+func (repo *RepositoryMock) PurgeUsers(
+	ctx context.Context,
+	deletedBefore time.Time,
+	reservedUntil time.Time) ([]usercommon.User, error) {
+	users := make([]usercommon.User, 0)
+	for id, user := range repo.deleted {
+		if !user.DeletedAt.Time.Before(deletedBefore) {
+			continue
+		}
+		if normalized, err := usercommon.NormalizeName(user.Name); err == nil {
+			if _, ok := repo.reservations[normalized]; !ok {
+				repo.reservations[normalized] = reservedUntil
+			}
+		}
+		for code, invite := range repo.invites {
+			if invite.CreatorID == id {
+				delete(repo.invites, code)
+			}
+		}
+		delete(repo.deleted, id)
+		users = append(users, *user)
+	}
+	return users, nil
+}
+
+// DeleteExpiredNameReservations deletes the expired name reservations.
+func (repo *RepositoryMock) DeleteExpiredNameReservations(
+	ctx context.Context, now time.Time) error {
+	for name, expiresAt := range repo.reservations {
+		if !expiresAt.After(now) {
+			delete(repo.reservations, name)
+		}
+	}
+	return nil
+}
+
+// FindInvite returns the invite with the given code.
+func (repo *RepositoryMock) FindInvite(
+	ctx context.Context, code string) (*usercommon.Invite, error) {
+	invite, ok := repo.invites[code]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copy := *invite
+	return &copy, nil
+}
+
+// CreateInvite stores the given invite.
+func (repo *RepositoryMock) CreateInvite(
+	ctx context.Context, invite *usercommon.Invite) error {
+	copy := *invite
+	repo.invites[invite.Code] = &copy
+	return nil
+}
+
+// UseInvite increments the number of uses of the given invite.
+func (repo *RepositoryMock) UseInvite(
+	ctx context.Context, invite *usercommon.Invite) error {
+	stored, ok := repo.invites[invite.Code]
+	if !ok || stored.Uses >= stored.MaxUses {
+		return gorm.ErrRecordNotFound
+	}
+	stored.Uses++
+	invite.Uses = stored.Uses
+	return nil
+}
+
 // InsertTestUserData inserts users directly in the repository.
 func InsertTestUserData(repo *RepositoryMock, models []*usercommon.User) {
 	for _, model := range models {
@@ -206,5 +421,13 @@ func makeUserCopy(model *usercommon.User) *usercommon.User {
 		Password:              model.Password,
 		RequireChangePassword: model.RequireChangePassword,
 		RefreshToken:          model.RefreshToken,
+		NormalizedName:        model.NormalizedName,
+		Version:               model.Version,
+		CreatedAt:             model.CreatedAt,
+		UpdatedAt:             model.UpdatedAt,
+		LastLoginAt:           model.LastLoginAt,
+		LastSeenAt:            model.LastSeenAt,
+		DeletedAt:             model.DeletedAt,
+		TokensValidAfter:      model.TokensValidAfter,
 	}
 }
@@ -3,6 +3,10 @@ package mock_userservice
 import (
 	"context"
 	"errors"
+	"time"
+
+	"p2pderivatives-server/internal/common/servererror"
+	"p2pderivatives-server/internal/common/token"
 	"p2pderivatives-server/internal/user/usercommon"
 	"p2pderivatives-server/internal/user/userservice"
 	"p2pderivatives-server/test/mocks/mock_userrepository"
@@ -35,13 +39,23 @@ func (service *ServiceMock) CreateUser(
 // FindFirstUser finds a user
 func (service *ServiceMock) FindFirstUser(
 	ctx context.Context, condition *usercommon.User, orders []string) (*usercommon.User, error) {
-	return service.repo.FindFirstUser(ctx, condition, orders)
+	return findResult(service.repo.FindFirstUser(ctx, condition, orders))
 }
 
 // FindFirstUserByName finds the user based on account.
 func (service *ServiceMock) FindFirstUserByName(
 	ctx context.Context, name string) (*usercommon.User, error) {
-	return service.repo.FindFirstUserByName(ctx, &usercommon.User{Name: name})
+	return findResult(service.repo.FindFirstUserByName(ctx, &usercommon.User{Name: name}))
+}
+
+// findResult returns the given user, or a user not found error like the
+// service if it was not found.
+func findResult(user *usercommon.User, err error) (*usercommon.User, error) {
+	if err != nil {
+		return nil, servererror.NewErrorWithDetail(servererror.NotFoundError,
+			"User is not found.", err, servererror.ErrorDetailCodeUserNotFound, nil)
+	}
+	return user, nil
 }
 
 // FindUsers find users.
@@ -68,7 +82,11 @@ func (service *ServiceMock) UpdateUser(
 // DeleteUser deletes a usercommon.
 func (service *ServiceMock) DeleteUser(
 	ctx context.Context, condition *usercommon.User) error {
-	return service.repo.DeleteUser(ctx, condition)
+	if err := service.repo.DeleteUser(ctx, condition); err != nil {
+		return err
+	}
+	token.RevokeUserTokens(condition.ID)
+	return nil
 }
 
 // AuthenticateUser authenticates a usercommon.
@@ -85,7 +103,7 @@ func (service *ServiceMock) AuthenticateUser(
 // FindUserByCondition finds a user by condition.
 func (service *ServiceMock) FindUserByCondition(
 	ctx context.Context, condition *usercommon.Condition) ([]usercommon.User, error) {
-	panic("Not implemented")
+	return service.repo.FindUserByCondition(ctx, condition)
 }
 
 // ChangeUserPassword changes a user password.
@@ -106,6 +124,7 @@ func (service *ServiceMock) ChangeUserPassword(
 	if err != nil {
 		return nil, err
 	}
+	token.RevokeUserTokens(userModel.ID)
 
 	return userModel, nil
 }
@@ -120,3 +139,51 @@ func (service *ServiceMock) RefreshUserToken(
 func (service *ServiceMock) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
 	panic("Not implemented")
 }
+
+// CreateInvite creates an invite.
+func (service *ServiceMock) CreateInvite(
+	ctx context.Context,
+	creatorID string,
+	maxUses int,
+	validity time.Duration) (*usercommon.Invite, error) {
+	invite, err := usercommon.NewInvite(creatorID, maxUses, time.Now().Add(validity))
+	if err != nil {
+		return nil, err
+	}
+	return invite, service.repo.CreateInvite(ctx, invite)
+}
+
+// RedeemInvite consumes one use of an invite.
+func (service *ServiceMock) RedeemInvite(ctx context.Context, code string) error {
+	invite, err := service.repo.FindInvite(ctx, code)
+	if err != nil || !invite.IsRedeemable(time.Now()) {
+		return servererror.NewErrorWithDetail(
+			servererror.PermissionDenied, "Invalid invite code.", err,
+			servererror.ErrorDetailCodeInviteInvalid, nil)
+	}
+	return service.repo.UseInvite(ctx, invite)
+}
+
+// UpdateLastSeen records that a user was seen now.
+func (service *ServiceMock) UpdateLastSeen(ctx context.Context, userID string) error {
+	return service.repo.UpdateLastSeen(ctx, userID, time.Now())
+}
+
+// RestoreUser restores a deleted user.
+func (service *ServiceMock) RestoreUser(ctx context.Context, name string) (*usercommon.User, error) {
+	normalized, err := usercommon.NormalizeName(name)
+	if err != nil {
+		return nil, err
+	}
+	user, err := service.repo.FindDeletedUser(ctx, normalized)
+	if err != nil {
+		return nil, servererror.NewErrorWithDetail(servererror.NotFoundError, "Not Found", err,
+			servererror.ErrorDetailCodeUserNotFound, nil)
+	}
+	return user, service.repo.RestoreUser(ctx, user)
+}
+
+// PurgeDeletedUsers is not implemented.
+func (service *ServiceMock) PurgeDeletedUsers(ctx context.Context) ([]usercommon.User, error) {
+	panic("Not implemented")
+}
@@ -71,6 +71,12 @@ func (controller *Controller) TestReadWriteTxOption(
 	return &Empty{}, nil
 }
 
+// TestSerializableIsolation method with serializable isolation level.
+func (controller *Controller) TestSerializableIsolation(
+	ctx context.Context, empty *Empty) (*Empty, error) {
+	return &Empty{}, nil
+}
+
 // TestReadOnlyTxOption method with read-only tx option.
 func (controller *Controller) TestReadOnlyTxOption(
 	ctx context.Context, empty *Empty) (*Empty, error) {
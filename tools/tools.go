@@ -7,6 +7,8 @@ import (
 	_ "github.com/golang/mock/gomock"
 	_ "github.com/golang/mock/mockgen"
 	_ "github.com/golang/protobuf/protoc-gen-go"
+	_ "github.com/grpc-ecosystem/grpc-gateway/v2/protoc-gen-grpc-gateway"
+	_ "github.com/grpc-ecosystem/grpc-gateway/v2/protoc-gen-openapiv2"
 	_ "github.com/mwitkow/go-proto-validators/protoc-gen-govalidators"
 	_ "gotest.tools/gotestsum"
 )